@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Defaults of ArtifactsConfig.
+const (
+	defaultArtifactsDir    = "./artifacts"
+	defaultArtifactMaxSize = 100 // MB
+)
+
+// artifact is one stored file of a job, as listed by the API.
+type artifact struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// artifactsDir returns the directory holding the artifacts of a job.
+func (s *Server) artifactsDir(jobName string) string {
+	dir := s.config.Artifacts.Dir
+	if dir == "" {
+		dir = defaultArtifactsDir
+	}
+	return filepath.Join(dir, jobName)
+}
+
+// artifactPath resolves an artifact name (a relative, slash-separated path) to
+// its file, rejecting names that would escape the job's directory.
+func (s *Server) artifactPath(jobName, name string) (string, error) {
+	if jobName == "" || jobName != path.Base(jobName) || jobName == ".." {
+		return "", fmt.Errorf("invalid job name")
+	}
+	cleaned := path.Clean("/" + name)[1:]
+	if cleaned == "" || cleaned != strings.TrimPrefix(name, "./") {
+		return "", fmt.Errorf("invalid artifact name: %q", name)
+	}
+	return filepath.Join(s.artifactsDir(jobName), filepath.FromSlash(cleaned)), nil
+}
+
+// handleUploadArtifact stores the request body as the artifact ?name= of a
+// job; the runner's upload-artifact command sends one request per file.
+func (s *Server) handleUploadArtifact(c *gin.Context) {
+	jobName := c.Param("jobName")
+	if _, err := s.repo.GetJobByName(jobName); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	file, err := s.artifactPath(jobName, c.Query("name"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	maxSize := s.config.Artifacts.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultArtifactMaxSize
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Write next to the target and rename, so a failed upload leaves no partial file
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".upload-*")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxSize)<<20))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("artifact exceeds %d MB", maxSize)})
+		return
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// handleListArtifacts lists the artifacts of a job.
+func (s *Server) handleListArtifacts(c *gin.Context) {
+	jobName := c.Param("jobName")
+	if jobName != path.Base(jobName) || jobName == ".." {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job name"})
+		return
+	}
+	root := s.artifactsDir(jobName)
+	artifacts := []artifact{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		artifacts = append(artifacts, artifact{Name: filepath.ToSlash(rel), Size: info.Size(), ModifiedAt: info.ModTime()})
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"artifacts": artifacts})
+}
+
+// handleDownloadArtifact serves one artifact of a job as an attachment.
+func (s *Server) handleDownloadArtifact(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("name"), "/")
+	file, err := s.artifactPath(c.Param("jobName"), name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if info, err := os.Stat(file); err != nil || info.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "artifact not found"})
+		return
+	}
+	c.FileAttachment(file, path.Base(name))
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"neutron/internal/model"
+)
+
+// TestArtifactPath verifies that artifact names stay inside the job's
+// directory.
+func TestArtifactPath(t *testing.T) {
+	s := &Server{config: model.Config{Artifacts: model.ArtifactsConfig{Dir: "/data"}}}
+	for name, want := range map[string]string{
+		"app.tar.gz":     "/data/job-1/app.tar.gz",
+		"./dist/app":     "/data/job-1/dist/app",
+		"reports/a.html": "/data/job-1/reports/a.html",
+	} {
+		got, err := s.artifactPath("job-1", name)
+		if err != nil || got != filepath.FromSlash(want) {
+			t.Errorf("artifactPath(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"", ".", "../x", "dist/../../x", "/etc/passwd", "a//b"} {
+		if got, err := s.artifactPath("job-1", name); err == nil {
+			t.Errorf("artifactPath(%q) = %q, want error", name, got)
+		}
+	}
+	if _, err := s.artifactPath("..", "x"); err == nil {
+		t.Error("artifactPath accepted job name ..")
+	}
+}
@@ -3,11 +3,14 @@ package main
 import (
 	"fmt"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"neutron/internal/model"
+	"neutron/internal/platform"
 )
 
 // loadConfig reads the YAML config file (path from NEUTRON_CONFIG, default
@@ -27,9 +30,28 @@ func loadConfig() (model.Config, error) {
 	}
 
 	applyEnvOverrides(&config)
+	if cache := config.Kubernetes.GitCache; cache != nil && (cache.Pvc == "") == (cache.HostPath == "") {
+		return config, fmt.Errorf("kubernetes.git-cache needs exactly one of pvc and host-path")
+	}
+	for id := range config.BaseConfig {
+		cb, _ := config.Codebase(id)
+		signed := slices.ContainsFunc(signedWebhookPlatforms, func(name string) bool { return strings.EqualFold(name, cb.Type) })
+		if signed && cb.WebhookSecret == "" {
+			return config, fmt.Errorf("codebase %s: webhook_secret is required to verify %s webhooks", id, cb.Type)
+		}
+	}
+	for name, max := range map[string]string{"max-cpu": config.Kubernetes.Policy.MaxCpu, "max-memory": config.Kubernetes.Policy.MaxMemory} {
+		if _, err := resource.ParseQuantity(max); max != "" && err != nil {
+			return config, fmt.Errorf("invalid kubernetes.policy.%s %q: %w", name, max, err)
+		}
+	}
 	return config, nil
 }
 
+// signedWebhookPlatforms verify webhooks by a signature made with the
+// instance's webhook_secret, which is therefore required.
+var signedWebhookPlatforms = []string{"GitHub", "Gitea"}
+
 // envStr invokes set with the value of key when it is non-empty.
 func envStr(key string, set func(string)) {
 	if v := os.Getenv(key); v != "" {
@@ -44,8 +66,8 @@ func envTrue(key string, set func()) {
 	}
 }
 
-// applyCodebaseEnv applies URL/token/skip-TLS overrides for a single codebase
-// entry from the NEUTRON_<PREFIX>_* environment variables.
+// applyCodebaseEnv applies URL/token/skip-TLS/webhook overrides for a single
+// codebase entry from the NEUTRON_<PREFIX>_* environment variables.
 func applyCodebaseEnv(config *model.Config, name, prefix string) {
 	envStr(prefix+"_URL", func(v string) {
 		cb := config.BaseConfig[name]
@@ -62,6 +84,16 @@ func applyCodebaseEnv(config *model.Config, name, prefix string) {
 		cb.SkipTLSVerify = true
 		config.BaseConfig[name] = cb
 	})
+	envStr(prefix+"_WEBHOOK_URL", func(v string) {
+		cb := config.BaseConfig[name]
+		cb.WebhookUrl = v
+		config.BaseConfig[name] = cb
+	})
+	envStr(prefix+"_WEBHOOK_SECRET", func(v string) {
+		cb := config.BaseConfig[name]
+		cb.WebhookSecret = v
+		config.BaseConfig[name] = cb
+	})
 }
 
 func applyEnvOverrides(config *model.Config) {
@@ -78,6 +110,7 @@ func applyEnvOverrides(config *model.Config) {
 	})
 	envStr("NEUTRON_DATABASE", func(v string) { config.Database = v })
 	envStr("NEUTRON_LOG_URL", func(v string) { config.LogUrl = v })
+	envStr("NEUTRON_SALT", func(v string) { config.Salt = v })
 	envStr("NEUTRON_KUBE_NAMESPACE", func(v string) { config.Kubernetes.Namespace = v })
 	envStr("NEUTRON_KUBE_CONFIG", func(v string) { config.Kubernetes.KubeConfig = v })
 	envStr("NEUTRON_GIT_PRIVATE_KEY", func(v string) { config.Kubernetes.GitPrivateKey = v })
@@ -99,17 +132,40 @@ func applyEnvOverrides(config *model.Config) {
 		}
 	})
 
-	applyCodebaseEnv(config, "GitLab", "NEUTRON_GITLAB")
-	applyCodebaseEnv(config, "Codeup", "NEUTRON_CODEUP")
-	envStr("NEUTRON_CODEUP_WEBHOOK_URL", func(v string) {
-		cb := config.BaseConfig["Codeup"]
-		cb.WebhookUrl = v
-		config.BaseConfig["Codeup"] = cb
-	})
+	for _, name := range platform.Names() {
+		applyCodebaseEnv(config, name, "NEUTRON_"+strings.ToUpper(name))
+	}
+	// Named instances: NEUTRON_CODEBASE_<ID>_* (see platform.EnvName)
+	for id := range config.BaseConfig {
+		if !slices.ContainsFunc(platform.Names(), func(name string) bool { return strings.EqualFold(name, id) }) {
+			applyCodebaseEnv(config, id, "NEUTRON_CODEBASE_"+platform.EnvName(id))
+		}
+	}
 
 	envStr("NEUTRON_NOTIFY_URL", func(v string) { config.Notify.Url = v })
 	envStr("NEUTRON_NOTIFY_CORP_ID", func(v string) { config.Notify.CorpId = v })
 	envStr("NEUTRON_NOTIFY_APP_ID", func(v string) { config.Notify.AppId = v })
 	envTrue("NEUTRON_NOTIFY_SKIP_TLS_VERIFY", func() { config.Notify.SkipTLSVerify = true })
 	envStr("NEUTRON_POD_API_URL", func(v string) { config.Kubernetes.PodApiUrl = v })
+	envStr("NEUTRON_KNOWN_HOSTS_SECRET", func(v string) { config.Kubernetes.KnownHostsSecret = v })
+	envStr("NEUTRON_POD_TEMPLATE", func(v string) { config.Kubernetes.PodTemplate = v })
+	envStr("NEUTRON_QUEUE_MAX_JOBS", func(v string) {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.Queue.MaxJobs = n
+		}
+	})
+	envStr("NEUTRON_QUEUE_MAX_JOBS_PER_PROJECT", func(v string) {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.Queue.MaxJobsPerProject = n
+		}
+	})
+	envStr("NEUTRON_SNIPPETS_SYNC_PROJECT", func(v string) { config.Snippets.SyncProject = v })
+	envStr("NEUTRON_SNIPPETS_SYNC_BRANCH", func(v string) { config.Snippets.SyncBranch = v })
+	envStr("NEUTRON_SNIPPETS_SYNC_DIR", func(v string) { config.Snippets.SyncDir = v })
+	envStr("NEUTRON_ARTIFACTS_DIR", func(v string) { config.Artifacts.Dir = v })
+	envStr("NEUTRON_ARTIFACTS_MAX_SIZE", func(v string) {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.Artifacts.MaxSize = n
+		}
+	})
 }
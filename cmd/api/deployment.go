@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"neutron/internal"
+)
+
+// defaultDeploymentHistory caps an environment's history listing when the
+// caller does not pass ?limit=.
+const defaultDeploymentHistory = 50
+
+// recordDeployment stores a deployment row for a succeeded job whose spec
+// declares an environment. Jobs without a spec or environment are ignored, and
+// recording the same job twice is a no-op.
+func (s *Server) recordDeployment(jobName string) {
+	dbJob, err := s.repo.GetJobByName(jobName)
+	if err != nil {
+		return
+	}
+	spec, ok := parseSpec(dbJob.Spec)
+	if !ok || spec.Environment == nil || spec.Environment.Name == "" {
+		return
+	}
+	// For a manual job the approver is the one who deployed it.
+	triggeredBy := dbJob.ApprovedBy
+	if triggeredBy == "" {
+		triggeredBy = spec.TriggeredBy
+	}
+	now := time.Now()
+	if err := s.repo.AddDeployment(internal.Deployment{
+		ProjectId:   dbJob.ProjectId,
+		Environment: spec.Environment.Name,
+		Url:         spec.Environment.Url,
+		JobName:     dbJob.Name,
+		PipelineJob: spec.JobName,
+		CommitSha:   spec.CommitSha,
+		Ref:         spec.CodeRef,
+		TriggeredBy: triggeredBy,
+		CreatedAt:   &now,
+	}); err != nil {
+		log.Printf("failed to record deployment of %s: %v", jobName, err)
+	}
+}
+
+// handleListEnvironments returns each environment of a project with its
+// currently deployed version (the latest successful deployment).
+func (s *Server) handleListEnvironments(c *gin.Context) {
+	environments, err := s.repo.ListEnvironments(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"environments": environments})
+}
+
+// handleListDeployments returns an environment's deployment history, newest first.
+func (s *Server) handleListDeployments(c *gin.Context) {
+	limit := defaultDeploymentHistory
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+	deployments, err := s.repo.ListDeployments(c.Param("id"), c.Param("env"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deployments": deployments})
+}
+
+// handleRedeploy deploys a previously deployed version again by rerunning the
+// job that produced the deployment. The new run records its own deployment
+// once it succeeds.
+func (s *Server) handleRedeploy(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid deployment id"})
+		return
+	}
+	var req struct {
+		TriggeredBy string `json:"triggered_by"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	deployment, err := s.repo.GetDeployment(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "deployment not found"})
+		return
+	}
+	dbJob, spec, ok := s.loadRerunnableJob(c, deployment.JobName)
+	if !ok {
+		return
+	}
+	spec.TriggeredBy = req.TriggeredBy
+	createdName, err := s.rerunJob(dbJob, spec, fmt.Sprintf("🔁 重新部署 %s 通知", deployment.Environment))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to redeploy: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":           "ok",
+		"job_name":         createdName,
+		"job_url":          fmt.Sprintf("%s/#/status/%s", s.config.Host, createdName),
+		"environment":      deployment.Environment,
+		"commit_sha":       deployment.CommitSha,
+		"waiting_approval": spec.Manual,
+	})
+}
@@ -10,8 +10,7 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
-	"sort"
-	"strings"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -22,6 +21,7 @@ import (
 
 	"neutron/internal"
 	"neutron/internal/ccwork"
+	"neutron/internal/launcher"
 	"neutron/internal/notify"
 )
 
@@ -80,8 +80,13 @@ func main() {
 		c.Data(http.StatusOK, "text/html; charset=utf-8", data)
 	})
 
-	server := NewServer(config, repo, clientSet, notifyClient, ccworkRobot)
+	podTemplate, err := launcher.LoadPodTemplate(config.Kubernetes.PodTemplate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := NewServer(config, repo, clientSet, notifyClient, ccworkRobot, podTemplate)
 	server.registerRoutes(r)
+	go server.runQueueWorker(15 * time.Second)
 
 	// --- Snippet management ---
 
@@ -95,12 +100,17 @@ func main() {
 	})
 
 	r.POST("/api/snippets", func(c *gin.Context) {
+		if server.snippetsReadOnly(c) {
+			return
+		}
 		var req struct {
-			Name        string `json:"name"`
-			Title       string `json:"title"`
-			Content     string `json:"content"`
-			Description string `json:"description"`
-			Params      string `json:"params"`
+			Name        string      `json:"name"`
+			Title       string      `json:"title"`
+			Content     string      `json:"content"`
+			Description string      `json:"description"`
+			Params      interface{} `json:"params"` // []SnippetParam, or a string (JSON or comma-separated names)
+			Lang        string      `json:"lang"`
+			Author      string      `json:"author"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -120,20 +130,33 @@ func main() {
 			c.JSON(http.StatusConflict, gin.H{"error": "snippet name already exists"})
 			return
 		}
+		if !validSnippetLang(req.Lang) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "lang must be sh, bash or pwsh"})
+			return
+		}
+		params, err := normalizeSnippetParams(req.Params)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		snippet := internal.Snippet{
 			Name:        req.Name,
 			Title:       req.Title,
 			Content:     req.Content,
 			Description: req.Description,
-			Params:      req.Params,
+			Params:      params,
+			Lang:        req.Lang,
 		}
-		if err := repo.CreateSnippet(snippet); err != nil {
+		if err := repo.CreateSnippet(snippet, req.Author); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"ok": true, "name": req.Name})
 	})
 
+	r.GET("/api/snippets/export", server.handleExportSnippets)
+	r.POST("/api/snippets/import", server.handleImportSnippets)
+
 	r.GET("/api/snippets/:name", func(c *gin.Context) {
 		name := c.Param("name")
 		snippet, err := repo.GetSnippetByName(name)
@@ -145,6 +168,9 @@ func main() {
 	})
 
 	r.PATCH("/api/snippets/:name", func(c *gin.Context) {
+		if server.snippetsReadOnly(c) {
+			return
+		}
 		name := c.Param("name")
 		if _, err := repo.GetSnippetByName(name); err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "snippet not found"})
@@ -169,65 +195,184 @@ func main() {
 		if v, ok := req["description"]; ok {
 			updates["description"] = v
 		}
+		if v, ok := req["lang"]; ok {
+			lang, isStr := v.(string)
+			if !isStr || !validSnippetLang(lang) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "lang must be sh, bash or pwsh"})
+				return
+			}
+			updates["lang"] = lang
+		}
 		if v, ok := req["params"]; ok {
-			updates["params"] = v
+			params, err := normalizeSnippetParams(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updates["params"] = params
 		}
 		if len(updates) == 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "no fields to update"})
 			return
 		}
-		if err := repo.UpdateSnippet(name, updates); err != nil {
+		author, _ := req["author"].(string)
+		if err := repo.UpdateSnippet(name, updates, author); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"ok": true})
 	})
 
-	r.DELETE("/api/snippets/:name", func(c *gin.Context) {
+	r.GET("/api/snippets/:name/revisions", func(c *gin.Context) {
 		name := c.Param("name")
 		if _, err := repo.GetSnippetByName(name); err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "snippet not found"})
 			return
 		}
-		if err := repo.DeleteSnippet(name); err != nil {
+		revisions, err := repo.ListSnippetRevisions(name)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"ok": true})
+		c.JSON(http.StatusOK, gin.H{"revisions": revisions})
 	})
 
-	// Raw snippet endpoint for curl | bash / source <(curl)
-	r.GET("/s/:name", func(c *gin.Context) {
+	// Diff between two revisions (?from=, ?to= defaults to the current one)
+	r.GET("/api/snippets/:name/diff", func(c *gin.Context) {
 		name := c.Param("name")
 		snippet, err := repo.GetSnippetByName(name)
 		if err != nil {
-			c.String(http.StatusNotFound, "snippet not found")
+			c.JSON(http.StatusNotFound, gin.H{"error": "snippet not found"})
 			return
 		}
-		// Prepend query parameters as shell variable assignments (sorted for determinism)
-		query := c.Request.URL.Query()
-		keys := make([]string, 0, len(query))
-		for k := range query {
-			if safeParamKey.MatchString(k) {
-				keys = append(keys, k)
+		from, err := strconv.Atoi(c.Query("from"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a revision number"})
+			return
+		}
+		to := snippet.Rev
+		if v := c.Query("to"); v != "" {
+			if to, err = strconv.Atoi(v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a revision number"})
+				return
 			}
 		}
-		sort.Strings(keys)
-		var lines []string
-		for _, key := range keys {
-			values := query[key]
-			if len(values) > 0 {
-				escaped := strings.ReplaceAll(values[0], `"`, `\"`)
-				lines = append(lines, fmt.Sprintf(`%s="%s";`, key, escaped))
+		a, err := repo.GetSnippetRevision(name, from)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("revision %d not found", from)})
+			return
+		}
+		b, err := repo.GetSnippetRevision(name, to)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("revision %d not found", to)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"from":        from,
+			"to":          to,
+			"diff":        diffLines(a.Content, b.Content),
+			"params_diff": diffLines(a.Params, b.Params),
+		})
+	})
+
+	// Usage stats over the last ?days= (default 30): total uses, the projects
+	// using the snippet, and the most recent uses
+	r.GET("/api/snippets/:name/usage", func(c *gin.Context) {
+		name := c.Param("name")
+		if _, err := repo.GetSnippetByName(name); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "snippet not found"})
+			return
+		}
+		days, ok := usageDays(c)
+		if !ok {
+			return
+		}
+		since := time.Now().AddDate(0, 0, -days)
+		total, err := repo.CountSnippetUsage(name, since)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		projects, err := repo.ListSnippetUsageProjects(name, since)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recent, err := repo.ListSnippetUsage(name, 20)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"days": days, "total": total, "projects": projects, "recent": recent})
+	})
+
+	// Rollback records the content of an earlier revision as a new revision
+	r.POST("/api/snippets/:name/rollback", func(c *gin.Context) {
+		if server.snippetsReadOnly(c) {
+			return
+		}
+		name := c.Param("name")
+		var req struct {
+			Rev    int    `json:"rev"`
+			Author string `json:"author"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := repo.GetSnippetRevision(name, req.Rev); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("revision %d not found", req.Rev)})
+			return
+		}
+		rev, err := repo.RollbackSnippet(name, req.Rev, req.Author)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true, "rev": rev})
+	})
+
+	// Deleting a snippet used within ?days= (default 30) needs ?force=true
+	r.DELETE("/api/snippets/:name", func(c *gin.Context) {
+		if server.snippetsReadOnly(c) {
+			return
+		}
+		name := c.Param("name")
+		if _, err := repo.GetSnippetByName(name); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "snippet not found"})
+			return
+		}
+		days, ok := usageDays(c)
+		if !ok {
+			return
+		}
+		if c.Query("force") != "true" {
+			since := time.Now().AddDate(0, 0, -days)
+			used, err := repo.CountSnippetUsage(name, since)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if used > 0 {
+				projects, _ := repo.ListSnippetUsageProjects(name, since)
+				c.JSON(http.StatusConflict, gin.H{
+					"error":    fmt.Sprintf("snippet was used %d times in the last %d days; pass force=true to delete it anyway", used, days),
+					"uses":     used,
+					"projects": projects,
+				})
+				return
 			}
 		}
-		if len(lines) > 0 {
-			lines = append(lines, "")
+		if err := repo.DeleteSnippet(name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
-		lines = append(lines, snippet.Content)
-		c.String(http.StatusOK, strings.Join(lines, "\n"))
+		c.JSON(http.StatusOK, gin.H{"ok": true})
 	})
 
+	// Raw snippet endpoint for curl | bash / source <(curl)
+	r.GET("/s/:name", server.handleRawSnippet)
+
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%d", config.Port),
 		Handler: r,
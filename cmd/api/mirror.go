@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"neutron/internal"
+	"neutron/internal/model"
+)
+
+// refreshMirror starts the K8s Job fetching a project's mirror in the shared
+// git cache, so its next checkouts find the new commits locally. A refresh
+// already running covers this webhook as well. Per-node caches are refreshed
+// by the checkouts themselves.
+func (s *Server) refreshMirror(p internal.PipelineProject, codebaseId string) {
+	cache := s.config.Kubernetes.GitCache
+	if cache == nil || cache.Pvc == "" {
+		return
+	}
+	l := s.launcherFromSpec(model.JobSpec{
+		Platform:   p.WebhookType,
+		Codebase:   codebaseId,
+		Project:    p.Id,
+		Namespace:  p.Namespace,
+		GitRepoUrl: p.RepoUrl,
+	})
+	job, err := l.CreateMirrorJob()
+	if err != nil {
+		log.Printf("failed to refresh the mirror of project %s: %v", p.Id, err)
+		return
+	}
+	_, err = s.clientSet.BatchV1().Jobs(l.Namespace).Create(context.Background(), job, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		log.Printf("failed to refresh the mirror of project %s: %v", p.Id, err)
+	}
+}
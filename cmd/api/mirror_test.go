@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"neutron/internal"
+	"neutron/internal/model"
+)
+
+// TestGitCache covers the webhook-triggered refresh of the shared mirror, which
+// runs once per repository at a time; the checkout script is covered in the
+// launcher.
+func TestGitCache(t *testing.T) {
+	var cfg model.Config
+	cfg.Kubernetes.Namespace = "ci"
+	cfg.Kubernetes.GitCache = &model.GitCacheConfig{Pvc: "git-cache"}
+	srv := newTestServer(t, cfg)
+
+	repoUrl := "git@gitlab.example.com:g/app.git"
+	project := internal.PipelineProject{Id: "p1", WebhookType: "GitLab", RepoUrl: repoUrl}
+	srv.refreshMirror(project, "GitLab")
+	srv.refreshMirror(project, "GitLab")
+	jobs, _ := srv.clientSet.BatchV1().Jobs("ci").List(context.Background(), metav1.ListOptions{})
+	if len(jobs.Items) != 1 {
+		t.Fatalf("got %d mirror jobs, want 1", len(jobs.Items))
+	}
+	refresh := jobs.Items[0].Spec.Template.Spec
+	if script := refresh.Containers[0].Command[2]; !strings.Contains(script, "git clone -q --mirror '"+repoUrl+"'") || !strings.Contains(script, "-mtime +14") {
+		t.Errorf("refresh script = %q", script)
+	}
+	if v := refresh.Volumes[len(refresh.Volumes)-1]; v.PersistentVolumeClaim == nil || v.PersistentVolumeClaim.ClaimName != "git-cache" {
+		t.Errorf("refresh volumes = %+v", refresh.Volumes)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"neutron/internal"
+	"neutron/internal/model"
+	"neutron/internal/parser"
+	"neutron/internal/platform"
+)
+
+// projectFetcher resolves `include: project` entries: the project must be
+// registered (by id or repo URL), and its files are read with its own token or
+// that of its codebase instance.
+func (s *Server) projectFetcher(project string) (parser.FileFetcher, error) {
+	p := s.repo.GetWebhookConfig(project)
+	if p.Id == "" {
+		p = s.repo.GetProjectByRepoUrl(project)
+	}
+	if p.Id == "" {
+		return nil, fmt.Errorf("project %s is not registered", project)
+	}
+	_, cb, err := s.projectCodebase(p)
+	if err != nil {
+		return nil, err
+	}
+	return platform.NewBase(p.WebhookType, p.RepoUrl, cb)
+}
+
+// handlePreviewPipeline returns a project's neutron.yaml at ?ref= with includes
+// merged and extends applied, i.e. the jobs a webhook at that ref would run.
+func (s *Server) handlePreviewPipeline(c *gin.Context) {
+	ref := c.Query("ref")
+	if ref == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ref is required"})
+		return
+	}
+	project := s.repo.GetWebhookConfig(c.Param("id"))
+	if project.Id == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	_, cb, err := s.projectCodebase(project)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pipeline, err := platform.FetchPipeline(project.WebhookType, project.RepoUrl, ref, cb, s.projectFetcher)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to resolve pipeline: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ref": ref, "pipeline": pipeline})
+}
+
+// noLocalFiles is the FileFetcher of a lint request naming no project, where
+// local includes have nowhere to be read from.
+type noLocalFiles struct{}
+
+func (noLocalFiles) FetchFile(filePath string, ref string) ([]byte, error) {
+	return nil, errors.New("local includes need ?project=")
+}
+
+// handleLintPipeline checks the neutron.yaml in the request body without
+// running it: includes and extends are resolved, and every job is checked as
+// a webhook would check it, against the snippet library, the scheduling
+// allow-list and the pod policy. With ?project= (id or repo URL) local includes
+// are read from that project at ?ref= and its default resources apply. The
+// response lists every problem found rather than the first one.
+func (s *Server) handleLintPipeline(c *gin.Context) {
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var project internal.PipelineProject
+	var local parser.FileFetcher = noLocalFiles{}
+	if id := c.Query("project"); id != "" {
+		project = s.repo.GetWebhookConfig(id)
+		if project.Id == "" {
+			project = s.repo.GetProjectByRepoUrl(id)
+		}
+		if project.Id == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+			return
+		}
+		_, cb, err := s.projectCodebase(project)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if local, err = platform.NewBase(project.WebhookType, project.RepoUrl, cb); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	pipeline, err := parser.Resolve(data, local, c.Query("ref"), s.projectFetcher)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"valid": false, "errors": []string{err.Error()}})
+		return
+	}
+	problems := []string{}
+	for _, name := range slices.Sorted(maps.Keys(pipeline.Jobs)) {
+		if err := s.lintJob(project, name, pipeline.Jobs[name]); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": len(problems) == 0, "errors": problems, "pipeline": pipeline})
+}
+
+// lintJob returns the error a webhook would fail with when triggering job.
+func (s *Server) lintJob(project internal.PipelineProject, name string, job model.Job) error {
+	if _, err := s.expandSnippetSteps(job.Steps); err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+	return s.checkSpec(model.JobSpec{
+		Platform:   project.WebhookType,
+		Codebase:   project.CodebaseId,
+		Project:    project.Id,
+		Namespace:  project.Namespace,
+		JobName:    name,
+		Image:      job.Image,
+		Resources:  projectResources(project, job.Resources),
+		GitRepoUrl: project.RepoUrl,
+		Checkout:   job.Checkout,
+		Scheduling: jobScheduling(job),
+	})
+}
+
+// reportPipelineError marks the pushed commit failed with why its neutron.yaml
+// could not be resolved, so the author sees it on the commit rather than only
+// in the platform's webhook delivery log.
+func (s *Server) reportPipelineError(platformName string, cb model.CodeBase, repoUrl string, ph parsedHook, cause error) {
+	p, err := platform.Get(platformName)
+	if err != nil || ph.reportSha == "" {
+		return
+	}
+	r, err := p.NewReporter(platform.RunnerEnv{
+		CodebaseUrl:   cb.Url,
+		Token:         cb.Token,
+		RepoUrl:       repoUrl,
+		ProjectId:     strconv.Itoa(ph.projectId),
+		ReportSha:     ph.reportSha,
+		PipelineUrl:   s.config.Host,
+		SkipTLSVerify: cb.SkipTLSVerify,
+	})
+	if err != nil {
+		log.Printf("cannot report pipeline error of %s: %v", repoUrl, err)
+		return
+	}
+	r.Report("neutron", "pipeline", model.Fail, cause.Error())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"neutron/internal"
+	"neutron/internal/model"
+)
+
+// TestLintPipeline verifies that linting reports every job's problem, and
+// reads local includes and default resources from the named project.
+func TestLintPipeline(t *testing.T) {
+	gitlab := gitlabServer(t, `
+templates:
+  base:
+    image: alpine:3
+`)
+	s := newTestServer(t, model.Config{BaseConfig: map[string]model.CodeBase{"GitLab": {Url: gitlab.URL, Token: "tok"}}})
+	lint := func(query, pipeline string) (valid bool, problems []string) {
+		t.Helper()
+		w := serve(s, "POST", "/api/lint"+query, pipeline)
+		if w.Code != http.StatusOK {
+			t.Fatalf("lint%s: %d %s", query, w.Code, w.Body)
+		}
+		var resp struct {
+			Valid  bool     `json:"valid"`
+			Errors []string `json:"errors"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Valid, resp.Errors
+	}
+
+	valid, problems := lint("", `
+jobs:
+  build:
+    image: alpine:3
+    node_selector: {pool: gpu}
+  test:
+    image: alpine:3
+    steps:
+      - uses: missing
+  lint:
+    image: alpine:3
+`)
+	if valid || len(problems) != 2 || !strings.HasPrefix(problems[0], "job build: ") || !strings.HasPrefix(problems[1], "job test: ") {
+		t.Errorf("lint = %v, %q, want the build and test problems", valid, problems)
+	}
+	if valid, problems := lint("", "jobs:\n  build:\n    image: alpine:3\n    resources:\n      limits: {cpu: 2 cores}\n"); valid || len(problems) != 1 || !strings.Contains(problems[0], "resources.limits.cpu") {
+		t.Errorf("lint of an unresolvable pipeline = %v, %q", valid, problems)
+	}
+
+	included := `
+include:
+  - local: ci/base.yaml
+jobs:
+  build:
+    extends: base
+`
+	if valid, problems := lint("", included); valid || len(problems) != 1 || !strings.Contains(problems[0], "?project=") {
+		t.Errorf("local include without a project = %v, %q", valid, problems)
+	}
+	if err := s.repo.AddWebhookConfig(internal.PipelineProject{Id: "p1", WebhookType: "GitLab", RepoUrl: "git@gitlab.example.com:group/app.git",
+		Resources: `{"Limits":{"Cpu":"2 cores"}}`}); err != nil {
+		t.Fatal(err)
+	}
+	if valid, problems := lint("?project=p1&ref=main", included); valid || len(problems) != 1 || !strings.Contains(problems[0], "resources.limits.cpu") {
+		t.Errorf("lint with the project = %v, %q, want its default resources checked", valid, problems)
+	}
+	if w := serve(s, "POST", "/api/lint?project=unknown", included); w.Code != http.StatusNotFound {
+		t.Errorf("lint with an unknown project: %d %s", w.Code, w.Body)
+	}
+}
+
+// TestReportPipelineError verifies that a pipeline that fails to resolve marks
+// the commit failed under neutron/pipeline.
+func TestReportPipelineError(t *testing.T) {
+	var path, token string
+	var status map[string]string
+	gitlab := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, token = r.URL.Path, r.Header.Get("PRIVATE-TOKEN")
+		_ = json.NewDecoder(r.Body).Decode(&status)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer gitlab.Close()
+	s := newTestServer(t, model.Config{})
+	cb := model.CodeBase{Url: gitlab.URL, Token: "tok"}
+
+	s.reportPipelineError("GitLab", cb, "git@gitlab.example.com:group/app.git", parsedHook{projectId: 42, reportSha: "abc123"}, errors.New("cannot resolve neutron.yaml"))
+	if path != "/api/v4/projects/42/statuses/abc123" || token != "tok" {
+		t.Errorf("reported to %s with token %q", path, token)
+	}
+	if status["state"] != "failed" || status["context"] != "neutron/pipeline" || status["description"] != "cannot resolve neutron.yaml" || status["target_url"] != "http://neutron.local" {
+		t.Errorf("status = %v", status)
+	}
+
+	path = ""
+	s.reportPipelineError("GitLab", cb, "git@gitlab.example.com:group/app.git", parsedHook{projectId: 42}, errors.New("no commit"))
+	if path != "" {
+		t.Errorf("reported %s without a commit to report on", path)
+	}
+}
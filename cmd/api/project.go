@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"neutron/internal"
+	"neutron/internal/launcher"
+	"neutron/internal/model"
+	"neutron/internal/platform"
+	"neutron/internal/secret"
+)
+
+// namespace is the K8s namespace of a project's or job's K8s Jobs; empty
+// means kubernetes.namespace.
+func (s *Server) namespace(namespace string) string {
+	if namespace == "" {
+		return s.config.Kubernetes.Namespace
+	}
+	return namespace
+}
+
+// jobNamespace looks up the K8s namespace of a job by name.
+func (s *Server) jobNamespace(jobName string) string {
+	if job, err := s.repo.GetJobByName(jobName); err == nil {
+		return s.namespace(job.Namespace)
+	}
+	return s.config.Kubernetes.Namespace
+}
+
+// webhookUrl is the URL a project's platform delivers webhooks to: the
+// instance's webhook_url if configured, otherwise config.Host.
+func (s *Server) webhookUrl(projectId string, cb model.CodeBase) string {
+	host := s.config.Host
+	if cb.WebhookUrl != "" {
+		host = cb.WebhookUrl
+	}
+	return fmt.Sprintf("%s/webhook/%s", host, projectId)
+}
+
+// createProject validates and saves a new project for /api/register and
+// POST /api/projects, installing its webhook first when asked to, and writes
+// the response.
+func (s *Server) createProject(c *gin.Context, p internal.PipelineProject, token string, installHook bool) {
+	// A named instance implies its platform
+	if p.CodebaseId != "" {
+		cb, ok := s.config.Codebase(p.CodebaseId)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("codebase %s not configured", p.CodebaseId)})
+			return
+		}
+		if p.WebhookType == "" {
+			p.WebhookType = cb.Type
+		}
+		if !strings.EqualFold(p.WebhookType, cb.Type) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("codebase %s is a %s instance, not %s", p.CodebaseId, cb.Type, p.WebhookType)})
+			return
+		}
+		p.WebhookType = cb.Type
+	}
+	if !slices.Contains(platform.Names(), p.WebhookType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported webhookType %q (supported: %s)", p.WebhookType, strings.Join(platform.Names(), ", "))})
+		return
+	}
+	codebaseId, cb, err := s.codebase(p.CodebaseId, p.WebhookType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Bind the project to the instance, so adding another of the same platform later does not move it
+	p.CodebaseId = codebaseId
+	if err := validateProject(p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if p.Token, err = s.encryptToken(token); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Install the hook before saving, so a project whose platform refuses it is not left behind
+	var hookErr error
+	if installHook {
+		if token != "" {
+			cb.Token = token
+		}
+		if p.HookId, hookErr = s.installHook(&p, cb); p.HookId == "" {
+			c.JSON(http.StatusBadGateway, gin.H{"error": hookErr.Error()})
+			return
+		}
+	}
+	if err := s.repo.AddWebhookConfig(p); err != nil {
+		// Best effort: a hook left behind would deliver to a project that does not exist
+		if p.HookId != "" {
+			if err := s.deleteHook(p); err != nil {
+				log.Printf("failed to delete webhook %s of unsaved project %s: %v", p.HookId, p.Id, err)
+			}
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp := gin.H{
+		"id":            p.Id,
+		"webhookType":   p.WebhookType,
+		"codebaseId":    p.CodebaseId,
+		"repoUrl":       p.RepoUrl,
+		"name":          p.Name,
+		"webhookUrl":    s.webhookUrl(p.Id, cb),
+		"hookId":        p.HookId,
+		"hasToken":      p.Token != "",
+		"tokenFile":     p.TokenFile,
+		"cloneProtocol": p.CloneProtocol,
+		"knownHosts":    p.KnownHosts,
+	}
+	if hookErr != nil {
+		resp["hookError"] = hookErr.Error()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// installHook creates or updates a project's webhook on its platform, with
+// the project's webhook secret, generated on its first install and set on p
+// encrypted; without a salt to encrypt it the instance's secret is used. An
+// id with an error means the hook was installed but its connectivity check
+// failed.
+func (s *Server) installHook(p *internal.PipelineProject, cb model.CodeBase) (string, error) {
+	installer, err := platform.Installer(p.WebhookType)
+	if err != nil {
+		return "", err
+	}
+	hookSecret := cb.WebhookSecret
+	if p.WebhookSecret != "" {
+		if hookSecret, err = secret.Decrypt(s.config.Salt, p.WebhookSecret); err != nil {
+			return "", fmt.Errorf("webhook secret of project %s: %w", p.Id, err)
+		}
+	} else if s.config.Salt != "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		hookSecret = hex.EncodeToString(buf)
+		if p.WebhookSecret, err = secret.Encrypt(s.config.Salt, hookSecret); err != nil {
+			return "", err
+		}
+	}
+	return installer.InstallHook(p.RepoUrl, cb, s.webhookUrl(p.Id, cb), hookSecret)
+}
+
+// handleInstallHook installs a registered project's webhook, or brings an
+// installed one back to the expected URL, events and secret.
+func (s *Server) handleInstallHook(c *gin.Context) {
+	project := s.repo.GetWebhookConfig(c.Param("id"))
+	if project.Id == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	_, cb, err := s.projectCodebase(project)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hookId, err := s.installHook(&project, cb)
+	if hookId == "" {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.repo.SetProjectHook(project.Id, hookId, project.WebhookSecret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp := gin.H{"id": project.Id, "hookId": hookId, "webhookUrl": s.webhookUrl(project.Id, cb)}
+	if err != nil {
+		resp["hookError"] = err.Error()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleDeleteProject removes a project's installed webhook from its platform,
+// cancels its running jobs and deletes its jobs, deployments and artifacts.
+// When the hook cannot be removed the project is kept, unless ?force=true.
+func (s *Server) handleDeleteProject(c *gin.Context) {
+	project := s.repo.GetWebhookConfig(c.Param("id"))
+	if project.Id == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	if project.HookId != "" {
+		err := s.deleteHook(project)
+		if err != nil && c.Query("force") != "true" {
+			c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("failed to delete webhook %s: %v (retry with ?force=true to keep it)", project.HookId, err)})
+			return
+		}
+		if err != nil {
+			log.Printf("failed to delete webhook %s of project %s: %v", project.HookId, project.Id, err)
+		}
+	}
+
+	// Hold the queue so no queued job of the project is launched meanwhile
+	s.queueMu.Lock()
+	jobs, err := s.repo.DeleteProject(project.Id)
+	s.queueMu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	propagation := metav1.DeletePropagationBackground
+	for _, job := range jobs {
+		if job.State == "" && !job.Completed {
+			err := s.clientSet.BatchV1().Jobs(s.namespace(job.Namespace)).Delete(context.Background(), job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+			if err != nil && !apierrors.IsNotFound(err) {
+				log.Printf("failed to cancel job %s: %v", job.Name, err)
+			}
+		}
+		if err := os.RemoveAll(s.artifactsDir(job.Name)); err != nil {
+			log.Printf("failed to remove artifacts of job %s: %v", job.Name, err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"id": project.Id, "deletedJobs": len(jobs)})
+}
+
+func (s *Server) deleteHook(p internal.PipelineProject) error {
+	installer, err := platform.Installer(p.WebhookType)
+	if err != nil {
+		return err
+	}
+	_, cb, err := s.projectCodebase(p)
+	if err != nil {
+		return err
+	}
+	return installer.DeleteHook(p.RepoUrl, cb, p.HookId)
+}
+
+// projectResponse is a project as the API returns it, with its JSON columns
+// decoded.
+type projectResponse struct {
+	internal.PipelineProject
+	Owners    []string
+	Notify    *model.Notify
+	Resources *model.Resources
+}
+
+func newProjectResponse(p internal.PipelineProject) projectResponse {
+	p.HasToken = p.Token != ""
+	resp := projectResponse{PipelineProject: p, Owners: []string{}, Notify: parseNotify(p.Notify), Resources: parseResources(p.Resources)}
+	_ = json.Unmarshal([]byte(p.Owners), &resp.Owners)
+	return resp
+}
+
+// projectRequest is the body of POST /api/projects and PATCH
+// /api/projects/:id. On update, absent fields are left alone; the platform,
+// codebase and token are set at creation (the token later via
+// /api/projects/:id/token).
+type projectRequest struct {
+	WebhookType   string           `json:"webhook_type"`
+	CodebaseId    string           `json:"codebase_id"`
+	RepoUrl       *string          `json:"repo_url"`
+	Name          *string          `json:"name"`
+	Description   *string          `json:"description"`
+	Owners        []string         `json:"owners"`
+	Notify        *model.Notify    `json:"notify"`
+	Paused        *bool            `json:"paused"`
+	Namespace     *string          `json:"namespace"`
+	Resources     *model.Resources `json:"resources"`
+	CloneProtocol *string          `json:"clone_protocol"`
+	KnownHosts    *string          `json:"known_hosts"`
+	Token         string           `json:"token"`
+	TokenFile     bool             `json:"token_file"`
+	InstallHook   bool             `json:"install_hook"`
+}
+
+// apply sets the fields present in the request on p, returning the changed
+// columns.
+func (req projectRequest) apply(p *internal.PipelineProject) (map[string]interface{}, error) {
+	updates := map[string]interface{}{}
+	if req.RepoUrl != nil {
+		p.RepoUrl = strings.TrimSpace(*req.RepoUrl)
+		updates["repo_url"] = p.RepoUrl
+	}
+	if req.Name != nil {
+		p.Name = strings.TrimSpace(*req.Name)
+		updates["name"] = p.Name
+	}
+	if req.Description != nil {
+		p.Description = *req.Description
+		updates["description"] = p.Description
+	}
+	if req.Owners != nil {
+		owners := []string{}
+		for _, owner := range req.Owners {
+			if owner = strings.TrimSpace(owner); owner != "" && !slices.Contains(owners, owner) {
+				owners = append(owners, owner)
+			}
+		}
+		data, _ := json.Marshal(owners)
+		p.Owners = string(data)
+		updates["owners"] = p.Owners
+	}
+	if req.Notify != nil {
+		p.Notify = marshalNotify(req.Notify)
+		updates["notify"] = p.Notify
+	}
+	if req.Paused != nil {
+		p.Paused = *req.Paused
+		updates["paused"] = p.Paused
+	}
+	if req.Namespace != nil {
+		p.Namespace = strings.TrimSpace(*req.Namespace)
+		updates["namespace"] = p.Namespace
+	}
+	if req.Resources != nil {
+		if err := req.Resources.Validate(); err != nil {
+			return nil, err
+		}
+		data, _ := json.Marshal(req.Resources)
+		p.Resources = string(data)
+		updates["resources"] = p.Resources
+	}
+	if req.CloneProtocol != nil {
+		p.CloneProtocol = strings.ToLower(strings.TrimSpace(*req.CloneProtocol))
+		updates["clone_protocol"] = p.CloneProtocol
+	}
+	if req.KnownHosts != nil {
+		p.KnownHosts = strings.TrimSpace(*req.KnownHosts)
+		updates["known_hosts"] = p.KnownHosts
+	}
+	return updates, nil
+}
+
+// validateProject checks the fields of a project that would otherwise only
+// fail once a webhook arrives.
+func validateProject(p internal.PipelineProject) error {
+	if err := platform.ValidateRepoUrl(p.WebhookType, p.RepoUrl); err != nil {
+		return fmt.Errorf("invalid repo_url: %w", err)
+	}
+	if p.Namespace != "" {
+		if errs := validation.IsDNS1123Label(p.Namespace); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace %q: %s", p.Namespace, strings.Join(errs, "; "))
+		}
+	}
+	switch p.CloneProtocol {
+	case "", "ssh":
+	case "https":
+		if _, err := platform.Cloner(p.WebhookType); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("clone_protocol must be ssh or https, got %q", p.CloneProtocol)
+	}
+	if p.KnownHosts != "" {
+		if errs := validation.IsDNS1123Subdomain(p.KnownHosts); len(errs) > 0 {
+			return fmt.Errorf("invalid known_hosts secret %q: %s", p.KnownHosts, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+func parseResources(s string) *model.Resources {
+	if s == "" {
+		return nil
+	}
+	var r model.Resources
+	if err := json.Unmarshal([]byte(s), &r); err != nil {
+		return nil
+	}
+	return &r
+}
+
+// projectResources returns the resources of a job, or the project's default
+// ones for a job that declares none.
+func projectResources(p internal.PipelineProject, resources *model.Resources) *model.Resources {
+	if resources != nil {
+		return resources
+	}
+	return parseResources(p.Resources)
+}
+
+// projectNotify returns the notify targets of a job, or the project's default
+// ones for a job that declares none.
+func projectNotify(p internal.PipelineProject, notify *model.Notify) *model.Notify {
+	if notify != nil {
+		return notify
+	}
+	return parseNotify(p.Notify)
+}
+
+func (s *Server) handleGetProject(c *gin.Context) {
+	project := s.repo.GetWebhookConfig(c.Param("id"))
+	if project.Id == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"project": newProjectResponse(project)})
+}
+
+// handleCreateProject registers a project from a JSON body; /api/register is
+// the form equivalent.
+func (s *Server) handleCreateProject(c *gin.Context) {
+	var req projectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p := internal.PipelineProject{
+		Id:          uuid.New().String(),
+		WebhookType: req.WebhookType,
+		CodebaseId:  req.CodebaseId,
+		TokenFile:   req.TokenFile,
+	}
+	if _, err := req.apply(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.createProject(c, p, req.Token, req.InstallHook)
+}
+
+// handleUpdateProject changes the fields present in the body. A project whose
+// webhook Neutron installed keeps its repo_url: the hook lives on that
+// repository.
+func (s *Server) handleUpdateProject(c *gin.Context) {
+	var req projectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	project := s.repo.GetWebhookConfig(c.Param("id"))
+	if project.Id == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	oldRepoUrl := project.RepoUrl
+	updates, err := req.apply(&project)
+	if err == nil {
+		err = validateProject(project)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if project.HookId != "" && project.RepoUrl != oldRepoUrl {
+		c.JSON(http.StatusConflict, gin.H{"error": "the project's webhook is installed on " + oldRepoUrl + "; delete and register the project again to move it"})
+		return
+	}
+	if len(updates) > 0 {
+		if err := s.repo.UpdateProject(project.Id, updates); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Paused != nil && !project.Paused {
+		// launch the jobs that queued up while the project was paused
+		s.dispatchQueue()
+	}
+	c.JSON(http.StatusOK, gin.H{"project": newProjectResponse(project)})
+}
+
+// projectPaused reports whether the project of a job is registered and
+// paused; its jobs are then neither launched nor rerun.
+func (s *Server) projectPaused(projectId string) bool {
+	return projectId != "" && s.repo.GetWebhookConfig(projectId).Paused
+}
+
+// applyProjectCheckout sets how a job of a registered project clones: the
+// project's known_hosts Secret, and for HTTPS the URL and credentials of the
+// job's token on the pod-side instance. A project whose HTTPS checkout no
+// longer resolves (e.g. the instance lost its url) keeps the SSH checkout.
+func (s *Server) applyProjectCheckout(projectId, codebaseId string, l *launcher.Launcher) {
+	if projectId == "" || s.repo == nil {
+		return
+	}
+	p := s.repo.GetWebhookConfig(projectId)
+	if p.KnownHosts != "" {
+		l.KnownHostsSecret = p.KnownHosts
+	}
+	if p.CloneProtocol != "https" {
+		return
+	}
+	cb := s.config.PodCodebase(codebaseId)
+	cb.Token = l.RunnerConfig.CodebaseToken
+	cloner, err := platform.Cloner(p.WebhookType)
+	if err != nil {
+		log.Printf("HTTPS checkout of project %s: %v", projectId, err)
+		return
+	}
+	cloneUrl, user, password, err := cloner.HttpsClone(p.RepoUrl, cb)
+	if err != nil {
+		log.Printf("HTTPS checkout of project %s: %v", projectId, err)
+		return
+	}
+	l.RunnerConfig.CloneUrl = cloneUrl
+	l.HttpsUser, l.HttpsPassword = user, password
+	l.SkipTLSVerify = cb.SkipTLSVerify
+}
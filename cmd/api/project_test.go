@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"neutron/internal"
+	"neutron/internal/model"
+)
+
+// TestProjectRequest verifies that an update only touches the fields it is
+// given and that project fields are validated before saving.
+func TestProjectRequest(t *testing.T) {
+	p := internal.PipelineProject{WebhookType: "GitLab", RepoUrl: "git@gitlab.example.com:group/project.git", Name: "old"}
+	paused := true
+	updates, err := projectRequest{Paused: &paused, Owners: []string{" alice", "bob", "alice", ""}}.apply(&p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 2 || p.Name != "old" || !p.Paused || p.Owners != `["alice","bob"]` {
+		t.Errorf("apply = %v, project %+v", updates, p)
+	}
+	if err := validateProject(p); err != nil {
+		t.Errorf("validateProject: %v", err)
+	}
+	for _, bad := range []internal.PipelineProject{
+		{WebhookType: "GitLab", RepoUrl: "git@gitlab.example.com:project.git"},
+		{WebhookType: "GitLab", RepoUrl: p.RepoUrl, Namespace: "CI_Jobs"},
+	} {
+		if err := validateProject(bad); err == nil {
+			t.Errorf("validateProject(%+v) accepted", bad)
+		}
+	}
+	if _, err := (projectRequest{Resources: &model.Resources{Limits: model.ResourceSpec{Cpu: "two"}}}).apply(&p); err == nil {
+		t.Error("apply accepted an invalid cpu quantity")
+	}
+}
+
+// TestProjectDefaults verifies that a job's own notify and resources take
+// precedence over the project's defaults.
+func TestProjectDefaults(t *testing.T) {
+	p := internal.PipelineProject{
+		Notify:    `{"users":["alice"]}`,
+		Resources: `{"Limits":{"Cpu":"2"}}`,
+	}
+	if n := projectNotify(p, nil); n == nil || len(n.Users) != 1 {
+		t.Errorf("projectNotify default = %+v", n)
+	}
+	own := &model.Notify{Groups: []string{"g"}}
+	if n := projectNotify(p, own); n != own {
+		t.Errorf("projectNotify = %+v, want the job's", n)
+	}
+	if r := projectResources(p, nil); r == nil || r.Limits.Cpu != "2" {
+		t.Errorf("projectResources default = %+v", r)
+	}
+	if r := projectResources(internal.PipelineProject{}, nil); r != nil {
+		t.Errorf("projectResources without defaults = %+v", r)
+	}
+}
+
+// TestProjectWebhookSecret verifies that installing a hook gives the project
+// its own secret, which its webhooks are verified against, while a project
+// with a hook added by hand keeps the instance's.
+func TestProjectWebhookSecret(t *testing.T) {
+	var installed string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet:
+			fmt.Fprint(w, `[]`)
+		case strings.HasSuffix(r.URL.Path, "/test/push_events"):
+			w.WriteHeader(http.StatusCreated)
+		default:
+			var body struct {
+				Token string `json:"token"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			installed = body.Token
+			fmt.Fprint(w, `{"id":7}`)
+		}
+	}))
+	defer srv.Close()
+	s := newTestServer(t, model.Config{Salt: "salt", BaseConfig: map[string]model.CodeBase{
+		"GitLab": {Url: srv.URL, Token: "tok", WebhookSecret: "instance"},
+	}})
+	for _, id := range []string{"p1", "p2"} {
+		if err := s.repo.AddWebhookConfig(internal.PipelineProject{Id: id, WebhookType: "GitLab", RepoUrl: "git@h:group/app.git"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if w := serve(s, http.MethodPut, "/api/projects/p1/hook", ""); w.Code != http.StatusOK {
+		t.Fatalf("install hook: %d %s", w.Code, w.Body)
+	}
+	p := s.repo.GetWebhookConfig("p1")
+	if p.HookId != "7" || p.WebhookSecret == "" || p.WebhookSecret == installed {
+		t.Fatalf("project after install = %+v, hook secret %q", p, installed)
+	}
+	if _, cb, err := s.projectCodebase(p); err != nil || installed == "instance" || cb.WebhookSecret != installed {
+		t.Errorf("project p1 verifies with %q (%v), hook installed with %q", cb.WebhookSecret, err, installed)
+	}
+	if _, cb, _ := s.projectCodebase(s.repo.GetWebhookConfig("p2")); cb.WebhookSecret != "instance" {
+		t.Errorf("project p2 verifies with %q, want the instance's secret", cb.WebhookSecret)
+	}
+
+	// Reinstalling keeps the project's secret
+	first := installed
+	serve(s, http.MethodPut, "/api/projects/p1/hook", "")
+	if installed != first {
+		t.Errorf("reinstall changed the hook secret from %q to %q", first, installed)
+	}
+}
+
+// TestCreateProjectRemovesHookOnSaveFailure verifies that a hook installed for
+// a project that then fails to save is deleted again.
+func TestCreateProjectRemovesHookOnSaveFailure(t *testing.T) {
+	var deleted string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete:
+			deleted = r.URL.Path
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet:
+			fmt.Fprint(w, `[]`)
+		case strings.HasSuffix(r.URL.Path, "/test/push_events"):
+			w.WriteHeader(http.StatusCreated)
+		default:
+			fmt.Fprint(w, `{"id":7}`)
+		}
+	}))
+	defer srv.Close()
+	s := newTestServer(t, model.Config{Salt: "salt", BaseConfig: map[string]model.CodeBase{"GitLab": {Url: srv.URL, Token: "tok"}}})
+	if err := s.repo.DB().Migrator().DropTable(&internal.PipelineProject{}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(s, http.MethodPost, "/api/projects", `{"webhook_type":"GitLab","repo_url":"git@h:group/app.git","install_hook":true}`)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	if !strings.HasSuffix(deleted, "/hooks/7") {
+		t.Errorf("deleted %q, want the installed hook 7", deleted)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"neutron/internal"
+	"neutron/internal/model"
+)
+
+// The job queue sits between job creation and the K8s API. A job waits in it
+// while its concurrency group has a running job or a concurrency limit from
+// config.Queue is reached. Queued jobs are persisted on neutron_job (state
+// Queued), so a restart loses nothing: the queue worker picks them up again.
+// All admission decisions are taken under Server.queueMu so two webhooks cannot
+// both take the last free slot.
+
+// Queue priorities. Tag builds (releases) and manual jobs someone is waiting
+// on run before ordinary pushes and merge requests.
+const (
+	priorityNormal = 0
+	priorityHigh   = 1
+)
+
+// jobPriority returns the queue priority of a job.
+func jobPriority(spec model.JobSpec, manual bool) int {
+	if manual || spec.Trigger == "TAG" {
+		return priorityHigh
+	}
+	return priorityNormal
+}
+
+// mustWait reports whether a job of the project's concurrency group cannot
+// start now because its group is busy or a concurrency limit is reached.
+func (s *Server) mustWait(projectId string, group string) (bool, error) {
+	if group != "" {
+		n, err := s.repo.CountRunningInGroup(projectId, group)
+		if err != nil || n > 0 {
+			return true, err
+		}
+	}
+	if max := s.config.Queue.MaxJobs; max > 0 {
+		n, err := s.repo.CountRunningJobs("")
+		if err != nil || n >= int64(max) {
+			return true, err
+		}
+	}
+	if max := s.config.Queue.MaxJobsPerProject; max > 0 {
+		n, err := s.repo.CountRunningJobs(projectId)
+		if err != nil || n >= int64(max) {
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+// shouldQueue reports whether a new job must join the queue rather than start
+// now: it has to wait (see mustWait), or queued jobs of at least its priority
+// are already waiting for a slot and must not be overtaken. Caller holds queueMu.
+func (s *Server) shouldQueue(projectId string, group string, priority int) (bool, error) {
+	wait, err := s.mustWait(projectId, group)
+	if err != nil || wait {
+		return true, err
+	}
+	if s.config.Queue.MaxJobs <= 0 && s.config.Queue.MaxJobsPerProject <= 0 {
+		return false, nil
+	}
+	ahead, err := s.repo.CountQueuedJobs(priority)
+	if err != nil {
+		return true, err
+	}
+	return ahead > 0, nil
+}
+
+// isStillQueued reports whether a job is still queued after a dispatch.
+func (s *Server) isStillQueued(jobName string) bool {
+	job, err := s.repo.GetJobByName(jobName)
+	return err != nil || job.State == internal.JobStateQueued
+}
+
+// launchJob creates the K8s Job for a persisted or about-to-be-persisted job
+// row under its reserved name.
+func (s *Server) launchJob(name string, spec model.JobSpec) error {
+	// the allow-list may have changed since a queued or held job was checked
+	if err := s.validateScheduling(spec.JobName, spec.Scheduling); err != nil {
+		return err
+	}
+	l := s.launcherFromSpec(spec)
+	l.FullJobName = name
+	job, err := l.CreateJob(s.config.Host)
+	if err != nil {
+		return err
+	}
+	_, err = s.clientSet.BatchV1().Jobs(l.Namespace).Create(context.Background(), job, metav1.CreateOptions{})
+	return err
+}
+
+// cancelGroup cancels every running and queued job of a project's concurrency
+// group, deleting the K8s Jobs of the running ones. Caller holds queueMu.
+func (s *Server) cancelGroup(projectId string, group string) {
+	propagation := metav1.DeletePropagationBackground
+	for _, state := range []string{"", internal.JobStateQueued} {
+		jobs, err := s.repo.ListGroupJobs(projectId, group, state)
+		if err != nil {
+			log.Printf("failed to list %s jobs of group %s: %v", state, group, err)
+			continue
+		}
+		for _, job := range jobs {
+			if state == "" {
+				err := s.clientSet.BatchV1().Jobs(s.namespace(job.Namespace)).Delete(context.Background(), job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+				if err != nil && !apierrors.IsNotFound(err) {
+					log.Printf("failed to cancel job %s: %v", job.Name, err)
+					continue
+				}
+			}
+			if err := s.repo.MarkJobCanceled(job.Name); err != nil {
+				log.Printf("failed to mark job %s cancelled: %v", job.Name, err)
+			}
+		}
+	}
+}
+
+// dispatchQueue launches queued jobs in priority/FIFO order for as long as
+// their groups are free and the concurrency limits allow. Jobs of a paused
+// project stay queued until it is resumed.
+func (s *Server) dispatchQueue() {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	s.dispatchLocked()
+}
+
+// dispatchLocked is dispatchQueue for callers already holding queueMu.
+func (s *Server) dispatchLocked() {
+	queued, err := s.repo.ListQueuedJobs()
+	if err != nil {
+		log.Printf("failed to list queued jobs: %v", err)
+		return
+	}
+	paused := map[string]bool{}
+	for _, job := range queued {
+		if _, ok := paused[job.ProjectId]; !ok {
+			paused[job.ProjectId] = s.projectPaused(job.ProjectId)
+		}
+		if paused[job.ProjectId] {
+			// launched once the project is resumed
+			continue
+		}
+		wait, err := s.mustWait(job.ProjectId, job.ConcurrencyGroup)
+		if err != nil || wait {
+			continue
+		}
+		spec, ok := parseSpec(job.Spec)
+		if !ok {
+			s.failQueuedJob(job.Name, fmt.Errorf("job has no spec"))
+			continue
+		}
+		if err := s.launchJob(job.Name, spec); err != nil {
+			s.failQueuedJob(job.Name, err)
+			continue
+		}
+		if err := s.repo.SetJobState(job.Name, ""); err != nil {
+			log.Printf("failed to mark queued job %s launched: %v", job.Name, err)
+		}
+	}
+}
+
+// failQueuedJob records a queued job that could not be launched as failed so
+// it does not block its group forever.
+func (s *Server) failQueuedJob(jobName string, cause error) {
+	log.Printf("failed to launch queued job %s: %v", jobName, cause)
+	if status, err := s.repo.GetJobStatus(jobName); err == nil {
+		status.Failed = 1
+		_ = s.repo.UpdateJobStatus(jobName, status)
+	}
+	_ = s.repo.SetJobState(jobName, "")
+	_ = s.repo.MarkJobCompleted(jobName)
+}
+
+// markJobCompleted marks a job completed and hands its slot to the queue.
+func (s *Server) markJobCompleted(jobName string) {
+	_ = s.repo.MarkJobCompleted(jobName)
+	s.dispatchQueue()
+}
+
+// runQueueWorker periodically reconciles the queue: launched jobs whose
+// completion was never reported or outlasted the report (e.g. the pod was
+// evicted, or the K8s Job was deleted) are marked completed so they stop
+// holding a slot, succeeded ones record their deployment, and queued jobs are
+// dispatched.
+func (s *Server) runQueueWorker(interval time.Duration) {
+	for {
+		s.reconcileQueue()
+		time.Sleep(interval)
+	}
+}
+
+func (s *Server) reconcileQueue() {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+
+	// Holding queueMu, every row marked running already has its K8s Job, so a
+	// running row missing from the listing really is gone.
+	running, err := s.repo.ListRunningJobs()
+	if err != nil {
+		log.Printf("failed to list running jobs: %v", err)
+		return
+	}
+	finished := make(map[string]bool)
+	succeeded := make(map[string]bool)
+	listed := make(map[string]bool)
+	for _, job := range running {
+		namespace := s.namespace(job.Namespace)
+		if listed[namespace] {
+			continue
+		}
+		k8sJobs, err := s.clientSet.BatchV1().Jobs(namespace).List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			log.Printf("failed to list K8s jobs in %s: %v", namespace, err)
+			return
+		}
+		listed[namespace] = true
+		for _, j := range k8sJobs.Items {
+			finished[j.Name] = j.Status.Succeeded > 0 || j.Status.Failed > 0
+			succeeded[j.Name] = j.Status.Succeeded > 0
+		}
+	}
+	for _, job := range running {
+		if done, exists := finished[job.Name]; done || !exists {
+			_ = s.repo.MarkJobCompleted(job.Name)
+		}
+		if succeeded[job.Name] {
+			s.recordDeployment(job.Name)
+		}
+	}
+	s.dispatchLocked()
+}
+
+// handleQueue lists the queued jobs in dispatch order along with the
+// configured limits and current usage.
+func (s *Server) handleQueue(c *gin.Context) {
+	queued, err := s.repo.ListQueuedJobs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	running, err := s.repo.CountRunningJobs("")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	items := make([]gin.H, 0, len(queued))
+	for i, job := range queued {
+		items = append(items, gin.H{
+			"position":         i + 1,
+			"jobName":          job.Name,
+			"projectId":        job.ProjectId,
+			"priority":         job.Priority,
+			"concurrencyGroup": job.ConcurrencyGroup,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"queued":            items,
+		"running":           running,
+		"maxJobs":           s.config.Queue.MaxJobs,
+		"maxJobsPerProject": s.config.Queue.MaxJobsPerProject,
+	})
+}
+
+// pendingStatus is the status recorded for a job that has no K8s Job yet.
+func pendingStatus(spec model.JobSpec) string {
+	b, err := json.Marshal(internal.JobStatus{
+		WebhookType: spec.Platform,
+		RepoUrl:     spec.GitRepoUrl,
+		SourceUrl:   spec.SourceUrl,
+		TriggerType: spec.Trigger,
+	})
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"neutron/internal"
+	"neutron/internal/launcher"
+	"neutron/internal/model"
+)
+
+// newTestServer returns a server on an in-memory database and a fake K8s
+// cluster.
+func newTestServer(t *testing.T, cfg model.Config) *Server {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would open its own in-memory database
+	sqlDB.SetMaxOpenConns(1)
+	repo, err := internal.OpenRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(repo.Close)
+	if cfg.Host == "" {
+		cfg.Host = "http://neutron.local"
+	}
+	if cfg.Kubernetes.Namespace == "" {
+		cfg.Kubernetes.Namespace = "default"
+	}
+	if cfg.BaseConfig == nil {
+		cfg.BaseConfig = map[string]model.CodeBase{"GitLab": {Url: "https://gitlab.example.com", Token: "tok"}}
+	}
+	return NewServer(cfg, repo, fake.NewSimpleClientset(), nil, nil, nil)
+}
+
+// createJob returns the K8s Job of l, failing the test when the pod policy
+// rejects it.
+func createJob(t *testing.T, l *launcher.Launcher) *batchv1.Job {
+	t.Helper()
+	job, err := l.CreateJob("http://neutron.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// serve sends a request through the server's routes.
+func serve(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	r := gin.New()
+	s.registerRoutes(r)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+// queueSpec returns the spec of a GitLab push job named jobName.
+func queueSpec(jobName string, group string) model.JobSpec {
+	spec := model.JobSpec{
+		Platform:   "GitLab",
+		JobName:    jobName,
+		Image:      "alpine:3",
+		ProjectId:  "101",
+		CommitSha:  "abc123",
+		ReportSha:  "abc123",
+		Trigger:    "PUSH",
+		GitRepoUrl: "git@gitlab.example.com:backend/order-service.git",
+		CodeRef:    "main",
+	}
+	if group != "" {
+		spec.Concurrency = &model.Concurrency{Group: group}
+	}
+	return spec
+}
+
+// jobState returns the state of a job row and whether its K8s Job exists.
+func jobState(t *testing.T, s *Server, name string) (string, bool) {
+	t.Helper()
+	job, err := s.repo.GetJobByName(name)
+	if err != nil {
+		t.Fatalf("job %s: %v", name, err)
+	}
+	_, err = s.clientSet.BatchV1().Jobs("default").Get(context.Background(), name, metav1.GetOptions{})
+	return job.State, err == nil
+}
+
+func TestConcurrencyGroupSerialises(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+
+	first, queued, err := s.createJobFromSpec("p1", queueSpec("deploy-a", "deploy"), nil)
+	if err != nil || queued {
+		t.Fatalf("first job: queued=%v err=%v", queued, err)
+	}
+	second, queued, err := s.createJobFromSpec("p1", queueSpec("deploy-b", "deploy"), nil)
+	if err != nil || !queued {
+		t.Fatalf("second job: queued=%v err=%v, want queued", queued, err)
+	}
+	if state, launched := jobState(t, s, second); state != internal.JobStateQueued || launched {
+		t.Fatalf("second job state=%q launched=%v while the group is busy", state, launched)
+	}
+	// other projects and groups are not held up
+	if _, queued, err := s.createJobFromSpec("p2", queueSpec("deploy-c", "deploy"), nil); err != nil || queued {
+		t.Fatalf("other project's job: queued=%v err=%v", queued, err)
+	}
+	if _, queued, err := s.createJobFromSpec("p1", queueSpec("build", ""), nil); err != nil || queued {
+		t.Fatalf("ungrouped job: queued=%v err=%v", queued, err)
+	}
+
+	s.markJobCompleted(first)
+	if state, launched := jobState(t, s, second); state != "" || !launched {
+		t.Fatalf("second job state=%q launched=%v after the first completed", state, launched)
+	}
+}
+
+func TestConcurrencyCancelInProgress(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+
+	first, _, err := s.createJobFromSpec("p1", queueSpec("deploy-a", "deploy"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := s.createJobFromSpec("p1", queueSpec("deploy-b", "deploy"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := queueSpec("deploy-c", "deploy")
+	spec.Concurrency.CancelInProgress = true
+	third, queued, err := s.createJobFromSpec("p1", spec, nil)
+	if err != nil || queued {
+		t.Fatalf("cancelling job: queued=%v err=%v", queued, err)
+	}
+	for _, name := range []string{first, second} {
+		if state, launched := jobState(t, s, name); state != internal.JobStateCanceled || launched {
+			t.Errorf("job %s state=%q launched=%v, want cancelled", name, state, launched)
+		}
+	}
+	if state, launched := jobState(t, s, third); state != "" || !launched {
+		t.Errorf("cancelling job state=%q launched=%v", state, launched)
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	cfg := model.Config{}
+	cfg.Queue.MaxJobs = 1
+	s := newTestServer(t, cfg)
+
+	running, _, err := s.createJobFromSpec("p1", queueSpec("build-a", ""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	push, queued, err := s.createJobFromSpec("p1", queueSpec("build-b", ""), nil)
+	if err != nil || !queued {
+		t.Fatalf("push job: queued=%v err=%v, want queued", queued, err)
+	}
+	tagSpec := queueSpec("release", "")
+	tagSpec.Trigger = "TAG"
+	tag, queued, err := s.createJobFromSpec("p1", tagSpec, nil)
+	if err != nil || !queued {
+		t.Fatalf("tag job: queued=%v err=%v, want queued", queued, err)
+	}
+
+	s.markJobCompleted(running)
+	if state, launched := jobState(t, s, tag); state != "" || !launched {
+		t.Errorf("tag job state=%q launched=%v, want it to overtake the push", state, launched)
+	}
+	if state, launched := jobState(t, s, push); state != internal.JobStateQueued || launched {
+		t.Errorf("push job state=%q launched=%v, want still queued", state, launched)
+	}
+}
+
+// setJobStatus sets the status of a K8s Job in the fake cluster.
+func setJobStatus(t *testing.T, s *Server, name string, status batchv1.JobStatus) {
+	t.Helper()
+	jobs := s.clientSet.BatchV1().Jobs("default")
+	job, err := jobs.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Status = status
+	if _, err := jobs.UpdateStatus(context.Background(), job, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStepReportKeepsGroupBusy(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+	first, _, err := s.createJobFromSpec("p1", queueSpec("deploy-a", "deploy"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := s.createJobFromSpec("p1", queueSpec("deploy-b", "deploy"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the runner reports success after its first step while the pod still runs
+	setJobStatus(t, s, first, batchv1.JobStatus{Active: 1})
+	if s.completeJob(first, "default", "Succeeded") {
+		t.Fatal("job completed while its K8s Job is active")
+	}
+	if job, _ := s.repo.GetJobByName(first); job.Completed {
+		t.Error("running job marked completed")
+	}
+	if state, launched := jobState(t, s, second); state != internal.JobStateQueued || launched {
+		t.Fatalf("second job state=%q launched=%v while the first still runs", state, launched)
+	}
+
+	setJobStatus(t, s, first, batchv1.JobStatus{Succeeded: 1})
+	if !s.completeJob(first, "default", "Succeeded") {
+		t.Fatal("job not completed after its K8s Job succeeded")
+	}
+	if state, launched := jobState(t, s, second); state != "" || !launched {
+		t.Fatalf("second job state=%q launched=%v after the first finished", state, launched)
+	}
+}
+
+func TestReconcileCompletesFinishedJobs(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+	first, _, err := s.createJobFromSpec("p1", queueSpec("deploy-a", "deploy"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := s.createJobFromSpec("p1", queueSpec("deploy-b", "deploy"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.reconcileQueue()
+	if state, _ := jobState(t, s, second); state != internal.JobStateQueued {
+		t.Fatalf("second job state=%q while the first still runs", state)
+	}
+	setJobStatus(t, s, first, batchv1.JobStatus{Failed: 1})
+	s.reconcileQueue()
+	if state, launched := jobState(t, s, second); state != "" || !launched {
+		t.Fatalf("second job state=%q launched=%v after the first failed", state, launched)
+	}
+}
+
+// gitlabServer serves neutron.yaml for every file request of the GitLab API.
+func gitlabServer(t *testing.T, pipeline string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/repository/files/") {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"content": base64.StdEncoding.EncodeToString([]byte(pipeline))})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTriggerJoinsGroup(t *testing.T) {
+	gitlab := gitlabServer(t, `
+jobs:
+  deploy:
+    image: alpine:3
+    trigger: [PUSH]
+    concurrency:
+      group: deploy
+    steps:
+      - name: deploy
+        cmd: ./deploy.sh
+`)
+	cfg := model.Config{BaseConfig: map[string]model.CodeBase{"GitLab": {Url: gitlab.URL, Token: "tok"}}}
+	s := newTestServer(t, cfg)
+	repoUrl := "git@gitlab.example.com:backend/order-service.git"
+	if err := s.repo.AddWebhookConfig(internal.PipelineProject{Id: "p1", WebhookType: "GitLab", RepoUrl: repoUrl}); err != nil {
+		t.Fatal(err)
+	}
+	trigger := func() map[string]any {
+		w := serve(s, "POST", "/api/trigger", `{"repo_url": "`+repoUrl+`", "job_name": "deploy", "ref": "main"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("trigger: %d %s", w.Code, w.Body)
+		}
+		var resp map[string]any
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	first := trigger()
+	if first["queued"] != false {
+		t.Fatalf("first trigger: %v", first)
+	}
+	dbJob, err := s.repo.GetJobByName(first["job_name"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, ok := parseSpec(dbJob.Spec)
+	if !ok || dbJob.ConcurrencyGroup != "deploy" || spec.Trigger != triggerApi {
+		t.Errorf("trigger job row: group=%q spec=%+v", dbJob.ConcurrencyGroup, spec)
+	}
+
+	// the second trigger of the same second would reuse the job name
+	dbJob.Name = "neutron-deploy-20000101-000000"
+	if err := s.repo.DB().Model(&internal.PipelineJob{}).Where("id = ?", dbJob.Id).Update("name", dbJob.Name).Error; err != nil {
+		t.Fatal(err)
+	}
+	if second := trigger(); second["queued"] != true {
+		t.Errorf("second trigger while the group is busy: %v", second)
+	}
+}
+
+// TestLauncherInvalidResources verifies that a persisted spec with a quantity
+// that is not a K8s one fails to launch instead of panicking.
+func TestLauncherInvalidResources(t *testing.T) {
+	var cfg model.Config
+	cfg.Kubernetes.Namespace = "ci"
+	srv := newTestServer(t, cfg)
+	spec := model.JobSpec{Platform: "GitLab", JobName: "build", Image: "alpine:3", CommitSha: "abc", Trigger: "PUSH", GitRepoUrl: "git@gitlab.example.com:g/app.git",
+		Resources: &model.Resources{Requests: model.ResourceSpec{Memory: "2 gigs"}}}
+	err := srv.launchJob("neutron-build-1", spec)
+	if err == nil || !strings.Contains(err.Error(), `job build: invalid resources.requests.memory "2 gigs"`) {
+		t.Errorf("launchJob() error = %v", err)
+	}
+}
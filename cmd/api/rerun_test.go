@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+
+	"neutron/internal"
+	"neutron/internal/model"
+)
+
+// addJob persists a job row with the given spec and state.
+func addJob(t *testing.T, s *Server, name string, spec model.JobSpec, state string) {
+	t.Helper()
+	if err := s.repo.AddJob(internal.PipelineJob{ProjectId: "p1", Name: name, Spec: marshalSpec(spec), State: state}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRerunManualJobWaitsForApproval(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+	spec := queueSpec("deploy", "")
+	spec.Manual = true
+	spec.Approvers = []string{"alice"}
+	addJob(t, s, "neutron-deploy-20000101-000000", spec, "")
+
+	w := serve(s, "POST", "/api/jobs/neutron-deploy-20000101-000000/rerun", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("rerun: %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		JobName         string `json:"job_name"`
+		WaitingApproval bool   `json:"waiting_approval"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !resp.WaitingApproval {
+		t.Errorf("rerun response %s, want waiting_approval", w.Body)
+	}
+	if state, launched := jobState(t, s, resp.JobName); state != internal.JobStateWaitingApproval || launched {
+		t.Errorf("rerun of a manual job state=%q launched=%v, want held for approval", state, launched)
+	}
+}
+
+func TestRerunLegacyApprovedJob(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+	// specs written before JobSpec.Manual only show the approval on the row
+	addJob(t, s, "neutron-deploy-20000101-000000", queueSpec("deploy", ""), "")
+	if err := s.repo.DB().Model(&internal.PipelineJob{}).Where("name = ?", "neutron-deploy-20000101-000000").
+		Update("approved_by", "alice").Error; err != nil {
+		t.Fatal(err)
+	}
+	w := serve(s, "POST", "/api/jobs/neutron-deploy-20000101-000000/rerun", "")
+	var resp struct {
+		JobName string `json:"job_name"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if state, launched := jobState(t, s, resp.JobName); state != internal.JobStateWaitingApproval || launched {
+		t.Errorf("rerun of an approved job state=%q launched=%v, want held for approval", state, launched)
+	}
+}
+
+func TestRerunRejectsUnlaunchedJobs(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+	for _, state := range []string{internal.JobStateWaitingApproval, internal.JobStateQueued, internal.JobStateCanceled} {
+		name := "neutron-deploy-" + state
+		addJob(t, s, name, queueSpec("deploy", ""), state)
+		if w := serve(s, "POST", "/api/jobs/"+name+"/rerun", ""); w.Code != http.StatusConflict {
+			t.Errorf("rerun of a %s job: %d %s, want 409", state, w.Code, w.Body)
+		}
+	}
+}
+
+func TestRedeployManualJobWaitsForApproval(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+	spec := queueSpec("deploy", "")
+	spec.Manual = true
+	spec.Environment = &model.Environment{Name: "production"}
+	addJob(t, s, "neutron-deploy-20000101-000000", spec, "")
+	s.recordDeployment("neutron-deploy-20000101-000000")
+
+	w := serve(s, "POST", "/api/deployments/1/redeploy", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("redeploy: %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		JobName string `json:"job_name"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if state, launched := jobState(t, s, resp.JobName); state != internal.JobStateWaitingApproval || launched {
+		t.Errorf("redeploy of a manual job state=%q launched=%v, want held for approval", state, launched)
+	}
+}
+
+func TestTriggerManualJobWaitsForApproval(t *testing.T) {
+	gitlab := gitlabServer(t, `
+jobs:
+  deploy:
+    image: alpine:3
+    trigger: [TAG]
+    when: manual
+    steps:
+      - name: deploy
+        cmd: ./deploy.sh
+`)
+	s := newTestServer(t, model.Config{BaseConfig: map[string]model.CodeBase{"GitLab": {Url: gitlab.URL, Token: "tok"}}})
+	repoUrl := "git@gitlab.example.com:backend/order-service.git"
+	if err := s.repo.AddWebhookConfig(internal.PipelineProject{Id: "p1", WebhookType: "GitLab", RepoUrl: repoUrl}); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(s, "POST", "/api/trigger", `{"repo_url": "`+repoUrl+`", "job_name": "deploy", "ref": "v1.0"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("trigger: %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		JobName string `json:"job_name"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if state, launched := jobState(t, s, resp.JobName); state != internal.JobStateWaitingApproval || launched {
+		t.Errorf("trigger of a manual job state=%q launched=%v, want held for approval", state, launched)
+	}
+}
+
+func TestDeploymentRecordedWithoutPod(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+	spec := queueSpec("deploy", "")
+	spec.Environment = &model.Environment{Name: "staging"}
+	deployed := func(name string) bool {
+		envs, err := s.repo.ListEnvironments("p1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range envs {
+			if d.JobName == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	// the K8s Job and its pod are gone: the runner's report decides
+	addJob(t, s, "neutron-deploy-gone", spec, "")
+	s.completeJob("neutron-deploy-gone", "default", "Succeeded")
+	if !deployed("neutron-deploy-gone") {
+		t.Error("deployment of a succeeded job whose K8s Job is gone not recorded")
+	}
+	addJob(t, s, "neutron-deploy-failed", spec, "")
+	s.completeJob("neutron-deploy-failed", "default", "Failed")
+	if deployed("neutron-deploy-failed") {
+		t.Error("deployment of a failed job recorded")
+	}
+
+	// the pod is gone but the K8s Job records the outcome
+	name, _, err := s.createJobFromSpec("p1", spec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setJobStatus(t, s, name, batchv1.JobStatus{Succeeded: 1})
+	s.reconcileQueue()
+	if !deployed(name) {
+		t.Error("deployment of a succeeded K8s Job not recorded by the queue worker")
+	}
+}
+
+func TestReporterTokensKeptFromTokenFileJobs(t *testing.T) {
+	s := newTestServer(t, model.Config{BaseConfig: map[string]model.CodeBase{
+		"GitLab": {Url: "https://gitlab.example.com", Token: "tok", ReportTo: []string{"mirror"}},
+		"mirror": {Type: "GitLab", Url: "https://mirror.example.com", Token: "mirror-tok"},
+	}})
+	if err := s.repo.AddWebhookConfig(internal.PipelineProject{Id: "p1", WebhookType: "GitLab", TokenFile: true}); err != nil {
+		t.Fatal(err)
+	}
+	spec := queueSpec("build", "")
+	spec.QueryParams = map[string]string{"RUNNER_REPORTERS": "neutron,platform,mirror"}
+
+	hasMirrorToken := func() bool {
+		pod := createJob(t, s.launcherFromSpec(spec)).Spec.Template.Spec
+		for _, c := range append(pod.InitContainers, pod.Containers...) {
+			for _, e := range c.Env {
+				if e.Name == "MIRROR_CODEBASE_TOKEN" {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if !hasMirrorToken() {
+		t.Fatal("job allowed to report to mirror did not get its token")
+	}
+	spec.Project = "p1"
+	if hasMirrorToken() {
+		t.Error("job keeping its token out of the step env got the mirror token")
+	}
+}
+
+// TestProjectNamespaceJobReportsToApi verifies that a job run in a project's
+// own namespace still reports to the neutron-api Service of
+// kubernetes.namespace.
+func TestProjectNamespaceJobReportsToApi(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+	spec := queueSpec("build", "")
+	spec.Namespace = "team-a"
+	job := createJob(t, s.launcherFromSpec(spec))
+	if job.Namespace != "team-a" {
+		t.Errorf("job namespace = %q, want team-a", job.Namespace)
+	}
+	const want = "http://neutron-api.default.svc.cluster.local:8888"
+	pod := job.Spec.Template.Spec
+	found := false
+	for _, c := range append(pod.InitContainers, pod.Containers...) {
+		for _, e := range c.Env {
+			if e.Name == "NEUTRON_API_URL" {
+				found = true
+				if e.Value != want {
+					t.Errorf("%s: NEUTRON_API_URL = %q, want %q", c.Name, e.Value, want)
+				}
+			}
+		}
+	}
+	if !found {
+		t.Error("no container has NEUTRON_API_URL")
+	}
+}
+
+// TestPausedProjectStartsNoJobs verifies that a paused project's jobs are
+// neither approved, rerun, redeployed nor dispatched from the queue, and that
+// its queued jobs are launched once it is resumed.
+func TestPausedProjectStartsNoJobs(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+	if err := s.repo.AddWebhookConfig(internal.PipelineProject{Id: "p1", WebhookType: "GitLab", RepoUrl: "git@gitlab.example.com:group/app.git"}); err != nil {
+		t.Fatal(err)
+	}
+	first, _, err := s.createJobFromSpec("p1", queueSpec("deploy-a", "deploy"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, queued, err := s.createJobFromSpec("p1", queueSpec("deploy-b", "deploy"), nil)
+	if err != nil || !queued {
+		t.Fatalf("second job queued=%v, %v", queued, err)
+	}
+	held := queueSpec("release", "")
+	held.Manual = true
+	held.Approvers = []string{"alice"}
+	addJob(t, s, "neutron-release-20000101-000000", held, internal.JobStateWaitingApproval)
+	deploy := queueSpec("deploy", "")
+	deploy.Environment = &model.Environment{Name: "production"}
+	addJob(t, s, "neutron-deploy-20000101-000000", deploy, "")
+	s.recordDeployment("neutron-deploy-20000101-000000")
+
+	if w := serve(s, "PATCH", "/api/projects/p1", `{"paused":true}`); w.Code != http.StatusOK {
+		t.Fatalf("pause: %d %s", w.Code, w.Body)
+	}
+	for path, body := range map[string]string{
+		"/api/jobs/neutron-release-20000101-000000/approve": `{"approver":"alice"}`,
+		"/api/jobs/neutron-deploy-20000101-000000/rerun":    "",
+		"/api/deployments/1/redeploy":                       "",
+	} {
+		if w := serve(s, "POST", path, body); w.Code != http.StatusConflict {
+			t.Errorf("%s of a paused project: %d %s, want 409", path, w.Code, w.Body)
+		}
+	}
+	setJobStatus(t, s, first, batchv1.JobStatus{Succeeded: 1})
+	if !s.completeJob(first, "default", "Succeeded") {
+		t.Fatal("first job not completed")
+	}
+	if state, launched := jobState(t, s, second); state != internal.JobStateQueued || launched {
+		t.Fatalf("queued job of a paused project state=%q launched=%v", state, launched)
+	}
+
+	if w := serve(s, "PATCH", "/api/projects/p1", `{"paused":false}`); w.Code != http.StatusOK {
+		t.Fatalf("resume: %d %s", w.Code, w.Body)
+	}
+	if state, launched := jobState(t, s, second); state != "" || !launched {
+		t.Errorf("queued job after resuming state=%q launched=%v", state, launched)
+	}
+}
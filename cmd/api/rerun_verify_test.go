@@ -4,8 +4,6 @@ import (
 	"strings"
 	"testing"
 
-	"k8s.io/client-go/kubernetes/fake"
-
 	"neutron/internal/model"
 )
 
@@ -14,12 +12,11 @@ import (
 // K8s Job carrying every input persisted in a JobSpec: annotations, env vars
 // (including webhook query params), and a checkout pinned to the exact commit.
 func TestLauncherFromSpecRebuild(t *testing.T) {
-	cfg := model.Config{Host: "http://neutron.local"}
-	cfg.Kubernetes.Namespace = "default"
+	var cfg model.Config
 	cfg.BaseConfig = map[string]model.CodeBase{
 		"GitLab": {Url: "https://gitlab.example.com", Token: "tok", SkipTLSVerify: true},
 	}
-	srv := &Server{config: cfg, clientSet: fake.NewSimpleClientset()}
+	srv := newTestServer(t, cfg)
 
 	spec := model.JobSpec{
 		Platform:    "GitLab",
@@ -35,7 +32,7 @@ func TestLauncherFromSpecRebuild(t *testing.T) {
 		QueryParams: map[string]string{"DEPLOY_ENV": "prod"},
 	}
 
-	job := srv.launcherFromSpec(spec).CreateJob(srv.config.Host)
+	job := createJob(t, srv.launcherFromSpec(spec))
 
 	if !strings.HasPrefix(job.Name, "neutron-build-") {
 		t.Errorf("job name = %q, want neutron-build-*", job.Name)
@@ -79,12 +76,7 @@ func TestLauncherFromSpecRebuild(t *testing.T) {
 // TestLauncherFromSpecMR covers the GitLab MR branch: TARGET_BRANCH env is set
 // and the checkout merges the source commit into the target branch.
 func TestLauncherFromSpecMR(t *testing.T) {
-	cfg := model.Config{Host: "http://neutron.local"}
-	cfg.Kubernetes.Namespace = "default"
-	cfg.BaseConfig = map[string]model.CodeBase{
-		"GitLab": {Url: "https://gitlab.example.com", Token: "tok"},
-	}
-	srv := &Server{config: cfg, clientSet: fake.NewSimpleClientset()}
+	srv := newTestServer(t, model.Config{})
 
 	spec := model.JobSpec{
 		Platform:     "GitLab",
@@ -98,7 +90,7 @@ func TestLauncherFromSpecMR(t *testing.T) {
 		TargetBranch: "develop",
 	}
 
-	job := srv.launcherFromSpec(spec).CreateJob(srv.config.Host)
+	job := createJob(t, srv.launcherFromSpec(spec))
 
 	env := map[string]string{}
 	for _, e := range job.Spec.Template.Spec.Containers[0].Env {
@@ -115,3 +107,40 @@ func TestLauncherFromSpecMR(t *testing.T) {
 		t.Errorf("MR checkout should clone target branch and merge: %q", checkout)
 	}
 }
+
+// TestLauncherFromSpecApprovedName covers an approved manual job: the K8s Job
+// is created under the name reserved at webhook time, and the pod env links
+// back to that same status page.
+func TestLauncherFromSpecApprovedName(t *testing.T) {
+	srv := newTestServer(t, model.Config{})
+
+	spec := model.JobSpec{
+		Platform:   "GitLab",
+		JobName:    "deploy",
+		Image:      "alpine:3",
+		ProjectId:  "7",
+		CommitSha:  "deadbeef",
+		ReportSha:  "deadbeef",
+		Trigger:    "TAG",
+		GitRepoUrl: "git@gitlab.example.com:web/portal.git",
+		Approvers:  []string{"alice"},
+	}
+
+	l := srv.launcherFromSpec(spec)
+	l.FullJobName = "neutron-deploy-20260101-120000"
+	job := createJob(t, l)
+
+	if job.Name != l.FullJobName {
+		t.Errorf("job name = %q, want %q", job.Name, l.FullJobName)
+	}
+	env := map[string]string{}
+	for _, e := range job.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["FULL_JOB_NAME"] != l.FullJobName {
+		t.Errorf("env[FULL_JOB_NAME] = %q, want %q", env["FULL_JOB_NAME"], l.FullJobName)
+	}
+	if want := "http://neutron.local/#/status/" + l.FullJobName; env["PIPELINE_URL"] != want {
+		t.Errorf("env[PIPELINE_URL] = %q, want %q", env["PIPELINE_URL"], want)
+	}
+}
@@ -3,29 +3,32 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"maps"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 
 	"neutron/internal"
 	"neutron/internal/ccwork"
-	"neutron/internal/codeup"
-	"neutron/internal/gitlab"
 	"neutron/internal/launcher"
 	"neutron/internal/model"
 	"neutron/internal/notify"
 	"neutron/internal/parser"
+	"neutron/internal/platform"
 )
 
 // Server holds the dependencies shared by all HTTP handlers.
@@ -35,16 +38,19 @@ type Server struct {
 	clientSet    kubernetes.Interface
 	notifyClient *notify.Client
 	ccworkRobot  *ccwork.Robot
+	queueMu      sync.Mutex          // serialises job admission (see queue.go)
+	podTemplate  *v1.PodTemplateSpec // kubernetes.pod-template, loaded at startup
 }
 
 // NewServer wires the server dependencies together.
-func NewServer(config model.Config, repo *internal.Repository, clientSet kubernetes.Interface, notifyClient *notify.Client, ccworkRobot *ccwork.Robot) *Server {
+func NewServer(config model.Config, repo *internal.Repository, clientSet kubernetes.Interface, notifyClient *notify.Client, ccworkRobot *ccwork.Robot, podTemplate *v1.PodTemplateSpec) *Server {
 	return &Server{
 		config:       config,
 		repo:         repo,
 		clientSet:    clientSet,
 		notifyClient: notifyClient,
 		ccworkRobot:  ccworkRobot,
+		podTemplate:  podTemplate,
 	}
 }
 
@@ -52,27 +58,56 @@ func NewServer(config model.Config, repo *internal.Repository, clientSet kuberne
 func (s *Server) registerRoutes(r *gin.Engine) {
 	r.GET("/api/config", s.handleConfig)
 	r.GET("/api/projects", s.handleListProjects)
+	r.POST("/api/projects", s.handleCreateProject)
+	r.GET("/api/projects/:id", s.handleGetProject)
+	r.PATCH("/api/projects/:id", s.handleUpdateProject)
 	r.GET("/api/projects/:id/jobs", s.handleListProjectJobs)
+	r.GET("/api/projects/:id/pipeline", s.handlePreviewPipeline)
+	r.POST("/api/lint", s.handleLintPipeline)
+	r.PUT("/api/projects/:id/token", s.handleSetProjectToken)
+	r.PUT("/api/projects/:id/hook", s.handleInstallHook)
+	r.DELETE("/api/projects/:id", s.handleDeleteProject)
+	r.GET("/api/projects/:id/environments", s.handleListEnvironments)
+	r.GET("/api/projects/:id/environments/:env/deployments", s.handleListDeployments)
+	r.POST("/api/deployments/:id/redeploy", s.handleRedeploy)
 	r.GET("/api/jobs/recent", s.handleRecentJobs)
 	r.POST("/api/register", s.handleRegister)
 	r.GET("/api/status/:jobName", s.handleStatus)
 	r.POST("/api/report/:jobName", s.handleReport)
 	r.POST("/api/report/:jobName/pod", s.handleReportPod)
 	r.POST("/api/report/:jobName/link", s.handleReportLink)
+	r.POST("/api/report/:jobName/artifacts", s.handleUploadArtifact)
+	r.GET("/api/jobs/:jobName/artifacts", s.handleListArtifacts)
+	r.GET("/api/jobs/:jobName/artifacts/*name", s.handleDownloadArtifact)
 	r.POST("/api/jobs/:jobName/rerun", s.handleRerun)
+	r.POST("/api/jobs/:jobName/approve", s.handleApprove)
+	r.GET("/api/queue", s.handleQueue)
 	r.POST("/webhook/:id", s.handleWebhook)
 	r.POST("/api/trigger", s.handleTrigger)
 }
 
 func (s *Server) handleConfig(c *gin.Context) {
+	// codebaseUrls is keyed by instance id, and by platform name for the
+	// default instance projects without an instance use
 	codebaseUrls := make(map[string]string)
-	for k, v := range s.config.BaseConfig {
-		codebaseUrls[k] = v.Url
+	codebases := []gin.H{}
+	for _, id := range slices.Sorted(maps.Keys(s.config.BaseConfig)) {
+		cb, _ := s.config.Codebase(id)
+		codebaseUrls[id] = cb.Url
+		codebases = append(codebases, gin.H{"id": id, "type": cb.Type, "url": cb.Url})
+	}
+	for _, name := range platform.Names() {
+		if id, ok := s.config.DefaultCodebase(name); ok {
+			if _, taken := codebaseUrls[name]; !taken {
+				codebaseUrls[name] = s.config.BaseConfig[id].Url
+			}
+		}
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"logUrl":       s.config.LogUrl,
 		"namespace":    s.config.Kubernetes.Namespace,
 		"codebaseUrls": codebaseUrls,
+		"codebases":    codebases,
 	})
 }
 
@@ -82,7 +117,11 @@ func (s *Server) handleListProjects(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"projects": projects})
+	resp := make([]projectResponse, len(projects))
+	for i, p := range projects {
+		resp[i] = newProjectResponse(p)
+	}
+	c.JSON(http.StatusOK, gin.H{"projects": resp})
 }
 
 func (s *Server) handleListProjectJobs(c *gin.Context) {
@@ -106,33 +145,48 @@ func (s *Server) handleRecentJobs(c *gin.Context) {
 
 func (s *Server) handleRegister(c *gin.Context) {
 	p := internal.PipelineProject{
-		Id:          uuid.New().String(),
-		WebhookType: c.PostForm("webhookType"),
-		RepoUrl:     c.PostForm("repoUrl"),
-	}
-	if err := s.repo.AddWebhookConfig(p); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	// Determine webhook URL: use platform-specific URL if configured, otherwise use config.Host
-	webhookHost := s.config.Host
-	if cb, ok := s.config.BaseConfig[p.WebhookType]; ok && cb.WebhookUrl != "" {
-		webhookHost = cb.WebhookUrl
-	}
-	webhookUrl := fmt.Sprintf("%s/webhook/%s", webhookHost, p.Id)
-	c.JSON(http.StatusOK, gin.H{
-		"id":          p.Id,
-		"webhookType": p.WebhookType,
-		"repoUrl":     p.RepoUrl,
-		"webhookUrl":  webhookUrl,
-	})
+		Id:            uuid.New().String(),
+		WebhookType:   c.PostForm("webhookType"),
+		RepoUrl:       c.PostForm("repoUrl"),
+		CodebaseId:    c.PostForm("codebaseId"),
+		Name:          c.PostForm("name"),
+		TokenFile:     c.PostForm("tokenFile") == "true",
+		CloneProtocol: c.PostForm("cloneProtocol"),
+		KnownHosts:    c.PostForm("knownHosts"),
+	}
+	s.createProject(c, p, c.PostForm("token"), c.PostForm("installHook") == "true")
 }
 
 func (s *Server) handleStatus(c *gin.Context) {
 	jobName := c.Param("jobName")
 
-	// Check if job is completed in database - if yes, return from DB only
 	dbJob, dbErr := s.repo.GetJobByName(jobName)
+	// A manual job waiting for approval or a queued job has no K8s Job yet
+	if dbErr == nil && (dbJob.State == internal.JobStateWaitingApproval || dbJob.State == internal.JobStateQueued) {
+		var status internal.JobStatus
+		_ = json.Unmarshal([]byte(dbJob.Status), &status)
+		spec, _ := parseSpec(dbJob.Spec)
+		resp := gin.H{
+			"jobName":    jobName,
+			"status":     status,
+			"job":        gin.H{"metadata": gin.H{"name": jobName}},
+			"pods":       gin.H{"items": []gin.H{}},
+			"source":     "database",
+			"state":      dbJob.State,
+			"approvers":  spec.Approvers,
+			"rerunnable": false,
+			"projectId":  dbJob.ProjectId,
+		}
+		if dbJob.State == internal.JobStateQueued {
+			if pos, err := s.repo.QueuePosition(*dbJob); err == nil {
+				resp["queuePosition"] = pos
+			}
+			resp["concurrencyGroup"] = dbJob.ConcurrencyGroup
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	// Check if job is completed in database - if yes, return from DB only
 	if dbErr == nil && dbJob.Completed {
 		var status internal.JobStatus
 		_ = json.Unmarshal([]byte(dbJob.Status), &status)
@@ -154,6 +208,7 @@ func (s *Server) handleStatus(c *gin.Context) {
 			"job":        gin.H{"metadata": gin.H{"name": jobName}},
 			"pods":       gin.H{"items": podItems},
 			"source":     "database",
+			"state":      dbJob.State,
 			"reportUrl":  reportUrl,
 			"rerunnable": dbJob.Spec != "",
 			"projectId":  dbJob.ProjectId,
@@ -162,13 +217,17 @@ func (s *Server) handleStatus(c *gin.Context) {
 	}
 
 	// Job not completed, fetch from K8s
-	jobClient := s.clientSet.BatchV1().Jobs(s.config.Kubernetes.Namespace)
+	namespace := s.config.Kubernetes.Namespace
+	if dbErr == nil {
+		namespace = s.namespace(dbJob.Namespace)
+	}
+	jobClient := s.clientSet.BatchV1().Jobs(namespace)
 	job, err := jobClient.Get(context.Background(), jobName, metav1.GetOptions{})
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	podClient := s.clientSet.CoreV1().Pods(s.config.Kubernetes.Namespace)
+	podClient := s.clientSet.CoreV1().Pods(namespace)
 	selector, _ := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
 		MatchLabels: job.Spec.Selector.MatchLabels,
 	})
@@ -224,7 +283,10 @@ func (s *Server) handleStatus(c *gin.Context) {
 
 	// If job is completed, mark it in database
 	if job.Status.Succeeded > 0 || job.Status.Failed > 0 {
-		_ = s.repo.MarkJobCompleted(jobName)
+		go s.markJobCompleted(jobName)
+	}
+	if job.Status.Succeeded > 0 {
+		s.recordDeployment(jobName)
 	}
 
 	var reportUrl string
@@ -254,6 +316,7 @@ func (s *Server) handleReport(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	namespace := s.jobNamespace(jobName)
 	// Preserve existing SourceUrl from DB if not provided in report (runners don't send it)
 	if status.SourceUrl == "" {
 		if oldStatus, err := s.repo.GetJobStatus(jobName); err == nil {
@@ -262,7 +325,7 @@ func (s *Server) handleReport(c *gin.Context) {
 	}
 	// Fallback: load SourceUrl from K8s Job annotations if still empty
 	if status.SourceUrl == "" {
-		if k8sJob, err := s.clientSet.BatchV1().Jobs(s.config.Kubernetes.Namespace).Get(context.Background(), jobName, metav1.GetOptions{}); err == nil {
+		if k8sJob, err := s.clientSet.BatchV1().Jobs(namespace).Get(context.Background(), jobName, metav1.GetOptions{}); err == nil {
 			if srcUrl := k8sJob.Annotations["sourceUrl"]; srcUrl != "" {
 				status.SourceUrl = srcUrl
 			}
@@ -276,13 +339,13 @@ func (s *Server) handleReport(c *gin.Context) {
 	if status.Succeeded == 0 && status.Failed == 0 {
 		if dbJob, err := s.repo.GetJobByName(jobName); err == nil {
 			for _, pod := range dbJob.Pods {
-				if k8sPod, err := s.clientSet.CoreV1().Pods(s.config.Kubernetes.Namespace).Get(context.Background(), pod.PodName, metav1.GetOptions{}); err == nil {
+				if k8sPod, err := s.clientSet.CoreV1().Pods(namespace).Get(context.Background(), pod.PodName, metav1.GetOptions{}); err == nil {
 					_ = s.repo.UpdatePodStatus(pod.PodUid, string(k8sPod.Status.Phase))
 				}
 			}
 		}
 	}
-	// Mark job completed and asynchronously sync final pod phase
+	// Notify, then complete the job once its K8s Job has finished
 	if status.Succeeded > 0 || status.Failed > 0 {
 		// Notify recipients: pipeline completed
 		if dbJob, err := s.repo.GetJobByName(jobName); err == nil {
@@ -308,37 +371,63 @@ func (s *Server) handleReport(c *gin.Context) {
 		if status.Failed > 0 {
 			finalPhase = "Failed"
 		}
+		// The runner reports success after every step, so only the K8s Job
+		// tells whether this was the last one.
 		go func() {
-			// Retry until pod reaches terminal phase or is gone
-			for i := 0; i < 5; i++ {
+			for i := 0; i < completionPolls; i++ {
 				time.Sleep(2 * time.Second)
-				if dbJob, err := s.repo.GetJobByName(jobName); err == nil {
-					allSynced := true
-					for _, pod := range dbJob.Pods {
-						k8sPod, err := s.clientSet.CoreV1().Pods(s.config.Kubernetes.Namespace).Get(context.Background(), pod.PodName, metav1.GetOptions{})
-						if err != nil {
-							// Pod gone — use runner's reported phase
-							_ = s.repo.UpdatePodStatus(pod.PodUid, finalPhase)
-							continue
-						}
-						phase := string(k8sPod.Status.Phase)
-						if phase == "Succeeded" || phase == "Failed" {
-							_ = s.repo.UpdatePodStatus(pod.PodUid, phase)
-						} else {
-							allSynced = false
-						}
-					}
-					if allSynced {
-						break
-					}
+				if s.completeJob(jobName, namespace, finalPhase) {
+					return
 				}
 			}
-			_ = s.repo.MarkJobCompleted(jobName)
 		}()
 	}
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
+// completionPolls bounds how often a report waits for its K8s Job to finish,
+// 2s apart. Jobs finishing later are completed by the queue worker.
+const completionPolls = 60
+
+// completeJob marks a job completed, syncs its pod phases and records its
+// deployment once its K8s Job has finished. A K8s Job that is gone finished
+// with reportedPhase, the phase of the runner's last report. It reports
+// whether the job is complete.
+func (s *Server) completeJob(jobName, namespace, reportedPhase string) bool {
+	finished, succeeded := true, reportedPhase == "Succeeded"
+	k8sJob, err := s.clientSet.BatchV1().Jobs(namespace).Get(context.Background(), jobName, metav1.GetOptions{})
+	if err == nil {
+		finished, succeeded = k8sJob.Status.Succeeded > 0 || k8sJob.Status.Failed > 0, k8sJob.Status.Succeeded > 0
+	} else if !apierrors.IsNotFound(err) {
+		return false
+	}
+	if !finished {
+		return false
+	}
+	phase := "Failed"
+	if succeeded {
+		phase = "Succeeded"
+	}
+	if dbJob, err := s.repo.GetJobByName(jobName); err == nil {
+		for _, pod := range dbJob.Pods {
+			k8sPod, err := s.clientSet.CoreV1().Pods(namespace).Get(context.Background(), pod.PodName, metav1.GetOptions{})
+			if err != nil {
+				// Pod gone — use the job's phase
+				_ = s.repo.UpdatePodStatus(pod.PodUid, phase)
+				continue
+			}
+			if p := k8sPod.Status.Phase; p == v1.PodSucceeded || p == v1.PodFailed {
+				_ = s.repo.UpdatePodStatus(pod.PodUid, string(p))
+			}
+		}
+	}
+	s.markJobCompleted(jobName)
+	if succeeded {
+		s.recordDeployment(jobName)
+	}
+	return true
+}
+
 func (s *Server) handleReportPod(c *gin.Context) {
 	jobName := c.Param("jobName")
 
@@ -408,46 +497,184 @@ func (s *Server) handleReportLink(c *gin.Context) {
 func (s *Server) handleRerun(c *gin.Context) {
 	jobName := c.Param("jobName")
 
+	dbJob, spec, ok := s.loadRerunnableJob(c, jobName)
+	if !ok {
+		return
+	}
+	createdName, err := s.rerunJob(dbJob, spec, "🔁 流水线重跑通知")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to rerun job: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":           "ok",
+		"job_name":         createdName,
+		"job_url":          fmt.Sprintf("%s/#/status/%s", s.config.Host, createdName),
+		"waiting_approval": spec.Manual,
+	})
+}
+
+// loadRerunnableJob looks up a job and its persisted spec for a rerun. On
+// failure it writes the error response and returns ok=false.
+func (s *Server) loadRerunnableJob(c *gin.Context, jobName string) (*internal.PipelineJob, model.JobSpec, bool) {
 	dbJob, err := s.repo.GetJobByName(jobName)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
-		return
+		return nil, model.JobSpec{}, false
+	}
+	if dbJob.State != "" {
+		// a held or queued job has not run yet; rerunning it would skip its approval or turn
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("job is %s; only launched jobs can be rerun", dbJob.State)})
+		return nil, model.JobSpec{}, false
+	}
+	if s.projectPaused(dbJob.ProjectId) {
+		c.JSON(http.StatusConflict, gin.H{"error": "project is paused"})
+		return nil, model.JobSpec{}, false
 	}
 	spec, ok := parseSpec(dbJob.Spec)
 	if !ok {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "job is not rerunnable (no spec; only webhook jobs can be rerun)"})
-		return
+		return nil, spec, false
 	}
-	if _, ok := s.config.BaseConfig[spec.Platform]; !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s codebase not configured", spec.Platform)})
-		return
+	if spec.Project == "" {
+		// older specs; the project's token is looked up at launch
+		spec.Project = dbJob.ProjectId
+	}
+	if dbJob.ApprovedBy != "" {
+		// older specs of manual jobs do not say so
+		spec.Manual = true
+	}
+	if _, _, err := s.codebase(spec.Codebase, spec.Platform); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, spec, false
 	}
+	return dbJob, spec, true
+}
 
+// rerunJob creates a new K8s Job from a previous job's spec and notifies the
+// original job's targets under the given title. A manual job is held for
+// approval again instead. Returns the new job name.
+func (s *Server) rerunJob(dbJob *internal.PipelineJob, spec model.JobSpec, title string) (string, error) {
 	notify := parseNotify(dbJob.Notify)
-	createdName, err := s.createJobFromSpec(dbJob.ProjectId, spec, notify)
+	var createdName string
+	var queued bool
+	var err error
+	if spec.Manual {
+		createdName, err = s.holdJobForApproval(dbJob.ProjectId, spec, notify)
+		title = "⏸️ 流水线等待审批"
+	} else {
+		createdName, queued, err = s.createJobFromSpec(dbJob.ProjectId, spec, notify)
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to rerun job: %v", err)})
-		return
+		return "", err
+	}
+	if queued {
+		title = "⏳ 流水线排队中"
 	}
 
 	// Notify the job's targets: rerun triggered
 	statusUrl := fmt.Sprintf("%s/#/status/%s", s.config.Host, createdName)
-	title := "🔁 流水线重跑通知"
-	content := fmt.Sprintf("📂 项目: %s\n📋 任务: %s\n♻️ 重跑自: %s\n🔄 触发: %s\n🔗 查看: %s", spec.GitRepoUrl, createdName, jobName, spec.Trigger, statusUrl)
+	content := fmt.Sprintf("📂 项目: %s\n📋 任务: %s\n♻️ 重跑自: %s\n🔄 触发: %s\n🔗 查看: %s", spec.GitRepoUrl, createdName, dbJob.Name, spec.Trigger, statusUrl)
 	if spec.SourceUrl != "" {
 		content += fmt.Sprintf("\n📎 源码: %s", spec.SourceUrl)
 	}
 	s.sendJobNotifications(notify, title, content)
+	return createdName, nil
+}
+
+// handleApprove launches a manual job that is waiting for approval. The K8s
+// Job is created from the persisted spec under the name reserved when the
+// webhook arrived, so status links sent in the trigger notification stay valid.
+func (s *Server) handleApprove(c *gin.Context) {
+	jobName := c.Param("jobName")
+
+	var req struct {
+		Approver string `json:"approver"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Approver == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "approver is required"})
+		return
+	}
+
+	dbJob, err := s.repo.GetJobByName(jobName)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	if dbJob.State != internal.JobStateWaitingApproval {
+		c.JSON(http.StatusConflict, gin.H{"error": "job is not waiting for approval"})
+		return
+	}
+	if s.projectPaused(dbJob.ProjectId) {
+		c.JSON(http.StatusConflict, gin.H{"error": "project is paused"})
+		return
+	}
+	spec, ok := parseSpec(dbJob.Spec)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "job has no spec to launch"})
+		return
+	}
+	if !canApprove(spec.Approvers, req.Approver) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s is not an approver of this job", req.Approver)})
+		return
+	}
+	if _, _, err := s.codebase(spec.Codebase, spec.Platform); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// An approved job still waits its turn in the queue.
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	wait, err := s.shouldQueue(dbJob.ProjectId, dbJob.ConcurrencyGroup, dbJob.Priority)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	state := ""
+	if wait {
+		state = internal.JobStateQueued
+	}
+	claimed, err := s.repo.ClaimApproval(jobName, req.Approver, state)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !claimed {
+		c.JSON(http.StatusConflict, gin.H{"error": "job is not waiting for approval"})
+		return
+	}
+	if wait {
+		s.dispatchLocked()
+		wait = s.isStillQueued(jobName)
+	} else if err := s.launchJob(jobName, spec); err != nil {
+		_ = s.repo.RevertApproval(jobName)
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to launch job: %v", err)})
+		return
+	}
+
+	// Notify the job's targets: manual job approved
+	statusUrl := fmt.Sprintf("%s/#/status/%s", s.config.Host, jobName)
+	title := "✅ 流水线审批通过"
+	content := fmt.Sprintf("📂 项目: %s\n📋 任务: %s\n👤 审批人: %s\n🔗 查看: %s", spec.GitRepoUrl, jobName, req.Approver, statusUrl)
+	if spec.SourceUrl != "" {
+		content += fmt.Sprintf("\n📎 源码: %s", spec.SourceUrl)
+	}
+	s.sendJobNotifications(parseNotify(dbJob.Notify), title, content)
 
 	c.JSON(http.StatusOK, gin.H{
-		"status":   "ok",
-		"job_name": createdName,
-		"job_url":  statusUrl,
+		"status":      "ok",
+		"job_name":    jobName,
+		"job_url":     statusUrl,
+		"approved_by": req.Approver,
+		"queued":      wait,
 	})
 }
 
 // parsedHook holds the platform-agnostic result of parsing an incoming webhook.
 type parsedHook struct {
+	base         *parser.Base // reads files of the pushed repository
 	pipeline     model.Pipeline
 	trigger      string
 	codeSha      string
@@ -455,57 +682,32 @@ type parsedHook struct {
 	targetBranch string
 	codeRef      string
 	sourceUrl    string
+	triggeredBy  string
 	projectId    int
 }
 
-// parseWebhook parses a GitLab or Codeup webhook body and normalizes the
-// fields the launcher and notifications need.
-func parseWebhook(platform string, body io.ReadCloser, cb model.CodeBase, repoUrl string) (parsedHook, error) {
+// parseWebhook parses a webhook body with the project's platform and
+// normalizes the fields the launcher and notifications need. The pipeline
+// itself is fetched afterwards through base.
+func parseWebhook(platformName string, header http.Header, body io.ReadCloser, cb model.CodeBase, repoUrl string) (parsedHook, error) {
 	var ph parsedHook
-	switch platform {
-	case "GitLab":
-		p, err := gitlab.NewGitLabParser(body, cb.Url, cb.Token, cb.SkipTLSVerify)
-		if err != nil {
-			return ph, err
-		}
-		pipeline, err := p.Parse()
-		if err != nil {
-			return ph, fmt.Errorf("failed to parse pipeline: %w", err)
-		}
-		ph.pipeline = pipeline
-		ph.trigger = p.Trigger
-		ph.codeSha = p.CodeSha
-		ph.reportSha = p.ReportSha
-		ph.targetBranch = p.TargetBranch
-		ph.codeRef = codeRefForTrigger(p.Trigger, p.Request.Ref)
-		ph.projectId = p.Request.Project.Id
-		ph.sourceUrl = parser.BuildSourceUrl("GitLab", p.Trigger, cb.Url, repoUrl, p.Request.Ref, p.CodeSha, p.Request.Attributes.Iid)
-	case "Codeup":
-		p, err := codeup.NewCodeupParser(body, cb.Url, cb.Token, cb.SkipTLSVerify)
-		if err != nil {
-			return ph, err
-		}
-		pipeline, err := p.Parse()
-		if err != nil {
-			return ph, fmt.Errorf("failed to parse pipeline: %w", err)
-		}
-		ph.pipeline = pipeline
-		ph.trigger = p.Trigger
-		ph.codeSha = p.CodeSha
-		ph.reportSha = p.ReportSha
-		ph.targetBranch = p.TargetBranch
-		ph.codeRef = codeRefForTrigger(p.Trigger, p.Request.Ref)
-		ph.projectId = p.Request.Project.Id
-		if ph.projectId == 0 {
-			ph.projectId = p.Request.ProjectId
-		}
-		if ph.projectId == 0 {
-			ph.projectId = p.Request.Attributes.ProjectId
-		}
-		ph.sourceUrl = parser.BuildSourceUrl("Codeup", p.Trigger, cb.Url, repoUrl, p.Request.Ref, p.CodeSha, p.Request.Attributes.Iid)
-	default:
-		return ph, fmt.Errorf("unsupported platform: %s", platform)
+	p, err := platform.Get(platformName)
+	if err != nil {
+		return ph, err
 	}
+	hook, err := p.ParseWebhook(header, body, cb)
+	if err != nil {
+		return ph, err
+	}
+	ph.base = hook.Base
+	ph.trigger = hook.Trigger
+	ph.codeSha = hook.CodeSha
+	ph.reportSha = hook.ReportSha
+	ph.targetBranch = hook.TargetBranch
+	ph.codeRef = codeRefForTrigger(hook.Trigger, hook.Ref)
+	ph.projectId = hook.ProjectId
+	ph.triggeredBy = hook.TriggeredBy
+	ph.sourceUrl = p.SourceUrl(hook.Trigger, cb.Url, repoUrl, hook.Ref, hook.CodeSha, hook.MrIid)
 	return ph, nil
 }
 
@@ -517,30 +719,77 @@ func (s *Server) handleWebhook(c *gin.Context) {
 		return
 	}
 
-	platform := webhookConfig.WebhookType
-	if _, ok := s.config.BaseConfig[platform]; !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s codebase not configured", platform)})
+	platformName := webhookConfig.WebhookType
+	codebaseId, cb, err := s.projectCodebase(webhookConfig)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	ph, err := parseWebhook(platform, c.Request.Body, s.config.BaseConfig[platform], webhookConfig.RepoUrl)
+	ph, err := parseWebhook(platformName, c.Request.Header, c.Request.Body, cb, webhookConfig.RepoUrl)
+	if errors.Is(err, platform.ErrPing) {
+		c.JSON(http.StatusOK, gin.H{"status": "pong"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	// A push to the snippet source mirrors its snippet directory; the project
+	// does not need a neutron.yaml.
+	var synced *snippetSyncResult
+	if s.isSnippetSyncPush(id, ph) {
+		if synced, err = s.syncSnippets(ph.base, ph.codeSha, ph.triggeredBy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to sync snippets: %v", err)})
+			return
+		}
+	}
+
+	// A paused project acknowledges webhooks without starting jobs
+	if webhookConfig.Paused {
+		resp := gin.H{"status": "paused"}
+		if synced != nil {
+			resp["snippets"] = synced
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	s.refreshMirror(webhookConfig, codebaseId)
+
+	ph.pipeline, err = ph.base.Parse(s.projectFetcher)
+	if err != nil {
+		if synced != nil && errors.Is(err, parser.ErrFileNotFound) {
+			c.JSON(http.StatusOK, gin.H{"status": "ok", "snippets": synced})
+			return
+		}
+		if !errors.Is(err, parser.ErrFileNotFound) {
+			go s.reportPipelineError(platformName, cb, webhookConfig.RepoUrl, ph, err)
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to parse pipeline: %v", err)})
+		return
+	}
+
 	var jobs []string
 	for jobName, job := range ph.pipeline.Jobs {
 		if !isValidTrigger(ph.trigger, job.Trigger) {
 			continue
 		}
+		steps, err := s.expandSnippetSteps(job.Steps)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("job %s: %v", jobName, err)})
+			return
+		}
 
 		// Build the rerun snapshot from this webhook's parsed inputs.
 		spec := model.JobSpec{
-			Platform:     platform,
+			Platform:     platformName,
+			Codebase:     codebaseId,
+			Project:      id,
+			Namespace:    webhookConfig.Namespace,
 			JobName:      jobName,
 			Image:        job.Image,
-			Resources:    job.Resources,
+			Resources:    projectResources(webhookConfig, job.Resources),
 			ProjectId:    strconv.Itoa(ph.projectId),
 			CommitSha:    ph.codeSha,
 			ReportSha:    ph.reportSha,
@@ -550,9 +799,29 @@ func (s *Server) handleWebhook(c *gin.Context) {
 			CodeRef:      ph.codeRef,
 			SourceUrl:    ph.sourceUrl,
 			QueryParams:  firstQueryValues(c.Request.URL.Query()),
+			Manual:       job.IsManual(),
+			Approvers:    job.Approvers,
+			Environment:  job.Environment,
+			TriggeredBy:  ph.triggeredBy,
+			Concurrency:  job.Concurrency,
+			Checkout:     job.Checkout,
+			Scheduling:   jobScheduling(job),
+			Steps:        steps,
 		}
 
-		createdName, err := s.createJobFromSpec(id, spec, job.Notify)
+		var createdName string
+		var queued bool
+		title := "🚀 流水线触发通知"
+		notify := projectNotify(webhookConfig, job.Notify)
+		if spec.Manual {
+			createdName, err = s.holdJobForApproval(id, spec, notify)
+			title = "⏸️ 流水线等待审批"
+		} else {
+			createdName, queued, err = s.createJobFromSpec(id, spec, notify)
+		}
+		if queued {
+			title = "⏳ 流水线排队中"
+		}
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
@@ -561,27 +830,29 @@ func (s *Server) handleWebhook(c *gin.Context) {
 
 		// Notify this job's targets: pipeline triggered
 		statusUrl := fmt.Sprintf("%s/#/status/%s", s.config.Host, createdName)
-		title := "🚀 流水线触发通知"
 		content := fmt.Sprintf("📂 项目: %s\n📋 任务: %s\n🔄 触发: %s\n🔗 查看: %s", webhookConfig.RepoUrl, createdName, ph.trigger, statusUrl)
 		if ph.sourceUrl != "" {
 			content += fmt.Sprintf("\n📎 源码: %s", ph.sourceUrl)
 		}
-		s.sendJobNotifications(job.Notify, title, content)
+		s.sendJobNotifications(notify, title, content)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "pipeline": ph.pipeline, "jobs": jobs})
+	resp := gin.H{"status": "ok", "pipeline": ph.pipeline, "jobs": jobs}
+	if synced != nil {
+		resp["snippets"] = synced
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 // launcherFromSpec rebuilds the RunnerConfig + extra env from a JobSpec and
 // returns a configured launcher. Tokens/URLs are resolved from the current
-// config (not the spec). This is the pure manifest-construction step shared by
-// the webhook and rerun paths; it has no side effects, so it is unit-testable.
+// config and the spec's project (not the spec). This is the pure
+// manifest-construction step shared by the webhook and rerun paths; it has no
+// side effects, so it is unit-testable.
 func (s *Server) launcherFromSpec(spec model.JobSpec) *launcher.Launcher {
-	platform := spec.Platform
-	baseCfg := s.config.BaseConfig[platform]
-	if pod, ok := s.config.PodCodeBase[platform]; ok {
-		baseCfg = pod
-	}
+	platformName := spec.Platform
+	codebaseId, _, _ := s.codebase(spec.Codebase, platformName)
+	baseCfg := s.config.PodCodebase(codebaseId)
 
 	runnerConfig := model.RunnerConfig{
 		CodebaseToken: baseCfg.Token,
@@ -592,49 +863,120 @@ func (s *Server) launcherFromSpec(spec model.JobSpec) *launcher.Launcher {
 		JobName:       spec.JobName,
 		Trigger:       spec.Trigger,
 		GitRepoUrl:    spec.GitRepoUrl,
+		CloneUrl:      cloneUrl(platformName, spec.GitRepoUrl),
 		GitPrivateKey: "/etc/ssh/id_rsa",
 		TargetBranch:  spec.TargetBranch,
 		CodeRef:       spec.CodeRef,
 		SourceUrl:     spec.SourceUrl,
+		Steps:         spec.Steps,
+		Checkout:      spec.Checkout,
+	}
+	if spec.Trigger == triggerApi {
+		// the job was asked for by name, and there is no webhook commit to report on
+		runnerConfig.SkipTriggerCheck = true
+		runnerConfig.SkipPlatformReport = true
 	}
+	s.applyProjectToken(spec.Project, &runnerConfig)
 
 	var extraEnv []v1.EnvVar
-	extraEnv = append(extraEnv, v1.EnvVar{Name: "RUNNER_PLATFORM", Value: strings.ToLower(platform)})
+	extraEnv = append(extraEnv, v1.EnvVar{Name: "RUNNER_PLATFORM", Value: strings.ToLower(platformName)})
+	extraEnv = append(extraEnv, v1.EnvVar{Name: "CODEBASE_ID", Value: codebaseId})
 	if baseCfg.SkipTLSVerify {
 		extraEnv = append(extraEnv, v1.EnvVar{Name: "SKIP_TLS_VERIFY", Value: "true"})
 	}
-	if platform == "GitLab" && spec.TargetBranch != "" {
+	if spec.TargetBranch != "" {
 		extraEnv = append(extraEnv, v1.EnvVar{Name: "TARGET_BRANCH", Value: spec.TargetBranch})
 	}
+	extraEnv = append(extraEnv, s.reporterCodebaseEnv(spec.QueryParams["RUNNER_REPORTERS"], codebaseId, runnerConfig.TokenFile)...)
 	for key, value := range spec.QueryParams {
 		extraEnv = append(extraEnv, v1.EnvVar{Name: key, Value: value})
 	}
 
-	return s.buildLauncher(runnerConfig, spec.Image, spec.Resources, platform, extraEnv)
+	l := s.buildLauncher(spec.Namespace, runnerConfig, spec.Image, spec.Resources, platformName, extraEnv)
+	s.applyProjectCheckout(spec.Project, codebaseId, l)
+	l.Scheduling = spec.Scheduling
+	return l
 }
 
 // createJobFromSpec builds the K8s Job from a JobSpec (via launcherFromSpec),
 // creates it, and persists the DB row carrying the same spec (so the job can be
-// rerun again). Returns the generated K8s Job name.
-func (s *Server) createJobFromSpec(projectId string, spec model.JobSpec, notify *model.Notify) (string, error) {
-	l := s.launcherFromSpec(spec)
-	jobClient := s.clientSet.BatchV1().Jobs(s.config.Kubernetes.Namespace)
-	createdJob, err := jobClient.Create(context.Background(), l.CreateJob(s.config.Host), metav1.CreateOptions{})
+// rerun again). When the job has to wait (busy concurrency group, concurrency
+// limit reached, or earlier jobs queued) the row is persisted as Queued instead
+// and launched later by dispatchQueue; with cancel_in_progress the group's
+// running and queued jobs are cancelled first. Returns the K8s Job name and
+// whether the job was queued.
+func (s *Server) createJobFromSpec(projectId string, spec model.JobSpec, notify *model.Notify) (string, bool, error) {
+	if err := s.checkSpec(spec); err != nil {
+		return "", false, err
+	}
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+
+	job := internal.PipelineJob{
+		ProjectId:        projectId,
+		Name:             launcher.JobName(spec.JobName, time.Now()),
+		Notify:           marshalNotify(notify),
+		Spec:             marshalSpec(spec),
+		ConcurrencyGroup: spec.Concurrency.GroupName(),
+		Priority:         jobPriority(spec, false),
+		Namespace:        spec.Namespace,
+	}
+	if job.ConcurrencyGroup != "" && spec.Concurrency.CancelInProgress {
+		s.cancelGroup(projectId, job.ConcurrencyGroup)
+	}
+	wait, err := s.shouldQueue(projectId, job.ConcurrencyGroup, job.Priority)
 	if err != nil {
+		return "", false, err
+	}
+	if wait {
+		job.State = internal.JobStateQueued
+		job.Status = pendingStatus(spec)
+		if err := s.repo.AddJob(job); err != nil {
+			return "", false, err
+		}
+		s.recordSnippetSteps(spec.Steps, job.Name, projectId)
+		// A free slot goes to the head of the queue, which may be this job.
+		s.dispatchLocked()
+		return job.Name, s.isStillQueued(job.Name), nil
+	}
+	if err := s.launchJob(job.Name, spec); err != nil {
+		return "", false, err
+	}
+	if err := s.repo.AddJob(job); err != nil {
+		return "", false, err
+	}
+	s.recordSnippetSteps(spec.Steps, job.Name, projectId)
+	return job.Name, false, nil
+}
+
+// holdJobForApproval persists a manual job in WaitingApproval without creating
+// its K8s Job. The name is reserved now so the trigger notification can link to
+// it; handleApprove later launches the job from the same spec under that name.
+func (s *Server) holdJobForApproval(projectId string, spec model.JobSpec, notify *model.Notify) (string, error) {
+	if err := s.checkSpec(spec); err != nil {
 		return "", err
 	}
+	name := launcher.JobName(spec.JobName, time.Now())
 	if err := s.repo.AddJob(internal.PipelineJob{
-		ProjectId: projectId,
-		Name:      createdJob.Name,
-		Status:    "",
-		Notify:    marshalNotify(notify),
-		Spec:      marshalSpec(spec),
+		ProjectId:        projectId,
+		Name:             name,
+		Status:           pendingStatus(spec),
+		Notify:           marshalNotify(notify),
+		Spec:             marshalSpec(spec),
+		State:            internal.JobStateWaitingApproval,
+		ConcurrencyGroup: spec.Concurrency.GroupName(),
+		Priority:         jobPriority(spec, true),
+		Namespace:        spec.Namespace,
 	}); err != nil {
 		return "", err
 	}
-	return createdJob.Name, nil
+	s.recordSnippetSteps(spec.Steps, name, projectId)
+	return name, nil
 }
 
+// triggerApi is the trigger of jobs started through /api/trigger.
+const triggerApi = "API"
+
 func (s *Server) handleTrigger(c *gin.Context) {
 	var req struct {
 		RepoUrl string            `json:"repo_url"`
@@ -657,21 +999,21 @@ func (s *Server) handleTrigger(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "project not found for repo_url: " + req.RepoUrl})
 		return
 	}
-	platform := project.WebhookType
-
-	// Get platform config
-	baseCfg, ok := s.config.BaseConfig[platform]
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("platform %s not configured", platform)})
+	if project.Paused {
+		c.JSON(http.StatusConflict, gin.H{"error": "project is paused"})
 		return
 	}
-	podCfg := baseCfg
-	if pod, ok := s.config.PodCodeBase[platform]; ok {
-		podCfg = pod
+	platformName := project.WebhookType
+
+	// Get the project's codebase instance
+	codebaseId, baseCfg, err := s.projectCodebase(project)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	// Fetch neutron.yaml from repo at given ref
-	pipeline, err := parser.FetchPipeline(platform, req.RepoUrl, req.Ref, baseCfg.Url, baseCfg.Token, baseCfg.SkipTLSVerify)
+	pipeline, err := platform.FetchPipeline(project.WebhookType, req.RepoUrl, req.Ref, baseCfg, s.projectFetcher)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to fetch pipeline: %v", err)})
 		return
@@ -684,70 +1026,74 @@ func (s *Server) handleTrigger(c *gin.Context) {
 		return
 	}
 
-	// Build runner config
-	runnerConfig := model.RunnerConfig{
-		CodebaseToken:      podCfg.Token,
-		CodebaseUrl:        podCfg.Url,
-		ProjectId:          "api",
-		CommitSha:          req.Ref,
-		ReportSha:          req.Ref,
-		JobName:            req.JobName,
-		Trigger:            "API",
-		GitRepoUrl:         req.RepoUrl,
-		GitPrivateKey:      "/etc/ssh/id_rsa",
-		SkipTriggerCheck:   true,
-		SkipPlatformReport: true,
-		CodeRef:            codeRefForTrigger("API", req.Ref),
-	}
-
-	// Build extra env vars
-	var extraEnv []v1.EnvVar
-	extraEnv = append(extraEnv, v1.EnvVar{Name: "RUNNER_PLATFORM", Value: strings.ToLower(platform)})
-	if podCfg.SkipTLSVerify {
-		extraEnv = append(extraEnv, v1.EnvVar{Name: "SKIP_TLS_VERIFY", Value: "true"})
-	}
-	// Inject user-provided env vars
-	for key, value := range req.Env {
-		extraEnv = append(extraEnv, v1.EnvVar{Name: key, Value: value})
-	}
-
-	// Create K8s Job
-	l := s.buildLauncher(runnerConfig, job.Image, job.Resources, platform, extraEnv)
-	jobClient := s.clientSet.BatchV1().Jobs(s.config.Kubernetes.Namespace)
-	createdJob, err := jobClient.Create(context.Background(), l.CreateJob(s.config.Host), metav1.CreateOptions{})
+	steps, err := s.expandSnippetSteps(job.Steps)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to create job: %v", err)})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Save job to database
-	if err := s.repo.AddJob(internal.PipelineJob{
-		ProjectId: project.Id,
-		Name:      createdJob.Name,
-		Status:    "",
-		Notify:    marshalNotify(job.Notify),
-	}); err != nil {
-		log.Printf("failed to save job to database: %v", err)
+	// API jobs go through the same queue, limits and concurrency groups as
+	// webhook jobs, and can be rerun from their spec.
+	spec := model.JobSpec{
+		Platform:    platformName,
+		Codebase:    codebaseId,
+		Project:     project.Id,
+		Namespace:   project.Namespace,
+		JobName:     req.JobName,
+		Image:       job.Image,
+		Resources:   projectResources(project, job.Resources),
+		ProjectId:   "api",
+		CommitSha:   req.Ref,
+		ReportSha:   req.Ref,
+		Trigger:     triggerApi,
+		GitRepoUrl:  req.RepoUrl,
+		CodeRef:     codeRefForTrigger(triggerApi, req.Ref),
+		QueryParams: req.Env,
+		Manual:      job.IsManual(),
+		Approvers:   job.Approvers,
+		Environment: job.Environment,
+		Concurrency: job.Concurrency,
+		Checkout:    job.Checkout,
+		Scheduling:  jobScheduling(job),
+		Steps:       steps,
+	}
+	var createdName string
+	var queued bool
+	title := "🚀 流水线触发通知 (API)"
+	notify := projectNotify(project, job.Notify)
+	if spec.Manual {
+		createdName, err = s.holdJobForApproval(project.Id, spec, notify)
+		title = "⏸️ 流水线等待审批 (API)"
+	} else {
+		createdName, queued, err = s.createJobFromSpec(project.Id, spec, notify)
+	}
+	if queued {
+		title = "⏳ 流水线排队中 (API)"
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	// Send notifications
-	statusUrl := fmt.Sprintf("%s/#/status/%s", s.config.Host, createdJob.Name)
-	title := "🚀 流水线触发通知 (API)"
+	statusUrl := fmt.Sprintf("%s/#/status/%s", s.config.Host, createdName)
 	content := fmt.Sprintf("📂 项目: %s\n📋 作业: %s\n🏷️ Ref: %s\n🔗 查看: %s", req.RepoUrl, req.JobName, req.Ref, statusUrl)
-	s.sendJobNotifications(job.Notify, title, content)
+	s.sendJobNotifications(notify, title, content)
 
 	c.JSON(http.StatusOK, gin.H{
-		"status":   "ok",
-		"job_name": createdJob.Name,
-		"job_url":  statusUrl,
+		"status":           "ok",
+		"job_name":         createdName,
+		"job_url":          statusUrl,
+		"queued":           queued,
+		"waiting_approval": spec.Manual,
 	})
 }
 
 // buildLauncher constructs a launcher with the K8s settings shared by the
-// webhook and trigger flows.
-func (s *Server) buildLauncher(rc model.RunnerConfig, image string, resources *model.Resources, platform string, extraEnv []v1.EnvVar) *launcher.Launcher {
-	return launcher.NewLauncher(
-		s.config.Kubernetes.Namespace,
+// webhook and trigger flows; an empty namespace is kubernetes.namespace.
+func (s *Server) buildLauncher(namespace string, rc model.RunnerConfig, image string, resources *model.Resources, platform string, extraEnv []v1.EnvVar) *launcher.Launcher {
+	l := launcher.NewLauncher(
+		s.namespace(namespace),
 		rc,
 		s.config.Kubernetes.InitImage,
 		s.config.Kubernetes.CheckoutImage,
@@ -759,6 +1105,48 @@ func (s *Server) buildLauncher(rc model.RunnerConfig, image string, resources *m
 		resources,
 		extraEnv...,
 	)
+	l.ApiNamespace = s.config.Kubernetes.Namespace
+	l.KnownHostsSecret = s.config.Kubernetes.KnownHostsSecret
+	l.GitCache = s.config.Kubernetes.GitCache
+	l.PodTemplate = s.podTemplate
+	l.Policy = s.config.Kubernetes.Policy
+	return l
+}
+
+// jobScheduling returns the scheduling a job sets in neutron.yaml, or nil.
+func jobScheduling(job model.Job) *model.Scheduling {
+	if job.Scheduling.IsZero() {
+		return nil
+	}
+	scheduling := job.Scheduling
+	return &scheduling
+}
+
+// validateScheduling checks a job's scheduling against kubernetes.scheduling.
+func (s *Server) validateScheduling(jobName string, scheduling *model.Scheduling) error {
+	if err := launcher.ValidateScheduling(scheduling, s.config.Kubernetes.Scheduling); err != nil {
+		return fmt.Errorf("job %s: %w", jobName, err)
+	}
+	return nil
+}
+
+// checkSpec rejects a job whose scheduling or pod breaks the admin's rules
+// when it is triggered, rather than when a queued or held job is launched.
+func (s *Server) checkSpec(spec model.JobSpec) error {
+	if err := s.validateScheduling(spec.JobName, spec.Scheduling); err != nil {
+		return err
+	}
+	_, err := s.launcherFromSpec(spec).CreateJob(s.config.Host)
+	return err
+}
+
+// cloneUrl is the URL the checkout clones a repository of a platform from.
+func cloneUrl(platformName, repoUrl string) string {
+	p, err := platform.Get(platformName)
+	if err != nil {
+		return repoUrl
+	}
+	return p.CloneUrl(repoUrl)
 }
 
 func isValidTrigger(currentTrigger string, validTriggers []string) bool {
@@ -770,6 +1158,20 @@ func isValidTrigger(currentTrigger string, validTriggers []string) bool {
 	return false
 }
 
+// canApprove reports whether approver may approve a manual job. An empty
+// approver list allows anyone.
+func canApprove(approvers []string, approver string) bool {
+	if len(approvers) == 0 {
+		return true
+	}
+	for _, a := range approvers {
+		if a == approver {
+			return true
+		}
+	}
+	return false
+}
+
 // codeRefForTrigger returns the CODE_REF value: tag name for TAG, branch name for PUSH, empty for MR.
 func codeRefForTrigger(trigger, ref string) string {
 	if trigger == "MR" {
@@ -841,3 +1243,54 @@ func firstQueryValues(q url.Values) map[string]string {
 	}
 	return m
 }
+
+// codebase resolves the codebase instance of a project or job spec: the
+// instance id it is bound to, or for rows from before instances existed the
+// default instance of its platform. It returns the instance id.
+func (s *Server) codebase(id, platformName string) (string, model.CodeBase, error) {
+	if id == "" {
+		var ok bool
+		if id, ok = s.config.DefaultCodebase(platformName); !ok {
+			return "", model.CodeBase{}, fmt.Errorf("%s codebase not configured", platformName)
+		}
+	}
+	cb, ok := s.config.Codebase(id)
+	if !ok {
+		return "", model.CodeBase{}, fmt.Errorf("codebase %s not configured", id)
+	}
+	return id, cb, nil
+}
+
+// reporterCodebaseEnv passes the runner the credentials of the codebase
+// instances named in a job's RUNNER_REPORTERS other than its own, as the
+// <ID>_PLATFORM, _CODEBASE_URL, _CODEBASE_TOKEN and _SKIP_TLS_VERIFY variables
+// the runner's reporters read. RUNNER_REPORTERS is set by whoever triggers the
+// job, so only the instances in the job's instance's report_to are passed, and
+// none to a job that keeps its own token out of the step env.
+func (s *Server) reporterCodebaseEnv(reporters, codebaseId string, tokenFile bool) []v1.EnvVar {
+	if tokenFile {
+		return nil
+	}
+	own, _ := s.config.Codebase(codebaseId)
+	var env []v1.EnvVar
+	for _, name := range strings.Split(reporters, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == codebaseId || !slices.Contains(own.ReportTo, name) {
+			continue
+		}
+		if _, ok := s.config.Codebase(name); !ok {
+			continue
+		}
+		cb := s.config.PodCodebase(name)
+		prefix := platform.EnvName(name) + "_"
+		env = append(env,
+			v1.EnvVar{Name: prefix + "PLATFORM", Value: strings.ToLower(cb.Type)},
+			v1.EnvVar{Name: prefix + "CODEBASE_URL", Value: cb.Url},
+			v1.EnvVar{Name: prefix + "CODEBASE_TOKEN", Value: cb.Token},
+		)
+		if cb.SkipTLSVerify {
+			env = append(env, v1.EnvVar{Name: prefix + "SKIP_TLS_VERIFY", Value: "true"})
+		}
+	}
+	return env
+}
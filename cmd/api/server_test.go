@@ -0,0 +1,232 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"neutron/internal/launcher"
+	"neutron/internal/model"
+	"neutron/internal/parser"
+)
+
+// TestLauncherFromSpecCodebaseInstance covers two instances of one platform:
+// the job gets the URL and token of the instance its spec is bound to (as
+// runner pods reach it), and the credentials of another instance named in
+// RUNNER_REPORTERS under that instance's prefix.
+func TestLauncherFromSpecCodebaseInstance(t *testing.T) {
+	var cfg model.Config
+	cfg.BaseConfig = map[string]model.CodeBase{
+		"GitLab": {Url: "https://gitlab.example.com", Token: "tok"},
+		"gitlab-acme": {Type: "GitLab", Url: "https://gitlab.acme.example.com", Token: "acme-tok", ReportTo: []string{"GitLab"},
+			Pod: &model.CodeBase{Url: "http://gitlab.acme.svc"}},
+	}
+	srv := newTestServer(t, cfg)
+
+	spec := model.JobSpec{
+		Platform:    "GitLab",
+		Codebase:    "gitlab-acme",
+		JobName:     "build",
+		Image:       "alpine:3",
+		ProjectId:   "7",
+		CommitSha:   "deadbeef",
+		ReportSha:   "deadbeef",
+		Trigger:     "PUSH",
+		GitRepoUrl:  "git@gitlab.acme.example.com:web/portal.git",
+		QueryParams: map[string]string{"RUNNER_REPORTERS": "neutron,platform,GitLab"},
+	}
+
+	job := createJob(t, srv.launcherFromSpec(spec))
+
+	env := map[string]string{}
+	for _, e := range job.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	for k, want := range map[string]string{
+		"CODEBASE_URL": "http://gitlab.acme.svc", "CODEBASE_TOKEN": "acme-tok", "CODEBASE_ID": "gitlab-acme",
+		"RUNNER_PLATFORM": "gitlab", "GITLAB_PLATFORM": "gitlab",
+		"GITLAB_CODEBASE_URL": "https://gitlab.example.com", "GITLAB_CODEBASE_TOKEN": "tok",
+	} {
+		if env[k] != want {
+			t.Errorf("env[%s] = %q, want %q", k, env[k], want)
+		}
+	}
+
+	// Jobs of an instance without report_to get no other instance's token
+	spec.Codebase = "GitLab"
+	spec.QueryParams = map[string]string{"RUNNER_REPORTERS": "neutron,platform,gitlab-acme"}
+	for _, e := range createJob(t, srv.launcherFromSpec(spec)).Spec.Template.Spec.Containers[0].Env {
+		if strings.HasPrefix(e.Name, "GITLAB_ACME_") {
+			t.Errorf("env %s passed to a job whose instance may not report to gitlab-acme", e.Name)
+		}
+	}
+
+	// Older specs without an instance use the platform's default one
+	spec.Codebase = ""
+	if id, _, err := srv.codebase(spec.Codebase, spec.Platform); err != nil || id != "GitLab" {
+		t.Errorf("codebase(%q, GitLab) = %q, %v; want GitLab", spec.Codebase, id, err)
+	}
+}
+
+// TestLauncherCheckoutOptions verifies that the checkout options persisted on
+// the spec reach the checkout; the script itself is covered in the launcher.
+func TestLauncherCheckoutOptions(t *testing.T) {
+	srv := newTestServer(t, model.Config{})
+
+	spec := model.JobSpec{
+		Platform:   "GitLab",
+		JobName:    "test",
+		Image:      "node:18",
+		CommitSha:  "deadbeef",
+		Trigger:    "PUSH",
+		GitRepoUrl: "git@gitlab.example.com:web/portal.git",
+		Checkout:   &model.Checkout{Depth: 1},
+	}
+	checkout := createJob(t, srv.launcherFromSpec(spec)).Spec.Template.Spec.InitContainers[0].Command[2]
+	if !strings.Contains(checkout, "git fetch -q --depth 1 origin 'deadbeef'") {
+		t.Errorf("checkout %q is not shallow", checkout)
+	}
+}
+
+// TestLauncherHttpsCheckout covers HTTPS checkout, which must not need the SSH
+// key Secret, and host keys pinned by a known_hosts Secret.
+func TestLauncherHttpsCheckout(t *testing.T) {
+	var cfg model.Config
+	cfg.Kubernetes.GitPrivateKey = "git-ssh-key"
+	cfg.Kubernetes.KnownHostsSecret = "gitlab-known-hosts"
+	srv := newTestServer(t, cfg)
+
+	l := srv.launcherFromSpec(model.JobSpec{Platform: "GitLab", JobName: "build", Image: "alpine:3", CommitSha: "abc", Trigger: "PUSH", GitRepoUrl: "git@gitlab.example.com:g/app.git"})
+	pod := createJob(t, l).Spec.Template.Spec
+	if len(pod.Volumes) != 4 || pod.Volumes[2].Secret.SecretName != "git-ssh-key" || pod.Volumes[3].Secret.SecretName != "gitlab-known-hosts" {
+		t.Errorf("SSH checkout volumes = %+v", pod.Volumes)
+	}
+	if env := pod.InitContainers[0].Env; !strings.Contains(env[0].Value, "StrictHostKeyChecking=yes") {
+		t.Errorf("SSH checkout env = %+v, want host keys checked", env)
+	}
+
+	l.KnownHostsSecret = ""
+	l.RunnerConfig.CloneUrl = "https://gitlab.example.com/g/app.git"
+	l.HttpsUser, l.HttpsPassword = "oauth2", "tok"
+	pod = createJob(t, l).Spec.Template.Spec
+	if len(pod.Volumes) != 2 {
+		t.Errorf("HTTPS checkout volumes = %+v, want no Secret", pod.Volumes)
+	}
+	checkout := pod.InitContainers[0]
+	script := checkout.Command[2]
+	if !strings.Contains(script, "GIT_ASKPASS") || !strings.Contains(script, "git clone 'https://gitlab.example.com/g/app.git'") || strings.Contains(script, "tok") {
+		t.Errorf("HTTPS checkout script = %q", script)
+	}
+	if checkout.Env[0].Name != "GIT_USER" || checkout.Env[1].Value != "tok" || len(checkout.VolumeMounts) != 1 {
+		t.Errorf("HTTPS checkout env %+v, mounts %+v", checkout.Env, checkout.VolumeMounts)
+	}
+}
+
+// TestScheduling verifies that a job's scheduling, inherited from a template
+// of neutron.yaml, is checked against kubernetes.scheduling and set on its
+// pod; the rules themselves are covered in the launcher.
+func TestScheduling(t *testing.T) {
+	pipeline, err := parser.Resolve([]byte(`
+templates:
+  gpu:
+    node_selector: {accelerator: nvidia}
+    tolerations:
+      - {key: gpu, operator: Exists, effect: NoSchedule}
+    affinity:
+      nodeAffinity:
+        preferredDuringSchedulingIgnoredDuringExecution:
+          - weight: 1
+            preference:
+              matchExpressions:
+                - {key: zone, operator: In, values: [a]}
+    runtime_class: nvidia
+jobs:
+  train:
+    extends: gpu
+    image: cuda
+`), nil, "abc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg model.Config
+	cfg.Kubernetes.Namespace = "ci"
+	cfg.Kubernetes.Scheduling = model.SchedulingConfig{
+		NodeLabels:     map[string][]string{"accelerator": {"nvidia"}, "zone": {"*"}},
+		TolerationKeys: []string{"gpu"},
+		RuntimeClasses: []string{"nvidia"},
+	}
+	srv := newTestServer(t, cfg)
+
+	scheduling := jobScheduling(pipeline.Jobs["train"])
+	if err := srv.validateScheduling("train", scheduling); err != nil {
+		t.Fatal(err)
+	}
+	pod := createJob(t, srv.launcherFromSpec(model.JobSpec{Platform: "GitLab", JobName: "train", Image: "cuda", CommitSha: "abc", Trigger: "PUSH", GitRepoUrl: "git@gitlab.example.com:g/app.git", Scheduling: scheduling})).Spec.Template.Spec
+	if pod.NodeSelector["accelerator"] != "nvidia" || len(pod.Tolerations) != 1 || pod.Tolerations[0].Operator != v1.TolerationOpExists {
+		t.Errorf("node selector = %v, tolerations = %+v", pod.NodeSelector, pod.Tolerations)
+	}
+	if pod.Affinity == nil || pod.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].Preference.MatchExpressions[0].Key != "zone" {
+		t.Errorf("affinity = %+v", pod.Affinity)
+	}
+	if pod.RuntimeClassName == nil || *pod.RuntimeClassName != "nvidia" || pod.ServiceAccountName != "" {
+		t.Errorf("runtime class = %v, service account = %q", pod.RuntimeClassName, pod.ServiceAccountName)
+	}
+
+	bad := model.Scheduling{ServiceAccount: "deployer"}
+	if err := srv.validateScheduling("train", &bad); err == nil || !strings.HasPrefix(err.Error(), "job train: ") {
+		t.Errorf("scheduling %+v: error %v, want one naming the job", bad, err)
+	}
+}
+
+// TestPodTemplate verifies that the server merges kubernetes.pod-template into
+// job pods and checks kubernetes.policy before launching; the merge and policy
+// rules themselves are covered in the launcher.
+func TestPodTemplate(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/pod.yaml"
+	if err := os.WriteFile(path, []byte(`
+metadata:
+  labels: {team: ci}
+spec:
+  securityContext: {runAsNonRoot: true}
+  containers:
+    - name: pipeline
+      resources:
+        limits: {cpu: "2", memory: 2Gi}
+    - name: proxy
+      image: registry.example.com/proxy:1
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	podTemplate, err := launcher.LoadPodTemplate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg model.Config
+	cfg.Kubernetes.Namespace = "ci"
+	cfg.Kubernetes.Policy = model.PodPolicy{MaxCpu: "4", AllowedRegistries: []string{"registry.example.com/ci", "docker.io/library"}}
+	srv := newTestServer(t, cfg)
+	srv.podTemplate = podTemplate
+
+	spec := model.JobSpec{Platform: "GitLab", JobName: "build", Image: "alpine:3", CommitSha: "abc", Trigger: "PUSH", GitRepoUrl: "git@gitlab.example.com:g/app.git",
+		Resources: &model.Resources{Limits: model.ResourceSpec{Memory: "1Gi"}}}
+	pod := createJob(t, srv.launcherFromSpec(spec)).Spec.Template
+	if pod.Labels["team"] != "ci" || pod.Spec.SecurityContext == nil || len(pod.Spec.Containers) != 2 || pod.Spec.Containers[1].Name != "proxy" {
+		t.Errorf("pod = %+v, want the template's labels, security context and sidecar", pod)
+	}
+	limits := pod.Spec.Containers[0].Resources.Limits
+	if limits.Cpu().String() != "2" || limits.Memory().String() != "1Gi" {
+		t.Errorf("limits = %v, want the template's cpu and the job's memory", limits)
+	}
+
+	spec.Image, spec.Resources = "quay.io/app:1", nil
+	if err := srv.checkSpec(spec); err == nil || !strings.HasPrefix(err.Error(), "job build: ") {
+		t.Errorf("image %s: error %v, want a policy error naming the job", spec.Image, err)
+	}
+	spec.Image, spec.Resources = "registry.example.com/ci/tool:1", nil
+	if err := srv.checkSpec(spec); err != nil {
+		t.Errorf("allowed job: %v", err)
+	}
+}
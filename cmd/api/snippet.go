@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"neutron/internal"
+	"neutron/internal/model"
+)
+
+// resolveSnippet looks up a snippet reference: `name` or `name@latest` is the
+// current version, `name@<rev>` an immutable revision.
+func resolveSnippet(repo *internal.Repository, ref string) (*internal.SnippetRevision, error) {
+	name, rev, pinned := strings.Cut(ref, "@")
+	if !pinned || rev == "latest" {
+		snippet, err := repo.GetSnippetByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("snippet %s not found", name)
+		}
+		return &internal.SnippetRevision{
+			SnippetName: name,
+			Rev:         snippet.Rev,
+			Content:     snippet.Content,
+			Params:      snippet.Params,
+			Lang:        snippet.Lang,
+		}, nil
+	}
+	n, err := strconv.Atoi(rev)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid snippet revision %q", rev)
+	}
+	revision, err := repo.GetSnippetRevision(name, n)
+	if err != nil {
+		return nil, fmt.Errorf("snippet %s has no revision %d", name, n)
+	}
+	return revision, nil
+}
+
+// diffLines returns a line diff of a and b: unchanged lines are prefixed with
+// two spaces, removed ones with "- " and added ones with "+ ".
+func diffLines(a, b string) string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			out = append(out, "  "+x[i])
+			i++
+			j++
+		case j < len(y) && (i == len(x) || lcs[i][j+1] >= lcs[i+1][j]):
+			out = append(out, "+ "+y[j])
+			j++
+		default:
+			out = append(out, "- "+x[i])
+			i++
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// Output formats of /s/:name. sh and bash render the same script; bash only
+// records that the caller runs it with bash.
+const (
+	formatSh   = "sh"
+	formatBash = "bash"
+	formatPwsh = "pwsh"
+	formatJson = "json"
+	formatEnv  = "env"
+)
+
+// snippetContentTypes maps each format to its Content-Type.
+var snippetContentTypes = map[string]string{
+	formatSh:   "text/x-shellscript; charset=utf-8",
+	formatBash: "text/x-shellscript; charset=utf-8",
+	formatPwsh: "text/x-powershell; charset=utf-8",
+	formatJson: "application/json; charset=utf-8",
+	formatEnv:  "text/plain; charset=utf-8",
+}
+
+// acceptFormats maps Accept media types to formats, for callers that do not
+// pass ?format=.
+var acceptFormats = map[string]string{
+	"application/json":         formatJson,
+	"text/x-shellscript":       formatSh,
+	"application/x-sh":         formatSh,
+	"text/x-powershell":        formatPwsh,
+	"application/x-powershell": formatPwsh,
+}
+
+// snippetFormat picks the output format: ?format= wins, then the first
+// recognised media type of Accept, then the snippet's own language.
+func snippetFormat(query string, accept string, lang string) (string, error) {
+	if query != "" {
+		if _, ok := snippetContentTypes[query]; !ok {
+			return "", fmt.Errorf("unknown format %q (sh, bash, pwsh, json or env)", query)
+		}
+		return query, nil
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		if format, ok := acceptFormats[strings.TrimSpace(mediaType)]; ok {
+			return format, nil
+		}
+	}
+	if lang == "" {
+		return formatSh, nil
+	}
+	return lang, nil
+}
+
+// validSnippetLang reports whether lang is a shell a snippet can be written
+// for; empty means sh.
+func validSnippetLang(lang string) bool {
+	switch lang {
+	case "", formatSh, formatBash, formatPwsh:
+		return true
+	}
+	return false
+}
+
+// langWarning describes a mismatch between the shell a snippet is written for
+// and the one it is rendered for, or returns "" when they are compatible.
+func langWarning(lang string, format string) string {
+	if lang == "" {
+		lang = formatSh
+	}
+	switch format {
+	case formatSh, formatBash, formatPwsh:
+	default:
+		return ""
+	}
+	if lang == format || (lang == formatSh && format == formatBash) {
+		return ""
+	}
+	return fmt.Sprintf("snippet is written for %s but rendered for %s", lang, format)
+}
+
+// renderSnippet renders a snippet as a POSIX shell script, the form pipeline
+// steps run with `sh -c`.
+func renderSnippet(snippet *internal.SnippetRevision, args map[string]string) (string, error) {
+	return renderSnippetAs(snippet, args, formatSh)
+}
+
+// renderSnippetAs validates args against the snippet's parameter schema and
+// renders them with the content in the given format. For shells they become
+// variable assignments (sorted for determinism) ahead of the content; values
+// are single-quoted, so nothing in them is expanded. env renders only the
+// assignments, json the snippet with its resolved parameters.
+func renderSnippetAs(snippet *internal.SnippetRevision, args map[string]string, format string) (string, error) {
+	params, err := model.ParseSnippetParams(snippet.Params)
+	if err != nil {
+		return "", err
+	}
+	values, err := model.ResolveSnippetArgs(params, args)
+	if err != nil {
+		return "", err
+	}
+	if format == formatJson {
+		b, err := json.MarshalIndent(struct {
+			Name    string            `json:"name"`
+			Rev     int               `json:"rev"`
+			Lang    string            `json:"lang,omitempty"`
+			Params  map[string]string `json:"params"`
+			Content string            `json:"content"`
+		}{snippet.SnippetName, snippet.Rev, snippet.Lang, values, snippet.Content}, "", "  ")
+		return string(b), err
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var lines []string
+	if warning := langWarning(snippet.Lang, format); warning != "" {
+		lines = append(lines, "# neutron: warning: "+warning)
+	}
+	for _, key := range keys {
+		switch format {
+		case formatPwsh:
+			lines = append(lines, fmt.Sprintf("$%s = %s", key, pwshQuote(values[key])))
+		case formatEnv:
+			lines = append(lines, fmt.Sprintf("%s=%s", key, shellQuote(values[key])))
+		default:
+			lines = append(lines, fmt.Sprintf("%s=%s;", key, shellQuote(values[key])))
+		}
+	}
+	if format == formatEnv {
+		return strings.Join(lines, "\n") + "\n", nil
+	}
+	if len(lines) > 0 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, snippet.Content)
+	return strings.Join(lines, "\n"), nil
+}
+
+// pwshQuote wraps a string in PowerShell single quotes, where only a doubled
+// quote is special.
+func pwshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// shellQuote wraps a string in single quotes for safe use in shell commands.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// snippetError renders an error of /s/:name in the requested format. Scripts
+// print it and fail, since the body is usually piped straight into a shell and
+// may echo request input.
+func snippetError(err error, format string) string {
+	msg := "neutron: " + err.Error()
+	switch format {
+	case formatJson:
+		b, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return string(b)
+	case formatPwsh:
+		return fmt.Sprintf("Write-Error %s; exit 1\n", pwshQuote(msg))
+	default:
+		return fmt.Sprintf("echo %s >&2; return 1 2>/dev/null || exit 1\n", shellQuote(msg))
+	}
+}
+
+// handleRawSnippet serves a snippet for `curl | bash` / `source <(curl)` and
+// other consumers. :name may pin a revision (name@3) or ask for the current
+// one explicitly (name@latest); query parameters other than format are the
+// snippet's arguments. Every successful fetch is recorded as usage.
+func (s *Server) handleRawSnippet(c *gin.Context) {
+	query := c.Request.URL.Query()
+	requested := query.Get("format")
+	query.Del("format")
+
+	snippet, err := resolveSnippet(s.repo, c.Param("name"))
+	if err != nil {
+		format, ferr := snippetFormat(requested, c.GetHeader("Accept"), "")
+		if ferr != nil {
+			format = formatSh
+		}
+		c.Data(http.StatusNotFound, snippetContentTypes[format], []byte(snippetError(err, format)))
+		return
+	}
+	format, err := snippetFormat(requested, c.GetHeader("Accept"), snippet.Lang)
+	if err != nil {
+		c.Data(http.StatusBadRequest, snippetContentTypes[formatSh], []byte(snippetError(err, formatSh)))
+		return
+	}
+	body, err := renderSnippetAs(snippet, firstQueryValues(query), format)
+	if err != nil {
+		c.Data(http.StatusBadRequest, snippetContentTypes[format], []byte(snippetError(err, format)))
+		return
+	}
+	if warning := langWarning(snippet.Lang, format); warning != "" {
+		c.Header("Warning", fmt.Sprintf("299 neutron %q", warning))
+	}
+	c.Data(http.StatusOK, snippetContentTypes[format], []byte(body))
+
+	go s.recordSnippetFetch(snippet, c.ClientIP(), c.Request.UserAgent(), c.GetHeader("X-Neutron-Job"))
+}
+
+// recordSnippetFetch records a fetch of /s/:name. The calling job is taken
+// from the X-Neutron-Job header, or else found by looking up the pod with the
+// caller's IP in every namespace, since jobs run in their project's namespace
+// (K8s labels the pods of a Job with job-name).
+func (s *Server) recordSnippetFetch(snippet *internal.SnippetRevision, callerIp, userAgent, jobName string) {
+	if jobName == "" && s.clientSet != nil && callerIp != "" {
+		pods, err := s.clientSet.CoreV1().Pods(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{
+			FieldSelector: "status.podIP=" + callerIp,
+			LabelSelector: "job-name",
+		})
+		if err == nil && len(pods.Items) > 0 {
+			jobName = pods.Items[0].Labels["job-name"]
+		}
+	}
+	var projectId string
+	if jobName != "" {
+		if job, err := s.repo.GetJobByName(jobName); err == nil {
+			projectId = job.ProjectId
+		} else {
+			jobName = "" // not one of ours; the header is caller-supplied
+		}
+	}
+	s.addSnippetUsage(internal.SnippetUsage{
+		SnippetName: snippet.SnippetName,
+		Rev:         snippet.Rev,
+		Source:      internal.SnippetUsageFetch,
+		CallerIp:    callerIp,
+		UserAgent:   truncate(userAgent, 255),
+		JobName:     jobName,
+		ProjectId:   projectId,
+	})
+}
+
+// recordSnippetSteps records the `uses:` steps of a created job as usage of
+// their snippets.
+func (s *Server) recordSnippetSteps(steps []model.Step, jobName string, projectId string) {
+	for _, step := range steps {
+		if step.Uses == "" {
+			continue
+		}
+		name, rev, _ := strings.Cut(step.Uses, "@")
+		n, _ := strconv.Atoi(rev)
+		s.addSnippetUsage(internal.SnippetUsage{
+			SnippetName: name,
+			Rev:         n,
+			Source:      internal.SnippetUsageUses,
+			JobName:     jobName,
+			ProjectId:   projectId,
+		})
+	}
+}
+
+func (s *Server) addSnippetUsage(u internal.SnippetUsage) {
+	now := time.Now()
+	u.CreatedAt = &now
+	if err := s.repo.AddSnippetUsage(u); err != nil {
+		log.Printf("failed to record usage of snippet %s: %v", u.SnippetName, err)
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// defaultUsageDays is the window of snippet usage stats and of the delete
+// guard when ?days= is not given.
+const defaultUsageDays = 30
+
+// usageDays reads ?days=, writing a 400 response and returning false when it
+// is not a positive integer.
+func usageDays(c *gin.Context) (int, bool) {
+	v := c.Query("days")
+	if v == "" {
+		return defaultUsageDays, true
+	}
+	days, err := strconv.Atoi(v)
+	if err != nil || days <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be a positive integer"})
+		return 0, false
+	}
+	return days, true
+}
+
+// normalizeSnippetParams parses and validates the params of a create/update
+// request, given either as a JSON array of parameter objects or as a string,
+// and returns what is stored in the params column: the schema as JSON, or a
+// string that is not JSON kept verbatim as legacy free text without a schema.
+func normalizeSnippetParams(raw interface{}) (string, error) {
+	var params []model.SnippetParam
+	switch v := raw.(type) {
+	case nil:
+	case string:
+		if v = strings.TrimSpace(v); !strings.HasPrefix(v, "[") {
+			return v, nil
+		}
+		p, err := model.ParseSnippetParams(v)
+		if err != nil {
+			return "", err
+		}
+		params = p
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		if err := json.Unmarshal(b, &params); err != nil {
+			return "", fmt.Errorf("invalid params: %w", err)
+		}
+	}
+	if len(params) == 0 {
+		return "", nil
+	}
+	if err := model.ValidateSnippetParams(params); err != nil {
+		return "", err
+	}
+	for _, p := range params {
+		if p.Name == "format" {
+			return "", fmt.Errorf("param name format is reserved for /s/:name?format=")
+		}
+	}
+	b, err := json.Marshal(params)
+	return string(b), err
+}
+
+// expandSnippetSteps replaces every `uses:` step by the rendered snippet, so
+// the JobSpec pins the script as it was when the job was triggered and a rerun
+// executes the same one even if the snippet is edited later.
+func (s *Server) expandSnippetSteps(steps []model.Step) ([]model.Step, error) {
+	expanded := make([]model.Step, 0, len(steps))
+	for _, step := range steps {
+		if step.Uses == "" {
+			expanded = append(expanded, step)
+			continue
+		}
+		if step.Command != "" {
+			return nil, fmt.Errorf("step %s: uses and cmd are mutually exclusive", step.StepName)
+		}
+		for k := range step.With {
+			if !safeParamKey.MatchString(k) {
+				return nil, fmt.Errorf("step %s: invalid parameter name %q", step.StepName, k)
+			}
+		}
+		snippet, err := resolveSnippet(s.repo, step.Uses)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: %w", step.StepName, err)
+		}
+		if snippet.Lang == formatPwsh {
+			return nil, fmt.Errorf("step %s: snippet %s is written for pwsh, steps run with sh", step.StepName, step.Uses)
+		}
+		if step.StepName == "" {
+			step.StepName = snippet.SnippetName
+		}
+		if step.Command, err = renderSnippet(snippet, step.With); err != nil {
+			return nil, fmt.Errorf("step %s: snippet %s: %w", step.StepName, step.Uses, err)
+		}
+		// Record which revision ran, also for `uses: name` and `name@latest`.
+		step.Uses = fmt.Sprintf("%s@%d", snippet.SnippetName, snippet.Rev)
+		expanded = append(expanded, step)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+
+	"neutron/internal"
+	"neutron/internal/model"
+	"neutron/internal/parser"
+)
+
+// The snippet library can be exported to, imported from, and mirrored from a
+// directory of files, one per snippet: YAML front matter between "---" lines
+// followed by the content, e.g. snippets/docker-login.sh.
+
+// maxSnippetArchiveSize caps an uploaded snippet archive.
+const maxSnippetArchiveSize = 10 << 20
+
+// defaultSnippetSyncDir and defaultSnippetSyncBranch apply when the snippets
+// config leaves them empty.
+const (
+	defaultSnippetSyncDir    = "snippets"
+	defaultSnippetSyncBranch = "main"
+)
+
+// snippetFrontMatter is the YAML header of a snippet file. Name defaults to
+// the file name without extension, Title to the name.
+type snippetFrontMatter struct {
+	Name        string            `yaml:"name,omitempty"`
+	Title       string            `yaml:"title,omitempty"`
+	Description string            `yaml:"description,omitempty"`
+	Lang        string            `yaml:"lang,omitempty"`
+	Params      snippetFileParams `yaml:"params,omitempty"`
+}
+
+// snippetFileParams is the params of a snippet file: a list of parameters, or
+// a string holding the legacy free text of a snippet without a schema, kept as
+// is so that exporting and importing it again leaves the snippet unchanged.
+type snippetFileParams struct {
+	schema []model.SnippetParam
+	text   string
+}
+
+func (p snippetFileParams) IsZero() bool {
+	return len(p.schema) == 0 && p.text == ""
+}
+
+func (p snippetFileParams) MarshalYAML() (interface{}, error) {
+	if p.text != "" {
+		return p.text, nil
+	}
+	return p.schema, nil
+}
+
+func (p *snippetFileParams) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&p.text)
+	}
+	return value.Decode(&p.schema)
+}
+
+// snippetSyncResult lists what an import or sync did, by snippet name.
+type snippetSyncResult struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+	Deleted   []string `json:"deleted"`
+	Kept      []string `json:"kept"` // missing from the source but used recently, so not pruned
+}
+
+// isSnippetFile reports whether a file of an archive or synced directory holds
+// a snippet; other files (README.md, ...) are skipped.
+func isSnippetFile(name string) bool {
+	switch path.Ext(name) {
+	case ".sh", ".bash", ".ps1":
+		return !strings.HasPrefix(path.Base(name), ".")
+	}
+	return false
+}
+
+// snippetFileName is the file a snippet is exported to.
+func snippetFileName(snippet internal.Snippet) string {
+	switch snippet.Lang {
+	case formatPwsh:
+		return snippet.Name + ".ps1"
+	case formatBash:
+		return snippet.Name + ".bash"
+	}
+	return snippet.Name + ".sh"
+}
+
+// marshalSnippetFile renders a snippet as front matter plus content.
+func marshalSnippetFile(snippet internal.Snippet) ([]byte, error) {
+	var params snippetFileParams
+	if text := strings.TrimSpace(snippet.Params); strings.HasPrefix(text, "[") {
+		schema, err := model.ParseSnippetParams(text)
+		if err != nil {
+			return nil, err
+		}
+		params.schema = schema
+	} else {
+		params.text = text
+	}
+	header, err := yaml.Marshal(snippetFrontMatter{
+		Name:        snippet.Name,
+		Title:       snippet.Title,
+		Description: snippet.Description,
+		Lang:        snippet.Lang,
+		Params:      params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	buf.Write(header)
+	buf.WriteString("---\n")
+	buf.WriteString(snippet.Content)
+	return buf.Bytes(), nil
+}
+
+// parseSnippetFile parses and validates a snippet file.
+func parseSnippetFile(filePath string, data []byte) (internal.Snippet, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	rest, ok := strings.CutPrefix(text, "---\n")
+	if !ok {
+		return internal.Snippet{}, fmt.Errorf("%s: missing front matter", filePath)
+	}
+	header, content, ok := strings.Cut(rest, "\n---\n")
+	if after, empty := strings.CutPrefix(rest, "---\n"); empty {
+		header, content, ok = "", after, true
+	}
+	if !ok {
+		return internal.Snippet{}, fmt.Errorf("%s: unterminated front matter", filePath)
+	}
+	var fm snippetFrontMatter
+	if err := yaml.Unmarshal([]byte(header), &fm); err != nil {
+		return internal.Snippet{}, fmt.Errorf("%s: %w", filePath, err)
+	}
+	if fm.Name == "" {
+		fm.Name = strings.TrimSuffix(path.Base(filePath), path.Ext(filePath))
+	}
+	if fm.Title == "" {
+		fm.Title = fm.Name
+	}
+	if !snippetNameRegex.MatchString(fm.Name) {
+		return internal.Snippet{}, fmt.Errorf("%s: name must be a valid slug (lowercase letters, digits, hyphens)", filePath)
+	}
+	if !validSnippetLang(fm.Lang) {
+		return internal.Snippet{}, fmt.Errorf("%s: lang must be sh, bash or pwsh", filePath)
+	}
+	if strings.TrimSpace(content) == "" {
+		return internal.Snippet{}, fmt.Errorf("%s: empty content", filePath)
+	}
+	var raw interface{}
+	if fm.Params.text != "" {
+		raw = fm.Params.text
+	} else if len(fm.Params.schema) > 0 {
+		raw = fm.Params.schema
+	}
+	params, err := normalizeSnippetParams(raw)
+	if err != nil {
+		return internal.Snippet{}, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return internal.Snippet{
+		Name:        fm.Name,
+		Title:       fm.Title,
+		Description: fm.Description,
+		Content:     content,
+		Params:      params,
+		Lang:        fm.Lang,
+	}, nil
+}
+
+// applySnippets creates or updates the given snippets (recording revisions by
+// author) and, with prune, deletes every other snippet. Pruning keeps snippets
+// used within defaultUsageDays unless force is set. Changes are applied in one
+// transaction, so nothing is applied when any of them fails.
+func (s *Server) applySnippets(snippets []internal.Snippet, author string, prune bool, force bool) (*snippetSyncResult, error) {
+	result := &snippetSyncResult{}
+	seen := make(map[string]bool, len(snippets))
+	for _, snippet := range snippets {
+		if seen[snippet.Name] {
+			return nil, fmt.Errorf("snippet %s defined twice", snippet.Name)
+		}
+		seen[snippet.Name] = true
+	}
+	err := s.repo.Transaction(func(repo *internal.Repository) error {
+		for _, snippet := range snippets {
+			existing, err := repo.GetSnippetByName(snippet.Name)
+			if err != nil {
+				if err := repo.CreateSnippet(snippet, author); err != nil {
+					return fmt.Errorf("creating %s: %w", snippet.Name, err)
+				}
+				result.Created = append(result.Created, snippet.Name)
+				continue
+			}
+			if existing.Title == snippet.Title && existing.Description == snippet.Description &&
+				existing.Content == snippet.Content && existing.Params == snippet.Params && existing.Lang == snippet.Lang {
+				result.Unchanged = append(result.Unchanged, snippet.Name)
+				continue
+			}
+			updates := map[string]interface{}{
+				"title":       snippet.Title,
+				"description": snippet.Description,
+				"content":     snippet.Content,
+				"params":      snippet.Params,
+				"lang":        snippet.Lang,
+			}
+			if err := repo.UpdateSnippet(snippet.Name, updates, author); err != nil {
+				return fmt.Errorf("updating %s: %w", snippet.Name, err)
+			}
+			result.Updated = append(result.Updated, snippet.Name)
+		}
+		if !prune {
+			return nil
+		}
+		all, err := repo.ListSnippets()
+		if err != nil {
+			return err
+		}
+		since := time.Now().AddDate(0, 0, -defaultUsageDays)
+		for _, snippet := range all {
+			if seen[snippet.Name] {
+				continue
+			}
+			if !force {
+				used, err := repo.CountSnippetUsage(snippet.Name, since)
+				if err != nil {
+					return err
+				}
+				if used > 0 {
+					result.Kept = append(result.Kept, snippet.Name)
+					continue
+				}
+			}
+			if err := repo.DeleteSnippet(snippet.Name); err != nil {
+				return fmt.Errorf("deleting %s: %w", snippet.Name, err)
+			}
+			result.Deleted = append(result.Deleted, snippet.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// snippetsReadOnly writes a 409 response and returns true when the snippet
+// library is a mirror of a Git project and must not be edited through the API.
+func (s *Server) snippetsReadOnly(c *gin.Context) bool {
+	if s.config.Snippets.SyncProject == "" {
+		return false
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "snippets are synced from Git; change them in the source project"})
+	return true
+}
+
+// isSnippetSyncPush reports whether a webhook is a push to the branch the
+// snippet library is synced from.
+func (s *Server) isSnippetSyncPush(projectId string, ph parsedHook) bool {
+	cfg := s.config.Snippets
+	if cfg.SyncProject == "" || cfg.SyncProject != projectId || ph.trigger != "PUSH" {
+		return false
+	}
+	branch := cfg.SyncBranch
+	if branch == "" {
+		branch = defaultSnippetSyncBranch
+	}
+	return ph.codeRef == branch
+}
+
+// syncSnippets mirrors the snippet directory of the sync project at ref into
+// the database: snippets are created or updated (as revisions by author) and
+// snippets without a file are deleted unless used recently. Nothing is applied
+// if any file is invalid.
+func (s *Server) syncSnippets(base *parser.Base, ref string, author string) (*snippetSyncResult, error) {
+	dir := s.config.Snippets.SyncDir
+	if dir == "" {
+		dir = defaultSnippetSyncDir
+	}
+	files, err := base.ListFiles(dir, ref)
+	if err != nil {
+		return nil, err
+	}
+	var snippets []internal.Snippet
+	for _, file := range files {
+		if !isSnippetFile(file) {
+			continue
+		}
+		data, err := base.FetchFile(file, ref)
+		if err != nil {
+			return nil, err
+		}
+		snippet, err := parseSnippetFile(file, data)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, snippet)
+	}
+	return s.applySnippets(snippets, author, true, false)
+}
+
+// handleExportSnippets returns the whole snippet library as a .tar.gz of
+// snippet files under snippets/.
+func (s *Server) handleExportSnippets(c *gin.Context) {
+	snippets, err := s.repo.ListSnippets()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, snippet := range snippets {
+		data, err := marshalSnippetFile(snippet)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("%s: %v", snippet.Name, err)})
+			return
+		}
+		modTime := now
+		if snippet.UpdatedAt != nil {
+			modTime = *snippet.UpdatedAt
+		}
+		hdr := &tar.Header{
+			Name:    path.Join(defaultSnippetSyncDir, snippetFileName(snippet)),
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: modTime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := tw.Write(data); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tw.Close(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := gz.Close(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="snippets.tar.gz"`)
+	c.Data(http.StatusOK, "application/gzip", buf.Bytes())
+}
+
+// handleImportSnippets creates or updates snippets from a .tar.gz of snippet
+// files (as produced by export). ?author= is recorded on the revisions, and
+// ?prune=true deletes snippets missing from the archive, those used recently
+// only with ?force=true. Nothing is applied if any file is invalid.
+func (s *Server) handleImportSnippets(c *gin.Context) {
+	if s.snippetsReadOnly(c) {
+		return
+	}
+	snippets, err := readSnippetArchive(http.MaxBytesReader(c.Writer, c.Request.Body, maxSnippetArchiveSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := s.applySnippets(snippets, c.Query("author"), c.Query("prune") == "true", c.Query("force") == "true")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "result": result})
+}
+
+// readSnippetArchive parses every snippet file of a .tar.gz.
+func readSnippetArchive(r io.Reader) ([]internal.Snippet, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	var snippets []internal.Snippet
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !isSnippetFile(hdr.Name) {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+		snippet, err := parseSnippetFile(hdr.Name, data)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, snippet)
+	}
+	return snippets, nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"neutron/internal"
+	"neutron/internal/model"
+)
+
+// TestRenderSnippetQuoting verifies that parameter values reach the snippet
+// verbatim: command substitutions, backticks and quotes in a query value must
+// not be executed by the shell running the rendered script.
+func TestRenderSnippetQuoting(t *testing.T) {
+	snippet := &internal.SnippetRevision{SnippetName: "echo", Content: `printf '%s' "$MSG"`}
+	for _, value := range []string{`$(echo pwned)`, "`echo pwned`", `it's "quoted"`, `a'; echo pwned; '`} {
+		script, err := renderSnippet(snippet, map[string]string{"MSG": value})
+		if err != nil {
+			t.Fatalf("renderSnippet(%q) error = %v", value, err)
+		}
+		out, err := exec.Command("sh", "-c", script).Output()
+		if err != nil {
+			t.Fatalf("sh -c %q: %v", script, err)
+		}
+		if string(out) != value {
+			t.Errorf("MSG=%q rendered as %q", value, out)
+		}
+	}
+}
+
+func TestRenderSnippetParams(t *testing.T) {
+	snippet := &internal.SnippetRevision{
+		SnippetName: "deploy",
+		Content:     "deploy",
+		Params: `[{"name":"ENV","required":true,"enum":["staging","prod"]},` +
+			`{"name":"REPLICAS","type":"int","default":"2"},` +
+			`{"name":"TAG","regex":"v[0-9.]+"}]`,
+	}
+	tests := []struct {
+		name    string
+		args    map[string]string
+		want    string
+		wantErr string
+	}{
+		{"defaults", map[string]string{"ENV": "prod"}, "ENV='prod';\nREPLICAS='2';\n\ndeploy", ""},
+		{"all set", map[string]string{"ENV": "staging", "REPLICAS": "3", "TAG": "v1.2"}, "ENV='staging';\nREPLICAS='3';\nTAG='v1.2';\n\ndeploy", ""},
+		{"missing required", map[string]string{}, "", "missing required param ENV"},
+		{"not in enum", map[string]string{"ENV": "dev"}, "", "must be one of"},
+		{"bad int", map[string]string{"ENV": "prod", "REPLICAS": "two"}, "", "must be an integer"},
+		{"regex is anchored", map[string]string{"ENV": "prod", "TAG": "v1; rm -rf /"}, "", "must match"},
+		{"unknown param", map[string]string{"ENV": "prod", "X": "1"}, "", "unknown param X"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderSnippet(snippet, tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("renderSnippet() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderSnippet() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderSnippet() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRenderLegacySnippetParams verifies that a snippet stored with the old
+// free-text params declares no schema and accepts any shell identifier.
+func TestRenderLegacySnippetParams(t *testing.T) {
+	snippet := &internal.SnippetRevision{SnippetName: "deploy", Content: "deploy", Params: "ENV (prod|dev)"}
+	got, err := renderSnippet(snippet, map[string]string{"ENV": "prod", "DEBUG": "1"})
+	if err != nil {
+		t.Fatalf("renderSnippet() error = %v", err)
+	}
+	if want := "DEBUG='1';\nENV='prod';\n\ndeploy"; got != want {
+		t.Errorf("renderSnippet() = %q, want %q", got, want)
+	}
+}
+
+func TestNormalizeSnippetParams(t *testing.T) {
+	got, err := normalizeSnippetParams(" ENV (prod|dev) ")
+	if err != nil || got != "ENV (prod|dev)" {
+		t.Errorf("legacy free text normalized to %q, %v", got, err)
+	}
+	for _, raw := range []interface{}{
+		`[{"name":"BAD-NAME"}]`,
+		`[{"name":"A","type":"float"}]`,
+		`[{"name":"A","regex":"("}]`,
+		`[{"name":"A","type":"int","default":"x"}]`,
+		[]interface{}{map[string]interface{}{"name": "A"}, map[string]interface{}{"name": "A"}},
+	} {
+		if _, err := normalizeSnippetParams(raw); err == nil {
+			t.Errorf("normalizeSnippetParams(%v) accepted an invalid schema", raw)
+		}
+	}
+}
+
+func TestRenderSnippetFormats(t *testing.T) {
+	snippet := &internal.SnippetRevision{SnippetName: "greet", Rev: 2, Content: "echo hi", Params: `[{"name":"WHO"}]`}
+	args := map[string]string{"WHO": "o'brien"}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{formatSh, "WHO='o'\\''brien';\n\necho hi"},
+		{formatBash, "WHO='o'\\''brien';\n\necho hi"},
+		{formatPwsh, "# neutron: warning: snippet is written for sh but rendered for pwsh\n$WHO = 'o''brien'\n\necho hi"},
+		{formatEnv, "WHO='o'\\''brien'\n"},
+		{formatJson, "{\n  \"name\": \"greet\",\n  \"rev\": 2,\n  \"params\": {\n    \"WHO\": \"o'brien\"\n  },\n  \"content\": \"echo hi\"\n}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := renderSnippetAs(snippet, args, tt.format)
+			if err != nil {
+				t.Fatalf("renderSnippetAs() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderSnippetAs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippetFormat(t *testing.T) {
+	tests := []struct {
+		query, accept, lang, want string
+	}{
+		{"", "", "", formatSh},
+		{"", "", formatPwsh, formatPwsh},
+		{"", "text/html, application/json;q=0.9", "", formatJson},
+		{"", "application/x-powershell", formatSh, formatPwsh},
+		{"env", "application/json", formatPwsh, formatEnv},
+	}
+	for _, tt := range tests {
+		got, err := snippetFormat(tt.query, tt.accept, tt.lang)
+		if err != nil || got != tt.want {
+			t.Errorf("snippetFormat(%q, %q, %q) = %q, %v, want %q", tt.query, tt.accept, tt.lang, got, err, tt.want)
+		}
+	}
+	if _, err := snippetFormat("zsh", "", ""); err == nil {
+		t.Errorf("snippetFormat accepted an unknown format")
+	}
+}
+
+func TestSnippetFileRoundTrip(t *testing.T) {
+	snippet := internal.Snippet{
+		Name:        "deploy",
+		Title:       "Deploy",
+		Description: "Roll out a release",
+		Lang:        formatBash,
+		Params:      `[{"name":"ENV","required":true,"enum":["staging","prod"]}]`,
+		Content:     "set -e\n---\nkubectl apply -n \"$ENV\"\n",
+	}
+	data, err := marshalSnippetFile(snippet)
+	if err != nil {
+		t.Fatalf("marshalSnippetFile() error = %v", err)
+	}
+	got, err := parseSnippetFile("snippets/"+snippetFileName(snippet), data)
+	if err != nil {
+		t.Fatalf("parseSnippetFile() error = %v", err)
+	}
+	if got != snippet {
+		t.Errorf("round trip = %+v, want %+v", got, snippet)
+	}
+
+	got, err = parseSnippetFile("snippets/hello.sh", []byte("---\ntitle: Hello\n---\necho hi\n"))
+	if err != nil || got.Name != "hello" || got.Content != "echo hi\n" {
+		t.Errorf("name from file = %+v, %v", got, err)
+	}
+	got, err = parseSnippetFile("snippets/hello.sh", []byte("---\n---\necho hi\n"))
+	if err != nil || got.Name != "hello" || got.Title != "hello" || got.Content != "echo hi\n" {
+		t.Errorf("empty front matter = %+v, %v", got, err)
+	}
+
+	legacy := internal.Snippet{Name: "release", Title: "Release", Lang: formatSh, Params: "ENV (prod|dev)", Content: "release \"$ENV\"\n"}
+	if data, err = marshalSnippetFile(legacy); err != nil {
+		t.Fatalf("marshalSnippetFile(legacy) error = %v", err)
+	}
+	if got, err = parseSnippetFile("snippets/release.sh", data); err != nil || got != legacy {
+		t.Errorf("legacy round trip = %+v, %v, want %+v", got, err, legacy)
+	}
+	for _, data := range []string{"echo hi\n", "---\ntitle: x\necho hi\n", "---\nname: Bad_Name\n---\necho hi\n", "---\nlang: zsh\n---\necho hi\n"} {
+		if _, err := parseSnippetFile("snippets/x.sh", []byte(data)); err == nil {
+			t.Errorf("parseSnippetFile(%q) accepted an invalid file", data)
+		}
+	}
+}
+
+func TestLegacySnippetGetsRevision(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+	// snippets created before revisions existed have rev 0 and no revision row
+	if err := s.repo.DB().Create(&internal.Snippet{Name: "notify", Content: "echo hi"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := internal.OpenRepository(s.repo.DB()); err != nil {
+		t.Fatal(err)
+	}
+	snippet, err := s.repo.GetSnippetByName("notify")
+	if err != nil || snippet.Rev != 1 {
+		t.Fatalf("legacy snippet rev = %v (err %v), want 1", snippet, err)
+	}
+	if rev, err := s.repo.GetSnippetRevision("notify", 1); err != nil || rev.Content != "echo hi" {
+		t.Fatalf("revision 1 = %v (err %v), want the legacy content", rev, err)
+	}
+	if err := s.repo.UpdateSnippet("notify", map[string]interface{}{"content": "echo bye"}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if snippet, _ := s.repo.GetSnippetByName("notify"); snippet.Rev != 2 {
+		t.Errorf("rev after an edit = %d, want 2", snippet.Rev)
+	}
+}
+
+func TestApplySnippetsPruneKeepsUsedSnippets(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+	for _, name := range []string{"used", "unused"} {
+		if err := s.repo.CreateSnippet(internal.Snippet{Name: name, Content: "echo " + name}, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	if err := s.repo.AddSnippetUsage(internal.SnippetUsage{SnippetName: "used", Rev: 1, Source: internal.SnippetUsageUses, CreatedAt: &now}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.applySnippets(nil, "bob", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Deleted, []string{"unused"}) || !slices.Equal(result.Kept, []string{"used"}) {
+		t.Errorf("prune deleted %v and kept %v, want only the used snippet kept", result.Deleted, result.Kept)
+	}
+	if _, err := s.repo.GetSnippetByName("used"); err != nil {
+		t.Error("used snippet was pruned")
+	}
+
+	result, err = s.applySnippets(nil, "bob", true, true)
+	if err != nil || !slices.Equal(result.Deleted, []string{"used"}) {
+		t.Errorf("forced prune deleted %v (err %v), want the used snippet", result, err)
+	}
+}
+
+func TestApplySnippetsIsAtomic(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+	if err := s.repo.CreateSnippet(internal.Snippet{Name: "old", Content: "echo old"}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	// pruning fails after the new snippet was created
+	if err := s.repo.DB().Migrator().DropTable(&internal.SnippetUsage{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.applySnippets([]internal.Snippet{{Name: "new", Content: "echo new"}}, "bob", true, false); err == nil {
+		t.Fatal("prune without a usage table succeeded")
+	}
+	if _, err := s.repo.GetSnippetByName("new"); err == nil {
+		t.Error("snippet of a failed import was created")
+	}
+	if _, err := s.repo.GetSnippetByName("old"); err != nil {
+		t.Error("snippet deleted by a failed import")
+	}
+}
+
+// TestRecordSnippetFetchFindsPod verifies that a fetch without X-Neutron-Job
+// is attributed to the job whose pod has the caller's IP, in the namespace of
+// its project.
+func TestRecordSnippetFetchFindsPod(t *testing.T) {
+	s := newTestServer(t, model.Config{})
+	addJob(t, s, "neutron-build-20000101-000000", queueSpec("build", ""), "")
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "neutron-build-20000101-000000-x1", Namespace: "team-a", Labels: map[string]string{"job-name": "neutron-build-20000101-000000"}},
+		Status:     v1.PodStatus{PodIP: "10.0.0.7"},
+	}
+	if _, err := s.clientSet.CoreV1().Pods("team-a").Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.recordSnippetFetch(&internal.SnippetRevision{SnippetName: "greet", Rev: 1}, "10.0.0.7", "curl/8", "")
+	usage, err := s.repo.ListSnippetUsage("greet", 10)
+	if err != nil || len(usage) != 1 {
+		t.Fatalf("usage = %+v, %v", usage, err)
+	}
+	if usage[0].JobName != "neutron-build-20000101-000000" || usage[0].ProjectId != "p1" {
+		t.Errorf("usage = %+v, want it attributed to the job's project", usage[0])
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"neutron/internal"
+	"neutron/internal/model"
+	"neutron/internal/secret"
+)
+
+// projectCodebase resolves the codebase instance of a registered project, with
+// the project's own token and webhook secret in place of the instance's when
+// it has them.
+func (s *Server) projectCodebase(p internal.PipelineProject) (string, model.CodeBase, error) {
+	id, cb, err := s.codebase(p.CodebaseId, p.WebhookType)
+	if err != nil {
+		return "", model.CodeBase{}, err
+	}
+	if p.Token != "" {
+		if cb.Token, err = secret.Decrypt(s.config.Salt, p.Token); err != nil {
+			return "", model.CodeBase{}, fmt.Errorf("token of project %s: %w", p.Id, err)
+		}
+	}
+	if p.WebhookSecret != "" {
+		if cb.WebhookSecret, err = secret.Decrypt(s.config.Salt, p.WebhookSecret); err != nil {
+			return "", model.CodeBase{}, fmt.Errorf("webhook secret of project %s: %w", p.Id, err)
+		}
+	}
+	return id, cb, nil
+}
+
+// applyProjectToken gives a job of a registered project the project's token
+// and token file setting. Without a project token the instance's is kept; a
+// token that no longer decrypts is dropped rather than replaced by the
+// instance's, so the job's reports fail instead of using broader credentials.
+func (s *Server) applyProjectToken(projectId string, runnerConfig *model.RunnerConfig) {
+	if projectId == "" || s.repo == nil {
+		return
+	}
+	p := s.repo.GetWebhookConfig(projectId)
+	runnerConfig.TokenFile = p.TokenFile
+	if p.Token == "" {
+		return
+	}
+	token, err := secret.Decrypt(s.config.Salt, p.Token)
+	if err != nil {
+		log.Printf("failed to decrypt token of project %s: %v", projectId, err)
+	}
+	runnerConfig.CodebaseToken = token
+}
+
+// encryptToken encrypts a project token for storage, explaining a missing salt.
+func (s *Server) encryptToken(token string) (string, error) {
+	enc, err := secret.Encrypt(s.config.Salt, token)
+	if errors.Is(err, secret.ErrNoKey) {
+		return "", errors.New("salt must be configured to store project tokens")
+	}
+	return enc, err
+}
+
+// handleSetProjectToken sets, rotates or (with an empty token) clears a
+// project's codebase token, and whether its jobs keep it out of the step env.
+func (s *Server) handleSetProjectToken(c *gin.Context) {
+	var req struct {
+		Token     string `json:"token"`
+		TokenFile bool   `json:"token_file"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	project := s.repo.GetWebhookConfig(c.Param("id"))
+	if project.Id == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	token, err := s.encryptToken(req.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.repo.SetProjectToken(project.Id, token, req.TokenFile); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": project.Id, "hasToken": token != "", "tokenFile": req.TokenFile})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"neutron/internal/model"
+)
+
+// TestLauncherTokenFile verifies that a project keeping its token out of the
+// step env gets it only in the init container, which writes the token file the
+// runner is pointed at.
+func TestLauncherTokenFile(t *testing.T) {
+	var cfg model.Config
+	cfg.BaseConfig = map[string]model.CodeBase{"GitLab": {Url: "https://gitlab.example.com", Token: "admin-tok"}}
+	srv := newTestServer(t, cfg)
+
+	l := srv.launcherFromSpec(model.JobSpec{Platform: "GitLab", JobName: "build", Image: "alpine:3", CommitSha: "abc", Trigger: "PUSH"})
+	l.RunnerConfig.CodebaseToken = "project-tok"
+	l.RunnerConfig.TokenFile = true
+	pod := createJob(t, l).Spec.Template.Spec
+
+	for _, e := range pod.Containers[0].Env {
+		if e.Name == "CODEBASE_TOKEN" || e.Value == "project-tok" {
+			t.Errorf("pipeline container env carries the token: %s", e.Name)
+		}
+	}
+	init := pod.InitContainers[1]
+	if got := init.Env[0]; got.Name != "CODEBASE_TOKEN" || got.Value != "project-tok" {
+		t.Errorf("init env[0] = %s=%q, want the project token", got.Name, got.Value)
+	}
+	if !strings.Contains(strings.Join(init.Command, " "), "/pipeline/.codebase-token") {
+		t.Errorf("init command %q does not write the token file", init.Command)
+	}
+}
@@ -0,0 +1,76 @@
+// Command neutron-runner runs a pipeline job inside its K8s pod. The init
+// container copies it to /pipeline/runner, where steps can call it too:
+//
+//	/pipeline/runner run                          run the job's steps (default)
+//	/pipeline/runner report [flags] [name status] report an extra status or a test report link
+//	/pipeline/runner upload-artifact path...      upload files or directories to the job's artifacts
+//
+// Statuses go to the reporters named in RUNNER_REPORTERS (see
+// service.ReporterNames).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"neutron/internal/model"
+	"neutron/internal/service"
+	"os"
+)
+
+func main() {
+	command := "run"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "run":
+		service.RunFromEnv()
+	case "report":
+		report(args)
+	case "upload-artifact":
+		if len(args) == 0 {
+			log.Fatal("usage: upload-artifact path...")
+		}
+		if err := service.UploadArtifacts(args); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q (run, report or upload-artifact)\n", command)
+		os.Exit(2)
+	}
+}
+
+func report(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	description := fs.String("description", "", "status description")
+	link := fs.String("link", "", "set the job's test report link")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: report [-description text] [-link url] [name pending|running|success|failed]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	var name string
+	var status model.StepResult
+	switch fs.NArg() {
+	case 0:
+		if *link == "" {
+			fs.Usage()
+			os.Exit(2)
+		}
+	case 2:
+		name = fs.Arg(0)
+		var err error
+		if status, err = service.ParseStepResult(fs.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := service.ReportFromEnv(name, status, *description, *link); err != nil {
+		log.Fatal(err)
+	}
+}
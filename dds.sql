@@ -1,7 +1,21 @@
 create table if not exists neutron_project(
     id char(36) primary key,
     webhook_type varchar(20),
-    repo_url varchar(200)
+    repo_url varchar(200),
+    codebase_id varchar(64),
+    name varchar(255),
+    description text,
+    owners text,
+    notify text,
+    paused tinyint(1) default 0,
+    namespace varchar(63),
+    resources text,
+    token text,
+    token_file tinyint(1) default 0,
+    hook_id varchar(64),
+    webhook_secret text,
+    clone_protocol varchar(8),
+    known_hosts varchar(253)
 );
 create table if not exists neutron_job(
     id bigint primary key auto_increment,
@@ -14,6 +14,8 @@ type WebhookRequest struct {
 	WebhookType string     `json:"object_kind"`
 	CodeSha     string     `json:"checkout_sha"`
 	Ref         string     `json:"ref"`
+	UserName    string     `json:"user_name"` // push / tag_push author
+	User        User       `json:"user"`      // merge_request author
 	Project     Project    `json:"project"`
 	ProjectId   int        `json:"project_id"`
 	Repository  Repository `json:"repository"`
@@ -24,6 +26,11 @@ type Project struct {
 	Id int `json:"id"`
 }
 
+type User struct {
+	Name     string `json:"name"`
+	Username string `json:"username"`
+}
+
 type Repository struct {
 	GitHttpUrl string `json:"git_http_url"`
 	GitSshUrl  string `json:"git_ssh_url"`
@@ -46,6 +53,17 @@ type Parser struct {
 	Request WebhookRequest
 }
 
+// Username returns the account that caused the webhook event.
+func (p *Parser) Username() string {
+	if p.Request.UserName != "" {
+		return p.Request.UserName
+	}
+	if p.Request.User.Username != "" {
+		return p.Request.User.Username
+	}
+	return p.Request.User.Name
+}
+
 func NewCodeupParser(requestBody io.ReadCloser, codeupHost string, token string, skipTLSVerify bool) (*Parser, error) {
 	body, err := parser.ReadBody(requestBody)
 	if err != nil {
@@ -92,14 +110,16 @@ func NewCodeupParser(requestBody io.ReadCloser, codeupHost string, token string,
 	}
 	return &Parser{
 		Base: parser.Base{
-			AccessApiPath:  fmt.Sprintf("%s/oapi/v1/codeup/organizations/%s/repositories/%s/files/neutron.yaml", codeupHost, orgId, encodedProjectPath),
-			AccessToken:    token,
-			AuthHeaderName: "x-yunxiao-token",
-			Client:         client,
-			CodeSha:        ref,
-			ReportSha:      reportSha,
-			TargetBranch:   targetBranch,
-			Trigger:        trigger,
+			FilesApiPath:    fmt.Sprintf("%s/oapi/v1/codeup/organizations/%s/repositories/%s/files", codeupHost, orgId, encodedProjectPath),
+			FilePathEscaper: parser.EncodeCodeupProjectPath,
+			TreeApiPath:     fmt.Sprintf("%s/oapi/v1/codeup/organizations/%s/repositories/%s/files/tree", codeupHost, orgId, encodedProjectPath),
+			AccessToken:     token,
+			AuthHeaderName:  "x-yunxiao-token",
+			Client:          client,
+			CodeSha:         ref,
+			ReportSha:       reportSha,
+			TargetBranch:    targetBranch,
+			Trigger:         trigger,
 		},
 		Request: request,
 	}, nil
@@ -0,0 +1,196 @@
+package gitea
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"neutron/internal/parser"
+	"strings"
+	"time"
+)
+
+// Gitea sends the event type and signature in headers; Forgejo sends the same
+// headers under its own name as well.
+const (
+	EventHeader            = "X-Gitea-Event"
+	SignatureHeader        = "X-Gitea-Signature"
+	ForgejoEventHeader     = "X-Forgejo-Event"
+	ForgejoSignatureHeader = "X-Forgejo-Signature"
+)
+
+type WebhookRequest struct {
+	Ref         string      `json:"ref"`
+	After       string      `json:"after"`
+	HeadCommit  *Commit     `json:"head_commit"`
+	Pusher      User        `json:"pusher"`
+	Sender      User        `json:"sender"`
+	Repository  Repository  `json:"repository"`
+	Action      string      `json:"action"`
+	Number      int         `json:"number"`
+	PullRequest PullRequest `json:"pull_request"`
+}
+
+type Commit struct {
+	Id string `json:"id"`
+}
+
+type User struct {
+	Login    string `json:"login"`
+	Username string `json:"username"`
+}
+
+type Repository struct {
+	Id       int    `json:"id"`
+	FullName string `json:"full_name"`
+	SshUrl   string `json:"ssh_url"`
+	CloneUrl string `json:"clone_url"`
+}
+
+type PullRequest struct {
+	Head Branch `json:"head"`
+	Base Branch `json:"base"`
+	User User   `json:"user"`
+}
+
+type Branch struct {
+	Ref string `json:"ref"`
+	Sha string `json:"sha"`
+}
+
+type Parser struct {
+	parser.Base
+	Request WebhookRequest
+	Event   string
+}
+
+// Username returns the account that caused the webhook event.
+func (p *Parser) Username() string {
+	for _, u := range []User{p.Request.Sender, p.Request.PullRequest.User, p.Request.Pusher} {
+		if u.Login != "" {
+			return u.Login
+		}
+		if u.Username != "" {
+			return u.Username
+		}
+	}
+	return ""
+}
+
+// VerifySignature checks the X-Gitea-Signature header (hex HMAC-SHA256 of the
+// body) against the webhook secret.
+func VerifySignature(body []byte, signature string, secret string) error {
+	if signature == "" {
+		return fmt.Errorf("missing %s header", SignatureHeader)
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("malformed %s header", SignatureHeader)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return fmt.Errorf("webhook signature mismatch")
+	}
+	return nil
+}
+
+// webhookType maps a Gitea event to the GitLab-style webhook type that
+// parser.DetectTrigger understands; pushes of tags are tag pushes.
+func webhookType(event string, ref string) string {
+	switch event {
+	case "push":
+		if strings.HasPrefix(ref, "refs/tags/") {
+			return "tag_push"
+		}
+		return "push"
+	case "pull_request":
+		return "merge_request"
+	}
+	return event
+}
+
+// NewGiteaParser parses a Gitea (or Forgejo) webhook. event is the
+// X-Gitea-Event header; the body must carry a valid X-Gitea-Signature made
+// with secret, so an empty secret rejects every webhook.
+func NewGiteaParser(requestBody io.ReadCloser, event string, signature string, secret string, giteaHost string, token string, skipTLSVerify bool) (*Parser, error) {
+	body, err := parser.ReadBody(requestBody)
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("no webhook secret configured to verify the signature")
+	}
+	if err := VerifySignature(body, signature, secret); err != nil {
+		return nil, err
+	}
+	var request WebhookRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("parsing webhook body: %w", err)
+	}
+
+	codeSha := request.After
+	// An annotated tag push may carry the tag object in after; check out the commit
+	if strings.HasPrefix(request.Ref, "refs/tags/") && request.HeadCommit != nil && request.HeadCommit.Id != "" {
+		codeSha = request.HeadCommit.Id
+	}
+	trigger, ref, reportSha, targetBranch, err := parser.DetectTrigger(
+		webhookType(event, request.Ref), codeSha, request.PullRequest.Head.Sha, request.PullRequest.Base.Ref,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if trigger != "MR" && strings.Trim(codeSha, "0") == "" {
+		return nil, fmt.Errorf("skipping push of deleted ref: %s", request.Ref)
+	}
+	// Skip PR events other than open/reopen/update (closed, edited, ...)
+	if trigger == "MR" {
+		action := request.Action
+		if action != "opened" && action != "reopened" && action != "synchronized" {
+			return nil, fmt.Errorf("skipping pull request action: %s", action)
+		}
+	}
+
+	if ref == "" {
+		return nil, fmt.Errorf("missing commit SHA in webhook payload (event: %s)", event)
+	}
+
+	repoPath := request.Repository.FullName
+	if repoPath == "" {
+		repoPath = parser.ExtractGitLabProjectPath(request.Repository.SshUrl)
+	}
+	if repoPath == "" {
+		return nil, fmt.Errorf("missing repository full_name in webhook payload")
+	}
+	contentsPath := fmt.Sprintf("%s/api/v1/repos/%s/contents", strings.TrimSuffix(giteaHost, "/"), repoPath)
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: skipTLSVerify},
+		},
+	}
+	return &Parser{
+		Base: parser.Base{
+			FilesApiPath:    contentsPath,
+			FilePathEscaper: parser.EscapeFilePath,
+			TreeApiPath:     contentsPath,
+			DirInPath:       true,
+			AccessToken:     token,
+			AuthHeaderName:  "Authorization",
+			AuthScheme:      "token",
+			Client:          client,
+			CodeSha:         ref,
+			ReportSha:       reportSha,
+			TargetBranch:    targetBranch,
+			Trigger:         trigger,
+		},
+		Request: request,
+		Event:   event,
+	}, nil
+}
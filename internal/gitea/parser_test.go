@@ -0,0 +1,68 @@
+package gitea
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const secret = "s3cret"
+
+func sign(body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestNewGiteaParser(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token tok" || r.URL.Path != "/api/v1/repos/owner/repo/contents/neutron.yaml" || r.URL.Query().Get("ref") != "c0ffee" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		content := base64.StdEncoding.EncodeToString([]byte("jobs:\n  build:\n    image: alpine\n    trigger: [MR]\n"))
+		_ = json.NewEncoder(w).Encode(map[string]string{"type": "file", "encoding": "base64", "content": content})
+	}))
+	defer srv.Close()
+
+	body := `{"action": "synchronized", "number": 3, "pull_request": {"head": {"ref": "feat", "sha": "c0ffee"}, "base": {"ref": "main"}},` +
+		` "sender": {"login": "carol"}, "repository": {"id": 9, "full_name": "owner/repo"}}`
+	p, err := NewGiteaParser(io.NopCloser(strings.NewReader(body)), "pull_request", sign(body), secret, srv.URL, "tok", false)
+	if err != nil {
+		t.Fatalf("NewGiteaParser() error = %v", err)
+	}
+	if p.Trigger != "MR" || p.CodeSha != "c0ffee" || p.TargetBranch != "main" || p.Username() != "carol" {
+		t.Errorf("parsed trigger=%s sha=%s target=%s user=%s", p.Trigger, p.CodeSha, p.TargetBranch, p.Username())
+	}
+	pipeline, err := p.Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if pipeline.Jobs["build"].Image != "alpine" {
+		t.Errorf("Parse() = %+v", pipeline)
+	}
+
+	if _, err := NewGiteaParser(io.NopCloser(strings.NewReader(body)), "pull_request", sign(body+" "), secret, srv.URL, "tok", false); err == nil {
+		t.Errorf("invalid signature accepted")
+	}
+	for _, signature := range []string{"", sign(body)} {
+		if _, err := NewGiteaParser(io.NopCloser(strings.NewReader(body)), "pull_request", signature, "", srv.URL, "tok", false); err == nil {
+			t.Errorf("webhook accepted without a configured secret (signature %q)", signature)
+		}
+	}
+	tag := `{"ref": "refs/tags/v1", "after": "c0ffee", "repository": {"full_name": "owner/repo"}}`
+	if p, err := NewGiteaParser(io.NopCloser(strings.NewReader(tag)), "push", sign(tag), secret, srv.URL, "tok", false); err != nil || p.Trigger != "TAG" {
+		t.Errorf("tag push parsed as %+v, %v", p, err)
+	}
+	deleted := `{"ref": "refs/heads/old", "after": "0000000000000000000000000000000000000000", "repository": {"full_name": "owner/repo"}}`
+	if _, err := NewGiteaParser(io.NopCloser(strings.NewReader(deleted)), "push", sign(deleted), secret, srv.URL, "tok", false); err == nil {
+		t.Errorf("push of a deleted branch accepted")
+	}
+}
@@ -0,0 +1,197 @@
+package github
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"neutron/internal/parser"
+	"strings"
+	"time"
+)
+
+// GitHub sends the event type in a header rather than in the payload.
+const (
+	EventHeader     = "X-GitHub-Event"
+	SignatureHeader = "X-Hub-Signature-256"
+)
+
+type WebhookRequest struct {
+	Ref         string      `json:"ref"`
+	After       string      `json:"after"`
+	Deleted     bool        `json:"deleted"`
+	HeadCommit  *Commit     `json:"head_commit"`
+	Pusher      Pusher      `json:"pusher"`
+	Sender      User        `json:"sender"`
+	Repository  Repository  `json:"repository"`
+	Action      string      `json:"action"`
+	Number      int         `json:"number"`
+	PullRequest PullRequest `json:"pull_request"`
+}
+
+type Commit struct {
+	Id string `json:"id"`
+}
+
+type Pusher struct {
+	Name string `json:"name"`
+}
+
+type User struct {
+	Login string `json:"login"`
+}
+
+type Repository struct {
+	Id       int    `json:"id"`
+	FullName string `json:"full_name"`
+	SshUrl   string `json:"ssh_url"`
+	CloneUrl string `json:"clone_url"`
+}
+
+type PullRequest struct {
+	Head Branch `json:"head"`
+	Base Branch `json:"base"`
+	User User   `json:"user"`
+}
+
+type Branch struct {
+	Ref string `json:"ref"`
+	Sha string `json:"sha"`
+}
+
+type Parser struct {
+	parser.Base
+	Request WebhookRequest
+	Event   string
+}
+
+// Username returns the account that caused the webhook event.
+func (p *Parser) Username() string {
+	if p.Request.Sender.Login != "" {
+		return p.Request.Sender.Login
+	}
+	if p.Request.PullRequest.User.Login != "" {
+		return p.Request.PullRequest.User.Login
+	}
+	return p.Request.Pusher.Name
+}
+
+// VerifySignature checks the X-Hub-Signature-256 header ("sha256=<hex>") of a
+// webhook body against the webhook secret.
+func VerifySignature(body []byte, signature string, secret string) error {
+	sig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return fmt.Errorf("missing or malformed %s header", SignatureHeader)
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("malformed %s header", SignatureHeader)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return fmt.Errorf("webhook signature mismatch")
+	}
+	return nil
+}
+
+// webhookType maps a GitHub event to the GitLab-style webhook type that
+// parser.DetectTrigger understands; pushes of tags are tag pushes.
+func webhookType(event string, ref string) string {
+	switch event {
+	case "push":
+		if strings.HasPrefix(ref, "refs/tags/") {
+			return "tag_push"
+		}
+		return "push"
+	case "pull_request":
+		return "merge_request"
+	}
+	return event
+}
+
+// NewGitHubParser parses a GitHub (or GitHub Enterprise) webhook. event is the
+// X-GitHub-Event header; the body must carry a valid X-Hub-Signature-256 made
+// with secret, so an empty secret rejects every webhook. githubHost is the web URL of the instance, the API root
+// is derived from it (see parser.GitHubApiUrl).
+func NewGitHubParser(requestBody io.ReadCloser, event string, signature string, secret string, githubHost string, token string, skipTLSVerify bool) (*Parser, error) {
+	body, err := parser.ReadBody(requestBody)
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("no webhook secret configured to verify %s", SignatureHeader)
+	}
+	if err := VerifySignature(body, signature, secret); err != nil {
+		return nil, err
+	}
+	var request WebhookRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("parsing webhook body: %w", err)
+	}
+
+	codeSha := request.After
+	// An annotated tag push carries the tag object in after; check out the commit
+	if strings.HasPrefix(request.Ref, "refs/tags/") && request.HeadCommit != nil && request.HeadCommit.Id != "" {
+		codeSha = request.HeadCommit.Id
+	}
+	trigger, ref, reportSha, targetBranch, err := parser.DetectTrigger(
+		webhookType(event, request.Ref), codeSha, request.PullRequest.Head.Sha, request.PullRequest.Base.Ref,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Deleted {
+		return nil, fmt.Errorf("skipping push of deleted ref: %s", request.Ref)
+	}
+	// Skip PR events other than open/reopen/update (closed, labeled, ...)
+	if trigger == "MR" {
+		action := request.Action
+		if action != "opened" && action != "reopened" && action != "synchronize" {
+			return nil, fmt.Errorf("skipping pull request action: %s", action)
+		}
+	}
+
+	if ref == "" {
+		return nil, fmt.Errorf("missing commit SHA in webhook payload (event: %s)", event)
+	}
+
+	repoPath := request.Repository.FullName
+	if repoPath == "" {
+		repoPath = parser.ExtractGitLabProjectPath(request.Repository.SshUrl)
+	}
+	if repoPath == "" {
+		return nil, fmt.Errorf("missing repository full_name in webhook payload")
+	}
+	contentsPath := fmt.Sprintf("%s/repos/%s/contents", parser.GitHubApiUrl(githubHost), repoPath)
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: skipTLSVerify},
+		},
+	}
+	return &Parser{
+		Base: parser.Base{
+			FilesApiPath:    contentsPath,
+			FilePathEscaper: parser.EscapeFilePath,
+			TreeApiPath:     contentsPath,
+			DirInPath:       true,
+			AccessToken:     token,
+			AuthHeaderName:  "Authorization",
+			AuthScheme:      "Bearer",
+			Client:          client,
+			CodeSha:         ref,
+			ReportSha:       reportSha,
+			TargetBranch:    targetBranch,
+			Trigger:         trigger,
+		},
+		Request: request,
+		Event:   event,
+	}, nil
+}
@@ -0,0 +1,153 @@
+package github
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const secret = "s3cret"
+
+func sign(body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// stubGitHub serves the contents API of owner/repo under /api/v3 like GitHub
+// Enterprise, with base64 content wrapped the way GitHub wraps it.
+func stubGitHub(t *testing.T, files map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		key := r.URL.Query().Get("ref") + ":" + strings.TrimPrefix(r.URL.Path, "/api/v3/repos/owner/repo/contents/")
+		data, ok := files[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		encoded := base64.StdEncoding.EncodeToString([]byte(data))
+		var wrapped strings.Builder
+		for len(encoded) > 60 {
+			wrapped.WriteString(encoded[:60] + "\n")
+			encoded = encoded[60:]
+		}
+		wrapped.WriteString(encoded)
+		_ = json.NewEncoder(w).Encode(map[string]string{"encoding": "base64", "content": wrapped.String()})
+	}))
+}
+
+func TestNewGitHubParser(t *testing.T) {
+	srv := stubGitHub(t, map[string]string{
+		"c0ffee:neutron.yaml": "jobs:\n  build:\n    image: golang:1.22\n    trigger: [PUSH, MR]\n    steps:\n      - name: test\n        cmd: go test ./... # a comment long enough to wrap the base64 content\n",
+	})
+	defer srv.Close()
+
+	repo := `"repository": {"id": 42, "full_name": "owner/repo", "ssh_url": "git@github.example.com:owner/repo.git"}`
+	tests := []struct {
+		name          string
+		event         string
+		body          string
+		wantTrigger   string
+		wantSha       string
+		wantTarget    string
+		wantUser      string
+		wantErrSubstr string
+	}{
+		{
+			name:        "branch push",
+			event:       "push",
+			body:        `{"ref": "refs/heads/main", "after": "c0ffee", "sender": {"login": "alice"}, ` + repo + `}`,
+			wantTrigger: "PUSH", wantSha: "c0ffee", wantUser: "alice",
+		},
+		{
+			name:        "annotated tag push checks out the commit",
+			event:       "push",
+			body:        `{"ref": "refs/tags/v1.0.0", "after": "7a90b1", "head_commit": {"id": "c0ffee"}, "sender": {"login": "alice"}, ` + repo + `}`,
+			wantTrigger: "TAG", wantSha: "c0ffee", wantUser: "alice",
+		},
+		{
+			name:        "pull request",
+			event:       "pull_request",
+			body:        `{"action": "synchronize", "number": 7, "pull_request": {"head": {"ref": "feat", "sha": "c0ffee"}, "base": {"ref": "main"}, "user": {"login": "bob"}}, "sender": {"login": "bob"}, ` + repo + `}`,
+			wantTrigger: "MR", wantSha: "c0ffee", wantTarget: "main", wantUser: "bob",
+		},
+		{
+			name:          "closed pull request",
+			event:         "pull_request",
+			body:          `{"action": "closed", "number": 7, "pull_request": {"head": {"sha": "c0ffee"}, "base": {"ref": "main"}}, ` + repo + `}`,
+			wantErrSubstr: "skipping pull request action: closed",
+		},
+		{
+			name:          "deleted branch",
+			event:         "push",
+			body:          `{"ref": "refs/heads/old", "after": "0000000000000000000000000000000000000000", "deleted": true, ` + repo + `}`,
+			wantErrSubstr: "deleted ref",
+		},
+		{
+			name:          "unsupported event",
+			event:         "issues",
+			body:          `{"action": "opened", ` + repo + `}`,
+			wantErrSubstr: "unsupported webhook type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewGitHubParser(io.NopCloser(strings.NewReader(tt.body)), tt.event, sign(tt.body), secret, srv.URL, "tok", false)
+			if tt.wantErrSubstr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrSubstr) {
+					t.Fatalf("NewGitHubParser() error = %v, want it to contain %q", err, tt.wantErrSubstr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewGitHubParser() error = %v", err)
+			}
+			if p.Trigger != tt.wantTrigger || p.CodeSha != tt.wantSha || p.ReportSha != tt.wantSha || p.TargetBranch != tt.wantTarget || p.Username() != tt.wantUser {
+				t.Errorf("parsed trigger=%s sha=%s report=%s target=%s user=%s", p.Trigger, p.CodeSha, p.ReportSha, p.TargetBranch, p.Username())
+			}
+			if tt.wantSha != "c0ffee" {
+				return
+			}
+			pipeline, err := p.Parse(nil)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if job := pipeline.Jobs["build"]; job.Image != "golang:1.22" || len(job.Steps) != 1 {
+				t.Errorf("Parse() build = %+v", job)
+			}
+		})
+	}
+}
+
+func TestNewGitHubParserSignature(t *testing.T) {
+	body := `{"ref": "refs/heads/main", "after": "c0ffee", "repository": {"full_name": "owner/repo"}}`
+	for name, signature := range map[string]string{
+		"missing":      "",
+		"sha1":         "sha1=0123",
+		"wrong secret": "sha256=" + strings.Repeat("00", 32),
+		"other body":   sign(body + " "),
+	} {
+		if _, err := NewGitHubParser(io.NopCloser(strings.NewReader(body)), "push", signature, secret, "https://github.example.com", "tok", false); err == nil {
+			t.Errorf("%s signature accepted", name)
+		}
+	}
+	if _, err := NewGitHubParser(io.NopCloser(strings.NewReader(body)), "push", sign(body), secret, "https://github.example.com", "tok", false); err != nil {
+		t.Errorf("valid signature rejected: %v", err)
+	}
+	for _, signature := range []string{"", sign(body)} {
+		if _, err := NewGitHubParser(io.NopCloser(strings.NewReader(body)), "push", signature, "", "https://github.example.com", "tok", false); err == nil {
+			t.Errorf("webhook accepted without a configured secret (signature %q)", signature)
+		}
+	}
+}
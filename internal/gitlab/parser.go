@@ -12,11 +12,17 @@ import (
 )
 
 type WebhookRequest struct {
-	WebhookType string     `json:"object_kind"`
-	CodeSha     string     `json:"checkout_sha"`
-	Ref         string     `json:"ref"`
-	Project     Project    `json:"project"`
-	Attributes  Attributes `json:"object_attributes"`
+	WebhookType  string     `json:"object_kind"`
+	CodeSha      string     `json:"checkout_sha"`
+	Ref          string     `json:"ref"`
+	UserUsername string     `json:"user_username"` // push / tag_push author
+	User         User       `json:"user"`          // merge_request author
+	Project      Project    `json:"project"`
+	Attributes   Attributes `json:"object_attributes"`
+}
+
+type User struct {
+	Username string `json:"username"`
 }
 
 type Project struct {
@@ -41,6 +47,14 @@ type Parser struct {
 	Request WebhookRequest
 }
 
+// Username returns the account that caused the webhook event.
+func (p *Parser) Username() string {
+	if p.Request.UserUsername != "" {
+		return p.Request.UserUsername
+	}
+	return p.Request.User.Username
+}
+
 func NewGitLabParser(requestBody io.ReadCloser, gitlabHost string, token string, skipTLSVerify bool) (*Parser, error) {
 	body, err := parser.ReadBody(requestBody)
 	if err != nil {
@@ -88,13 +102,14 @@ func NewGitLabParser(requestBody io.ReadCloser, gitlabHost string, token string,
 	}
 	return &Parser{
 		Base: parser.Base{
-			AccessApiPath: fmt.Sprintf("%s/api/v4/projects/%s/repository/files/neutron.yaml", gitlabHost, encodedPath),
-			AccessToken:   token,
-			Client:        client,
-			CodeSha:       ref,
-			ReportSha:     reportSha,
-			TargetBranch:  targetBranch,
-			Trigger:       trigger,
+			FilesApiPath: fmt.Sprintf("%s/api/v4/projects/%s/repository/files", gitlabHost, encodedPath),
+			TreeApiPath:  fmt.Sprintf("%s/api/v4/projects/%s/repository/tree", gitlabHost, encodedPath),
+			AccessToken:  token,
+			Client:       client,
+			CodeSha:      ref,
+			ReportSha:    reportSha,
+			TargetBranch: targetBranch,
+			Trigger:      trigger,
 		},
 		Request: request,
 	}, nil
@@ -0,0 +1,187 @@
+package launcher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	"neutron/internal/model"
+)
+
+// askpass answers git's credential prompts from the container's env, so the
+// token is neither written to .git/config nor shown in the command.
+const askpass = `printf '%s\n' '#!/bin/sh' 'case "$1" in Username*) echo "$GIT_USER" ;; *) echo "$GIT_PASSWORD" ;; esac' > /tmp/askpass && chmod 700 /tmp/askpass && export GIT_ASKPASS=/tmp/askpass GIT_TERMINAL_PROMPT=0`
+
+// checkoutContainer returns the init container cloning the repository into the
+// repo volume.
+func (l *Launcher) checkoutContainer(cloneUrl string) v1.Container {
+	script := l.checkoutCommand(cloneUrl)
+	mounts := []v1.VolumeMount{{MountPath: "/repo", Name: "repo"}}
+	if l.GitCache != nil {
+		if l.GitCache.HostPath != "" {
+			// nothing else refreshes a node's mirrors; a failed refresh only makes the clone slower
+			script = mirrorScript(cloneUrl, l.GitCache.MaxIdleDays) + " || true; " + script
+		}
+		mounts = append(mounts, v1.VolumeMount{MountPath: "/cache", Name: "git-cache", ReadOnly: l.GitCache.HostPath == ""})
+	}
+	c := l.gitContainer("checkout", script, mounts)
+	c.WorkingDir = "/repo"
+	return c
+}
+
+// gitContainer returns a container of the checkout image running script with
+// the SSH key or, for HTTPS, the token.
+func (l *Launcher) gitContainer(name, script string, mounts []v1.VolumeMount) v1.Container {
+	var env []v1.EnvVar
+	if l.HttpsUser != "" {
+		script = askpass + " && " + script
+		env = []v1.EnvVar{
+			{Name: "GIT_USER", Value: l.HttpsUser},
+			{Name: "GIT_PASSWORD", Value: l.HttpsPassword},
+		}
+		if l.SkipTLSVerify {
+			env = append(env, v1.EnvVar{Name: "GIT_SSL_NO_VERIFY", Value: "true"})
+		}
+	} else {
+		mounts = append(mounts, v1.VolumeMount{MountPath: "/root/.ssh/id_rsa", Name: "private-key", SubPath: "id_rsa", ReadOnly: true})
+	}
+	if l.KnownHostsSecret != "" {
+		// submodules may still be cloned over SSH, so pin host keys in HTTPS mode too
+		env = append(env, v1.EnvVar{Name: "GIT_SSH_COMMAND", Value: "ssh -o StrictHostKeyChecking=yes -o UserKnownHostsFile=/root/.ssh/known_hosts"})
+		mounts = append(mounts, v1.VolumeMount{MountPath: "/root/.ssh/known_hosts", Name: "known-hosts", SubPath: "known_hosts", ReadOnly: true})
+	} else {
+		env = append(env, v1.EnvVar{Name: "GIT_SSH_COMMAND", Value: "ssh -o StrictHostKeyChecking=no"})
+	}
+	return v1.Container{
+		Name:         name,
+		Image:        l.CheckoutImage,
+		Command:      []string{"/bin/sh", "-c", script},
+		Env:          env,
+		VolumeMounts: mounts,
+	}
+}
+
+// checkoutVolumes returns the Secret and cache volumes the checkout container
+// mounts. HTTPS checkouts don't need the SSH key Secret to exist.
+func (l *Launcher) checkoutVolumes() []v1.Volume {
+	var volumes []v1.Volume
+	if l.HttpsUser == "" {
+		volumes = append(volumes, secretVolume("private-key", l.SshKeyName, "id_rsa"))
+	}
+	if l.KnownHostsSecret != "" {
+		volumes = append(volumes, secretVolume("known-hosts", l.KnownHostsSecret, "known_hosts"))
+	}
+	if l.GitCache != nil {
+		volumes = append(volumes, cacheVolume(l.GitCache))
+	}
+	return volumes
+}
+
+func secretVolume(name, secretName, key string) v1.Volume {
+	return v1.Volume{Name: name, VolumeSource: v1.VolumeSource{
+		Secret: &v1.SecretVolumeSource{
+			SecretName:  secretName,
+			Items:       []v1.KeyToPath{{Key: key, Path: key}},
+			DefaultMode: int32Ptr(0400),
+		},
+	}}
+}
+
+// maxDeepen bounds how often a shallow MR checkout deepens its history looking
+// for the merge base before it fetches the whole history instead.
+const maxDeepen = 10
+
+// checkoutCommand returns the script of the checkout init container: clone
+// cloneUrl into /repo and check out the job's commit, or for MR merge it into
+// the target branch. Without depth, filter or sparse directories it is a full
+// clone; with them it fetches only the commits it needs, which relies on the
+// platform allowing commits to be fetched by SHA, as MR checkouts already do.
+func (l *Launcher) checkoutCommand(cloneUrl string) string {
+	rc := l.RunnerConfig
+	co := rc.Checkout
+	if co == nil {
+		co = &model.Checkout{}
+	}
+	sha := shellEscape(rc.CommitSha)
+	mr := rc.Trigger == "MR" && rc.TargetBranch != ""
+	var reference string
+	mirror := l.mirrorPath(cloneUrl)
+	if mirror != "" {
+		reference = " --reference-if-able " + shellEscape(mirror) + " --dissociate"
+	}
+
+	var cmds []string
+	if co.Depth == 0 && co.Filter == "" && len(co.Sparse) == 0 {
+		if mr {
+			// clone target branch, fetch source commit, merge
+			cmds = []string{
+				fmt.Sprintf("git clone%s --branch %s %s /repo", reference, shellEscape(rc.TargetBranch), shellEscape(cloneUrl)),
+				"cd /repo",
+				"git config user.email neutron@ci",
+				"git config user.name neutron",
+				"git fetch origin " + sha,
+				"git merge --no-edit " + sha,
+			}
+		} else {
+			// for tag or push, checkout specific sha
+			cmds = []string{fmt.Sprintf("git clone%s %s /repo", reference, shellEscape(cloneUrl)), "git checkout " + sha}
+		}
+	} else {
+		var fetchOpts string
+		if co.Depth > 0 {
+			fetchOpts += " --depth " + strconv.Itoa(co.Depth)
+		}
+		if co.Filter != "" {
+			fetchOpts += " --filter=" + shellEscape(co.Filter)
+		}
+		cmds = []string{"git init -q /repo", "cd /repo", "git remote add origin " + shellEscape(cloneUrl)}
+		if mirror != "" {
+			// borrow the mirror's objects while fetching, like clone --reference
+			cmds = append(cmds, fmt.Sprintf("if [ -d %[1]s ]; then echo %[1]s/objects > .git/objects/info/alternates; fi", shellEscape(mirror)))
+		}
+		if len(co.Sparse) > 0 {
+			dirs := make([]string, len(co.Sparse))
+			for i, dir := range co.Sparse {
+				dirs[i] = shellEscape(dir)
+			}
+			cmds = append(cmds, "git sparse-checkout set -- "+strings.Join(dirs, " "))
+		}
+		if mr {
+			branch := shellEscape(rc.TargetBranch)
+			target := shellEscape("+refs/heads/" + rc.TargetBranch + ":refs/remotes/origin/" + rc.TargetBranch)
+			cmds = append(cmds,
+				"git config user.email neutron@ci",
+				"git config user.name neutron",
+				fmt.Sprintf("git fetch -q%s origin %s", fetchOpts, target),
+				fmt.Sprintf("git checkout -q -B %s %s", branch, shellEscape("origin/"+rc.TargetBranch)),
+				fmt.Sprintf("git fetch -q%s origin %s", fetchOpts, sha),
+			)
+			if co.Depth > 0 {
+				// a shallow history may not reach the merge base yet
+				cmds = append(cmds, fmt.Sprintf(
+					"n=0; until git merge-base HEAD %[1]s >/dev/null 2>&1; do n=$((n+1)); if [ $n -gt %[2]d ]; then git fetch -q --unshallow origin; break; fi; git fetch -q --deepen=%[3]d origin %[4]s %[1]s; done",
+					sha, maxDeepen, co.Depth, shellEscape("refs/heads/"+rc.TargetBranch)))
+			}
+			cmds = append(cmds, "git merge --no-edit "+sha)
+		} else {
+			cmds = append(cmds, fmt.Sprintf("git fetch -q%s origin %s", fetchOpts, sha), "git checkout -q FETCH_HEAD")
+		}
+		if mirror != "" {
+			// copy the borrowed objects, like clone --dissociate, so the job survives the mirror's eviction
+			cmds = append(cmds, "if [ -f .git/objects/info/alternates ]; then git repack -a -d -q && rm .git/objects/info/alternates; fi")
+		}
+	}
+
+	switch co.Submodules {
+	case "true":
+		cmds = append(cmds, "git submodule update --init")
+	case "recursive":
+		cmds = append(cmds, "git submodule update --init --recursive")
+	}
+	if co.Lfs {
+		cmds = append(cmds, "git lfs install --local", "git lfs pull")
+	}
+	return strings.Join(append(cmds, "chmod -R 777 /repo"), " && ")
+}
@@ -0,0 +1,40 @@
+package launcher
+
+import (
+	"strings"
+	"testing"
+
+	"neutron/internal/model"
+)
+
+// TestCheckoutCommand covers a shallow, filtered, sparse MR checkout, and that
+// a job without options keeps the full clone.
+func TestCheckoutCommand(t *testing.T) {
+	const cloneUrl = "git@gitlab.example.com:web/portal.git"
+	l := &Launcher{RunnerConfig: model.RunnerConfig{
+		CommitSha:    "deadbeef",
+		Trigger:      "MR",
+		GitRepoUrl:   cloneUrl,
+		TargetBranch: "develop",
+		Checkout:     &model.Checkout{Depth: 50, Filter: "blob:none", Sparse: []string{"web", "libs/ui"}, Submodules: "recursive", Lfs: true},
+	}}
+	checkout := l.checkoutCommand(cloneUrl)
+	for _, want := range []string{
+		"git sparse-checkout set -- 'web' 'libs/ui'",
+		"git fetch -q --depth 50 --filter='blob:none' origin '+refs/heads/develop:refs/remotes/origin/develop'",
+		"--deepen=50",
+		"git merge --no-edit 'deadbeef'",
+		"git submodule update --init --recursive",
+		"git lfs pull",
+	} {
+		if !strings.Contains(checkout, want) {
+			t.Errorf("checkout %q does not contain %q", checkout, want)
+		}
+	}
+
+	l.RunnerConfig.Trigger, l.RunnerConfig.TargetBranch, l.RunnerConfig.Checkout = "PUSH", "", nil
+	checkout = l.checkoutCommand(cloneUrl)
+	if want := "git clone 'git@gitlab.example.com:web/portal.git' /repo && git checkout 'deadbeef' && chmod -R 777 /repo"; checkout != want {
+		t.Errorf("checkout = %q, want %q", checkout, want)
+	}
+}
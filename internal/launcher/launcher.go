@@ -1,6 +1,7 @@
 package launcher
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	batchv1 "k8s.io/api/batch/v1"
@@ -21,8 +22,18 @@ type Launcher struct {
 	ImagePullSecrets []string
 	Platform         string
 	PodApiUrl        string          // override NEUTRON_API_URL for pods (local dev)
-	ExtraEnv         []v1.EnvVar     // platform-specific env vars (e.g. TARGET_BRANCH for GitLab MR)
+	ApiNamespace     string          // namespace of the neutron-api Service pods report to; Namespace when empty
+	ExtraEnv         []v1.EnvVar     // job-specific env vars (e.g. TARGET_BRANCH for MR)
 	Resources        *model.Resources // job-level resource requirements
+	FullJobName      string           // fixed K8s Job name (e.g. an approved manual job); generated when empty
+	HttpsUser        string           // with HttpsPassword, clone CloneUrl over HTTPS instead of with the SSH key
+	HttpsPassword    string
+	SkipTLSVerify    bool             // don't verify the certificate of the HTTPS clone
+	KnownHostsSecret string           // Secret whose known_hosts pins SSH host keys; host keys are not checked when empty
+	GitCache         *model.GitCacheConfig // mirrors the checkout borrows objects from; none when nil
+	Scheduling       *model.Scheduling     // node selector, tolerations, affinity and classes of the pod; checked by ValidateScheduling
+	PodTemplate      *v1.PodTemplateSpec   // admin's base pod the generated one is merged into; none when nil
+	Policy           model.PodPolicy       // rules the pod must follow, checked by CreateJob
 }
 
 func NewLauncher(namespace string, runnerConfig model.RunnerConfig, initImage string, checkoutImage string, baseImage string, keyName string, imagePullSecrets []string, platform string, podApiUrl string, resources *model.Resources, extraEnv ...v1.EnvVar) *Launcher {
@@ -41,21 +52,24 @@ func NewLauncher(namespace string, runnerConfig model.RunnerConfig, initImage st
 	}
 }
 
-func (l *Launcher) CreateJob(neutronHost string) *batchv1.Job {
-	ts := time.Now().Format("20060102-150405")
-	fullJobName := fmt.Sprintf("neutron-%s-%s", l.RunnerConfig.JobName, ts)
-	var checkoutCommand string
-	if l.RunnerConfig.Trigger == "MR" && l.RunnerConfig.TargetBranch != "" {
-		// clone target branch, fetch source commit, merge
-		checkoutCommand = fmt.Sprintf(
-			"git clone --branch %s %s /repo && cd /repo && git config user.email neutron@ci && git config user.name neutron && git fetch origin %s && git merge --no-edit %s && chmod -R 777 /repo",
-			shellEscape(l.RunnerConfig.TargetBranch), shellEscape(l.RunnerConfig.GitRepoUrl),
-			shellEscape(l.RunnerConfig.CommitSha), shellEscape(l.RunnerConfig.CommitSha))
-	} else {
-		// for tag or push, checkout specific sha
-		checkoutCommand = fmt.Sprintf("git clone %s /repo && git checkout %s && chmod -R 777 /repo",
-			shellEscape(l.RunnerConfig.GitRepoUrl), shellEscape(l.RunnerConfig.CommitSha))
+// JobName returns the K8s Job name for a pipeline job created at t. The
+// trailing timestamp is relied on by the repository's recent-job queries.
+func JobName(jobName string, t time.Time) string {
+	return fmt.Sprintf("neutron-%s-%s", jobName, t.Format("20060102-150405"))
+}
+
+// TokenFile is where the runner finds the codebase token of a job whose
+// RunnerConfig.TokenFile is set.
+const TokenFile = "/pipeline/.codebase-token"
+
+// CreateJob returns the K8s Job of the pipeline job, or an error when its pod
+// breaks the admin's pod policy.
+func (l *Launcher) CreateJob(neutronHost string) (*batchv1.Job, error) {
+	fullJobName := l.FullJobName
+	if fullJobName == "" {
+		fullJobName = JobName(l.RunnerConfig.JobName, time.Now())
 	}
+	cloneUrl := l.cloneUrl()
 
 	// common env vars for all platforms
 	env := []v1.EnvVar{
@@ -85,8 +99,33 @@ func (l *Launcher) CreateJob(neutronHost string) *batchv1.Job {
 	if l.RunnerConfig.SkipPlatformReport {
 		env = append(env, v1.EnvVar{Name: "SKIP_PLATFORM_REPORT", Value: "true"})
 	}
+	if len(l.RunnerConfig.Steps) > 0 {
+		// resolved steps; the runner would not see included or inherited ones in neutron.yaml
+		if steps, err := json.Marshal(l.RunnerConfig.Steps); err == nil {
+			env = append(env, v1.EnvVar{Name: "PIPELINE_STEPS", Value: string(steps)})
+		}
+	}
 	env = append(env, l.ExtraEnv...)
 
+	initEnv := env
+	initCommand := []string{"cp", "/runners/neutron-runner", "/pipeline/runner"}
+	if l.RunnerConfig.TokenFile {
+		// Only the init container gets the token: it leaves it in a file the
+		// runner reads and deletes before the first step starts
+		pipelineEnv := []v1.EnvVar{{Name: "CODEBASE_TOKEN_FILE", Value: TokenFile}}
+		for _, e := range env {
+			if e.Name != "CODEBASE_TOKEN" {
+				pipelineEnv = append(pipelineEnv, e)
+			}
+		}
+		env = pipelineEnv
+	}
+
+	resources, err := l.buildResourceRequirements()
+	if err != nil {
+		return nil, fmt.Errorf("job %s: %w", l.RunnerConfig.JobName, err)
+	}
+
 	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      fullJobName,
@@ -107,41 +146,22 @@ func (l *Launcher) CreateJob(neutronHost string) *batchv1.Job {
 						{
 							Name:    "pipeline",
 							Image:   l.PipelineImage,
-							Command: []string{"/pipeline/runner"},
+							Command: []string{"/pipeline/runner", "run"},
 							Env:     env,
 							VolumeMounts: []v1.VolumeMount{
 								{MountPath: "/pipeline", Name: "pipeline"},
 								{MountPath: "/repo", Name: "repo"},
 							},
-							Resources: l.buildResourceRequirements(),
+							Resources: resources,
 						},
 					},
 					InitContainers: []v1.Container{
+						l.checkoutContainer(cloneUrl),
 						{
-							Name:  "checkout",
-							Image: l.CheckoutImage,
-							Command: []string{
-								"/bin/sh",
-								"-c",
-								checkoutCommand,
-							},
-							WorkingDir: "/repo",
-							Env: []v1.EnvVar{
-								{Name: "GIT_SSH_COMMAND", Value: "ssh -o StrictHostKeyChecking=no"},
-							},
-							VolumeMounts: []v1.VolumeMount{
-								{MountPath: "/repo", Name: "repo"},
-								{MountPath: "/root/.ssh/id_rsa", Name: "private-key", SubPath: "id_rsa", ReadOnly: true},
-							},
-						},
-						{
-							Name:  "init",
-							Image: l.InitImage,
-							Command: []string{
-								"/bin/sh", "-c",
-								`case "${RUNNER_PLATFORM}" in codeup) cp /runners/codeup-runner /pipeline/runner ;; *) cp /runners/gitlab-runner /pipeline/runner ;; esac`,
-							},
-							Env: env,
+							Name:    "init",
+							Image:   l.InitImage,
+							Command: initCommand,
+							Env:     initEnv,
 							VolumeMounts: []v1.VolumeMount{
 								{MountPath: "/pipeline", Name: "pipeline"},
 							},
@@ -149,32 +169,75 @@ func (l *Launcher) CreateJob(neutronHost string) *batchv1.Job {
 					},
 					RestartPolicy:   v1.RestartPolicyNever,
 					ImagePullSecrets: l.imagePullSecrets(),
-					Volumes: []v1.Volume{
+					Volumes: append([]v1.Volume{
 						{Name: "pipeline", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
 						{Name: "repo", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
-						{Name: "private-key", VolumeSource: v1.VolumeSource{
-							Secret: &v1.SecretVolumeSource{
-								SecretName: l.SshKeyName,
-								Items: []v1.KeyToPath{
-									{Key: "id_rsa", Path: "id_rsa"},
-								},
-								DefaultMode: int32Ptr(0400),
-							},
-						},
-						},
-					},
+					}, l.checkoutVolumes()...),
 				},
 			},
 		},
 	}
-	return job
+	l.applyPodTemplate(&job.Spec.Template)
+	if err := l.applyScheduling(&job.Spec.Template.Spec); err != nil {
+		return nil, fmt.Errorf("job %s: %w", l.RunnerConfig.JobName, err)
+	}
+	if err := l.checkPolicy(&job.Spec.Template.Spec); err != nil {
+		return nil, fmt.Errorf("job %s: %w", l.RunnerConfig.JobName, err)
+	}
+	if l.RunnerConfig.TokenFile {
+		spec := &job.Spec.Template.Spec
+		for i := range spec.InitContainers {
+			if spec.InitContainers[i].Name == "init" {
+				spec.InitContainers[i].Command = []string{"/bin/sh", "-c", tokenFileScript(spec)}
+			}
+		}
+	}
+	return job, nil
+}
+
+// tokenFileScript is the init container's script of a job with a token file.
+// The file is only readable by the pipeline container's user: it is given to
+// the user the pod template runs the pipeline container as, and made readable
+// by the pod's fsGroup when there is one. Without either, the pipeline
+// container has to run as the init container's user.
+func tokenFileScript(spec *v1.PodSpec) string {
+	script := fmt.Sprintf(`cp /runners/neutron-runner /pipeline/runner && umask 077 && printf %%s "$CODEBASE_TOKEN" > %s`, TokenFile)
+	var runAsUser, fsGroup *int64
+	if sc := spec.SecurityContext; sc != nil {
+		runAsUser, fsGroup = sc.RunAsUser, sc.FSGroup
+	}
+	if sc := spec.Containers[0].SecurityContext; sc != nil && sc.RunAsUser != nil {
+		runAsUser = sc.RunAsUser
+	}
+	if runAsUser != nil {
+		script += fmt.Sprintf(" && chown %d %s", *runAsUser, TokenFile)
+	}
+	if fsGroup != nil {
+		script += fmt.Sprintf(" && chgrp %d %s && chmod 0440 %s", *fsGroup, TokenFile, TokenFile)
+	}
+	return script
+}
+
+// cloneUrl is the URL the checkout clones: RunnerConfig.CloneUrl, or else the
+// repository URL.
+func (l *Launcher) cloneUrl() string {
+	if l.RunnerConfig.CloneUrl != "" {
+		return l.RunnerConfig.CloneUrl
+	}
+	return l.RunnerConfig.GitRepoUrl
 }
 
+// podApiUrl is the NEUTRON_API_URL of the pod: PodApiUrl, or else the
+// neutron-api Service, which need not be in the namespace of the job.
 func (l *Launcher) podApiUrl() string {
 	if l.PodApiUrl != "" {
 		return l.PodApiUrl
 	}
-	return fmt.Sprintf("http://neutron-api.%s.svc.cluster.local:8888", l.Namespace)
+	namespace := l.ApiNamespace
+	if namespace == "" {
+		namespace = l.Namespace
+	}
+	return fmt.Sprintf("http://neutron-api.%s.svc.cluster.local:8888", namespace)
 }
 
 func int32Ptr(i int32) *int32 {
@@ -191,35 +254,44 @@ func (l *Launcher) imagePullSecrets() []v1.LocalObjectReference {
 	return refs
 }
 
-// buildResourceRequirements converts model.Resources to K8s ResourceRequirements
-func (l *Launcher) buildResourceRequirements() v1.ResourceRequirements {
+// buildResourceRequirements converts model.Resources to K8s ResourceRequirements.
+// Resolved pipelines only carry valid quantities, but a job spec persisted
+// before they were checked may not, so a bad one is an error, not a panic.
+func (l *Launcher) buildResourceRequirements() (v1.ResourceRequirements, error) {
 	if l.Resources == nil {
-		return v1.ResourceRequirements{}
+		return v1.ResourceRequirements{}, nil
 	}
-
-	req := v1.ResourceRequirements{}
-
-	if l.Resources.Limits.Cpu != "" || l.Resources.Limits.Memory != "" {
-		req.Limits = v1.ResourceList{}
-		if l.Resources.Limits.Cpu != "" {
-			req.Limits[v1.ResourceCPU] = resource.MustParse(l.Resources.Limits.Cpu)
-		}
-		if l.Resources.Limits.Memory != "" {
-			req.Limits[v1.ResourceMemory] = resource.MustParse(l.Resources.Limits.Memory)
-		}
+	limits, err := resourceList("limits", l.Resources.Limits)
+	if err != nil {
+		return v1.ResourceRequirements{}, err
+	}
+	requests, err := resourceList("requests", l.Resources.Requests)
+	if err != nil {
+		return v1.ResourceRequirements{}, err
 	}
+	return v1.ResourceRequirements{Limits: limits, Requests: requests}, nil
+}
 
-	if l.Resources.Requests.Cpu != "" || l.Resources.Requests.Memory != "" {
-		req.Requests = v1.ResourceList{}
-		if l.Resources.Requests.Cpu != "" {
-			req.Requests[v1.ResourceCPU] = resource.MustParse(l.Resources.Requests.Cpu)
+// resourceList parses the cpu and memory of spec; nil when neither is set.
+func resourceList(kind string, spec model.ResourceSpec) (v1.ResourceList, error) {
+	var list v1.ResourceList
+	for _, r := range []struct {
+		name  v1.ResourceName
+		value string
+	}{{v1.ResourceCPU, spec.Cpu}, {v1.ResourceMemory, spec.Memory}} {
+		if r.value == "" {
+			continue
 		}
-		if l.Resources.Requests.Memory != "" {
-			req.Requests[v1.ResourceMemory] = resource.MustParse(l.Resources.Requests.Memory)
+		q, err := resource.ParseQuantity(r.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resources.%s.%s %q: %v", kind, r.name, r.value, err)
 		}
+		if list == nil {
+			list = v1.ResourceList{}
+		}
+		list[r.name] = q
 	}
-
-	return req
+	return list, nil
 }
 
 // shellEscape wraps a string in single quotes for safe use in shell commands.
@@ -0,0 +1,45 @@
+package launcher
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"neutron/internal/model"
+)
+
+func TestResourceList(t *testing.T) {
+	list, err := resourceList("limits", model.ResourceSpec{Cpu: "500m", Memory: "1Gi"})
+	if err != nil || list.Cpu().String() != "500m" || list.Memory().String() != "1Gi" {
+		t.Errorf("resourceList = %v, %v", list, err)
+	}
+	if list, err := resourceList("limits", model.ResourceSpec{}); err != nil || list != nil {
+		t.Errorf("resourceList of nothing = %v, %v, want nil", list, err)
+	}
+	_, err = resourceList("requests", model.ResourceSpec{Memory: "2 gigs"})
+	if err == nil || !strings.HasPrefix(err.Error(), `invalid resources.requests.memory "2 gigs"`) {
+		t.Errorf("resourceList error = %v", err)
+	}
+
+	l := &Launcher{Resources: &model.Resources{Requests: model.ResourceSpec{Cpu: "two"}}}
+	if _, err := l.CreateJob("http://neutron.local"); err == nil || !strings.Contains(err.Error(), "resources.requests.cpu") {
+		t.Errorf("CreateJob error = %v", err)
+	}
+}
+
+// TestTokenFileScript verifies that the token file is handed to the user and
+// fsGroup the pod template runs the pipeline container with.
+func TestTokenFileScript(t *testing.T) {
+	uid, podUid, gid := int64(1000), int64(2000), int64(3000)
+	spec := &v1.PodSpec{Containers: []v1.Container{{Name: "pipeline"}}}
+	if script := tokenFileScript(spec); strings.Contains(script, "chown") || strings.Contains(script, "chgrp") {
+		t.Errorf("script without a security context = %q", script)
+	}
+	spec.SecurityContext = &v1.PodSecurityContext{RunAsUser: &podUid, FSGroup: &gid}
+	spec.Containers[0].SecurityContext = &v1.SecurityContext{RunAsUser: &uid}
+	script := tokenFileScript(spec)
+	if !strings.HasSuffix(script, " && chown 1000 "+TokenFile+" && chgrp 3000 "+TokenFile+" && chmod 0440 "+TokenFile) {
+		t.Errorf("script = %q, want the file owned by the pipeline user and readable by the fsGroup", script)
+	}
+}
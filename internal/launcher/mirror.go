@@ -0,0 +1,97 @@
+package launcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"neutron/internal/model"
+)
+
+// defaultMaxIdleDays is how long an unused mirror is kept when the git cache
+// sets no max-idle-days.
+const defaultMaxIdleDays = 14
+
+// MirrorKey names the mirror of a clone URL in the git cache. The same
+// repository cloned over SSH and HTTPS has two mirrors, each holding the URL
+// it fetches from.
+func MirrorKey(cloneUrl string) string {
+	sum := sha256.Sum256([]byte(cloneUrl))
+	return hex.EncodeToString(sum[:8])
+}
+
+// mirrorPath is where the checkout container finds the mirror of cloneUrl, or
+// "" without a git cache.
+func (l *Launcher) mirrorPath(cloneUrl string) string {
+	if l.GitCache == nil {
+		return ""
+	}
+	return "/cache/" + MirrorKey(cloneUrl) + ".git"
+}
+
+// mirrorScript creates or fetches the mirror of cloneUrl under a lock, marks it
+// used, and deletes mirrors unused for maxIdleDays. A new mirror is cloned
+// aside and moved into place, so checkouts never borrow from a partial one.
+func mirrorScript(cloneUrl string, maxIdleDays int) string {
+	if maxIdleDays <= 0 {
+		maxIdleDays = defaultMaxIdleDays
+	}
+	key := MirrorKey(cloneUrl)
+	mirror := "/cache/" + key + ".git"
+	return fmt.Sprintf(
+		`mkdir -p /cache && (flock 9 && if [ -d %[1]s ]; then git -C %[1]s fetch -q --prune; else rm -rf %[1]s.tmp && git clone -q --mirror %[2]s %[1]s.tmp && mv %[1]s.tmp %[1]s; fi && touch %[1]s && find /cache -maxdepth 1 \( -name '*.git' -o -name '*.tmp' -o -name '*.lock' \) -mtime +%[3]d -exec rm -rf {} +) 9>/cache/%[4]s.lock`,
+		mirror, shellEscape(cloneUrl), maxIdleDays, key)
+}
+
+// CreateMirrorJob returns the K8s Job refreshing the shared mirror of the
+// repository in the git cache PVC. It is named after the mirror, so webhooks
+// arriving while a refresh runs do not start another, and deleted once done.
+// Its pod is built like a job's, from the pod template and under the pod
+// policy, which it may break.
+func (l *Launcher) CreateMirrorJob() (*batchv1.Job, error) {
+	cloneUrl := l.cloneUrl()
+	mounts := []v1.VolumeMount{{MountPath: "/cache", Name: "git-cache"}}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "neutron-mirror-" + MirrorKey(cloneUrl),
+			Namespace:   l.Namespace,
+			Annotations: map[string]string{"gitPath": l.RunnerConfig.GitRepoUrl},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            int32Ptr(0),
+			TTLSecondsAfterFinished: int32Ptr(0),
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Containers:       []v1.Container{l.gitContainer("mirror", mirrorScript(cloneUrl, l.GitCache.MaxIdleDays), mounts)},
+					RestartPolicy:    v1.RestartPolicyNever,
+					ImagePullSecrets: l.imagePullSecrets(),
+					Volumes:          l.checkoutVolumes(),
+				},
+			},
+		},
+	}
+	l.applyPodTemplate(&job.Spec.Template)
+	if err := l.applyScheduling(&job.Spec.Template.Spec); err != nil {
+		return nil, fmt.Errorf("mirror refresh: %w", err)
+	}
+	if err := l.checkPolicy(&job.Spec.Template.Spec); err != nil {
+		return nil, fmt.Errorf("mirror refresh: %w", err)
+	}
+	return job, nil
+}
+
+func cacheVolume(cache *model.GitCacheConfig) v1.Volume {
+	if cache.HostPath != "" {
+		hostPathType := v1.HostPathDirectoryOrCreate
+		return v1.Volume{Name: "git-cache", VolumeSource: v1.VolumeSource{
+			HostPath: &v1.HostPathVolumeSource{Path: cache.HostPath, Type: &hostPathType},
+		}}
+	}
+	return v1.Volume{Name: "git-cache", VolumeSource: v1.VolumeSource{
+		PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: cache.Pvc},
+	}}
+}
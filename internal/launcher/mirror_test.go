@@ -0,0 +1,91 @@
+package launcher
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"neutron/internal/model"
+)
+
+// TestCheckoutBorrowsFromMirror covers checkouts borrowing from the mirror of
+// a shared cache, read-only, and from a node's mirror, refreshed first.
+func TestCheckoutBorrowsFromMirror(t *testing.T) {
+	const cloneUrl = "git@gitlab.example.com:g/app.git"
+	mirror := "/cache/" + MirrorKey(cloneUrl) + ".git"
+	l := &Launcher{
+		RunnerConfig: model.RunnerConfig{CommitSha: "abc", Trigger: "PUSH", GitRepoUrl: cloneUrl},
+		GitCache:     &model.GitCacheConfig{Pvc: "git-cache"},
+	}
+	checkout := l.checkoutContainer(cloneUrl)
+	if !strings.Contains(checkout.Command[2], "git clone --reference-if-able '"+mirror+"' --dissociate") || strings.Contains(checkout.Command[2], "flock") {
+		t.Errorf("checkout script = %q, want it to borrow from %s without refreshing it", checkout.Command[2], mirror)
+	}
+	if m := checkout.VolumeMounts[1]; m.Name != "git-cache" || !m.ReadOnly {
+		t.Errorf("cache mount = %+v, want read-only", m)
+	}
+
+	l.GitCache = &model.GitCacheConfig{HostPath: "/var/cache/neutron"}
+	l.RunnerConfig.Checkout = &model.Checkout{Depth: 1}
+	checkout = l.checkoutContainer(cloneUrl)
+	script := checkout.Command[2]
+	if !strings.HasPrefix(script, mirrorScript(cloneUrl, 0)+" || true; ") || !strings.Contains(script, "echo '"+mirror+"'/objects > .git/objects/info/alternates") || !strings.Contains(script, "git repack -a -d -q") {
+		t.Errorf("shallow checkout script = %q, want it to refresh and borrow from %s", script, mirror)
+	}
+	if m := checkout.VolumeMounts[1]; m.ReadOnly {
+		t.Errorf("host-path cache mount = %+v, want writable", m)
+	}
+}
+
+func TestMirrorScript(t *testing.T) {
+	const cloneUrl = "git@gitlab.example.com:g/app.git"
+	mirror := "/cache/" + MirrorKey(cloneUrl) + ".git"
+	script := mirrorScript(cloneUrl, 0)
+	for _, want := range []string{
+		"git clone -q --mirror '" + cloneUrl + "' " + mirror + ".tmp && mv " + mirror + ".tmp " + mirror,
+		"git -C " + mirror + " fetch -q --prune",
+		"-mtime +14",
+		"9>/cache/" + MirrorKey(cloneUrl) + ".lock",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("mirror script %q does not contain %q", script, want)
+		}
+	}
+	if script := mirrorScript(cloneUrl, 3); !strings.Contains(script, "-mtime +3") {
+		t.Errorf("mirror script %q does not keep max-idle-days", script)
+	}
+}
+
+// TestMirrorJobPodTemplate verifies that the mirror refresh pod gets the pod
+// template, but not its pipeline container defaults, and obeys the pod policy.
+func TestMirrorJobPodTemplate(t *testing.T) {
+	privileged := true
+	l := &Launcher{
+		RunnerConfig: model.RunnerConfig{GitRepoUrl: "git@gitlab.example.com:g/app.git"},
+		GitCache:     &model.GitCacheConfig{Pvc: "git-cache"},
+		PodTemplate: &v1.PodTemplateSpec{Spec: v1.PodSpec{
+			NodeSelector: map[string]string{"pool": "ci"},
+			Containers:   []v1.Container{{Name: "pipeline", Env: []v1.EnvVar{{Name: "HTTP_PROXY", Value: "http://proxy:3128"}}}},
+		}},
+		Policy: model.PodPolicy{ForbidPrivileged: true, AllowedRegistries: []string{"registry.example.com/ci"}},
+	}
+	job, err := l.CreateMirrorJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := job.Spec.Template.Spec
+	if pod.NodeSelector["pool"] != "ci" || len(pod.Containers) != 1 || pod.Containers[0].Name != "mirror" {
+		t.Errorf("pod = %+v, want the template's node selector and the mirror container alone", pod)
+	}
+	for _, e := range pod.Containers[0].Env {
+		if e.Name == "HTTP_PROXY" {
+			t.Errorf("mirror container got the pipeline container's env")
+		}
+	}
+
+	l.PodTemplate.Spec.InitContainers = []v1.Container{{Name: "setup", SecurityContext: &v1.SecurityContext{Privileged: &privileged}}}
+	if _, err := l.CreateMirrorJob(); err == nil || !strings.Contains(err.Error(), "privileged") {
+		t.Errorf("CreateMirrorJob() error = %v, want a policy error", err)
+	}
+}
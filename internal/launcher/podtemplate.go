@@ -0,0 +1,181 @@
+package launcher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// LoadPodTemplate reads the PodTemplateSpec of kubernetes.pod-template, or
+// returns nil when path is empty. Unknown fields are an error, so a typo does
+// not silently drop a setting from every pod.
+func LoadPodTemplate(path string) (*v1.PodTemplateSpec, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read pod template: %w", err)
+	}
+	var tmpl v1.PodTemplateSpec
+	if err := yaml.UnmarshalStrict(data, &tmpl); err != nil {
+		return nil, fmt.Errorf("cannot parse pod template %s: %w", path, err)
+	}
+	return &tmpl, nil
+}
+
+// applyPodTemplate merges the admin's pod template into the generated pod. The
+// template's labels, annotations and pod-level settings are kept unless neutron
+// sets them; its init containers run before the checkout, and its containers
+// run next to the pipeline container as sidecars. A template container named
+// "pipeline" is not a sidecar: its security context, env, volume mounts and
+// resources are the pipeline container's defaults.
+func (l *Launcher) applyPodTemplate(pod *v1.PodTemplateSpec) {
+	if l.PodTemplate == nil {
+		return
+	}
+	tmpl := l.PodTemplate.DeepCopy()
+	pod.Labels = mergeStrings(tmpl.Labels, pod.Labels)
+	pod.Annotations = mergeStrings(tmpl.Annotations, pod.Annotations)
+
+	spec := tmpl.Spec
+	own := pod.Spec
+	var sidecars []v1.Container
+	for _, c := range spec.Containers {
+		if c.Name == "pipeline" {
+			if pipeline := pipelineContainer(&own); pipeline != nil {
+				applyContainerDefaults(pipeline, c)
+			}
+			continue
+		}
+		sidecars = append(sidecars, c)
+	}
+	spec.Containers = append(own.Containers, sidecars...)
+	spec.InitContainers = append(spec.InitContainers, own.InitContainers...)
+	spec.Volumes = append(spec.Volumes, own.Volumes...)
+	spec.ImagePullSecrets = append(spec.ImagePullSecrets, own.ImagePullSecrets...)
+	spec.RestartPolicy = own.RestartPolicy
+	pod.Spec = spec
+}
+
+// pipelineContainer returns the container running the job's steps, or nil
+// for a pod without one, such as a mirror refresh.
+func pipelineContainer(spec *v1.PodSpec) *v1.Container {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == "pipeline" {
+			return &spec.Containers[i]
+		}
+	}
+	return nil
+}
+
+// applyContainerDefaults fills c from the template's pipeline container. The
+// template's env comes first, so neutron's variables win on a name clash.
+func applyContainerDefaults(c *v1.Container, defaults v1.Container) {
+	if c.SecurityContext == nil {
+		c.SecurityContext = defaults.SecurityContext
+	}
+	c.Env = append(defaults.Env, c.Env...)
+	c.VolumeMounts = append(c.VolumeMounts, defaults.VolumeMounts...)
+	c.Resources.Limits = mergeResources(defaults.Resources.Limits, c.Resources.Limits)
+	c.Resources.Requests = mergeResources(defaults.Resources.Requests, c.Resources.Requests)
+}
+
+func mergeStrings(base, override map[string]string) map[string]string {
+	if len(base) == 0 {
+		return override
+	}
+	for k, v := range override {
+		base[k] = v
+	}
+	return base
+}
+
+func mergeResources(base, override v1.ResourceList) v1.ResourceList {
+	if len(base) == 0 {
+		return override
+	}
+	for name, q := range override {
+		base[name] = q
+	}
+	return base
+}
+
+// checkPolicy returns why the pod breaks the admin's policy, or nil. Resource
+// maxima apply to the pipeline container, whose image is the job's; a pod
+// without one only runs neutron's and the admin's images.
+func (l *Launcher) checkPolicy(spec *v1.PodSpec) error {
+	policy := l.Policy
+	if policy.ForbidPrivileged {
+		for _, c := range append(append([]v1.Container{}, spec.InitContainers...), spec.Containers...) {
+			if c.SecurityContext != nil && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
+				return fmt.Errorf("container %s is privileged, which is forbidden", c.Name)
+			}
+		}
+	}
+	pipeline := pipelineContainer(spec)
+	if pipeline == nil {
+		return nil
+	}
+	for _, rule := range []struct {
+		name v1.ResourceName
+		max  string
+	}{{v1.ResourceCPU, policy.MaxCpu}, {v1.ResourceMemory, policy.MaxMemory}} {
+		if rule.max == "" {
+			continue
+		}
+		max, err := resource.ParseQuantity(rule.max)
+		if err != nil {
+			return fmt.Errorf("invalid policy max %s %q: %w", rule.name, rule.max, err)
+		}
+		if q, ok := pipeline.Resources.Requests[rule.name]; ok && q.Cmp(max) > 0 {
+			return fmt.Errorf("%s request %s exceeds the maximum %s", rule.name, q.String(), rule.max)
+		}
+		if q, ok := pipeline.Resources.Limits[rule.name]; ok && q.Cmp(max) > 0 {
+			return fmt.Errorf("%s limit %s exceeds the maximum %s", rule.name, q.String(), rule.max)
+		}
+	}
+	if !registryAllowed(pipeline.Image, policy.AllowedRegistries) {
+		return fmt.Errorf("image %q is not from an allowed registry (%s)", pipeline.Image, strings.Join(policy.AllowedRegistries, ", "))
+	}
+	return nil
+}
+
+// registryAllowed reports whether image comes from one of the allowed
+// registries, each a host optionally followed by a path. Any image is allowed
+// when the list is empty.
+func registryAllowed(image string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	repo := imageRepository(image)
+	for _, a := range allowed {
+		a = strings.TrimSuffix(a, "/")
+		if repo == a || strings.HasPrefix(repo, a+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// imageRepository returns the registry and path of an image reference without
+// tag or digest, with Docker Hub's implicit registry and library/ spelled out:
+// "alpine:3" is docker.io/library/alpine.
+func imageRepository(image string) string {
+	name, _, _ := strings.Cut(image, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	first, _, found := strings.Cut(name, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return name
+	}
+	if !found {
+		name = "library/" + name
+	}
+	return "docker.io/" + name
+}
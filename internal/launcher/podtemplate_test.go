@@ -0,0 +1,98 @@
+package launcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"neutron/internal/model"
+)
+
+func TestApplyPodTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pod.yaml")
+	if err := os.WriteFile(path, []byte(`
+metadata:
+  labels: {team: ci}
+spec:
+  securityContext: {runAsNonRoot: true}
+  restartPolicy: OnFailure
+  containers:
+    - name: pipeline
+      env: [{name: HTTP_PROXY, value: "http://proxy:3128"}]
+      resources:
+        limits: {cpu: "2", memory: 2Gi}
+    - name: proxy
+      image: registry.example.com/proxy:1
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	podTemplate, err := LoadPodTemplate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &Launcher{PodTemplate: podTemplate}
+	pod := v1.PodTemplateSpec{Spec: v1.PodSpec{
+		Containers: []v1.Container{{
+			Name:      "pipeline",
+			Env:       []v1.EnvVar{{Name: "JOB_NAME", Value: "build"}},
+			Resources: v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")}},
+		}},
+		InitContainers: []v1.Container{{Name: "checkout"}},
+		RestartPolicy:  v1.RestartPolicyNever,
+	}}
+	l.applyPodTemplate(&pod)
+	if pod.Labels["team"] != "ci" || pod.Spec.SecurityContext == nil || pod.Spec.RestartPolicy != v1.RestartPolicyNever {
+		t.Errorf("pod = %+v, want the template's labels and security context with neutron's restart policy", pod)
+	}
+	if len(pod.Spec.Containers) != 2 || pod.Spec.Containers[1].Name != "proxy" {
+		t.Errorf("containers = %+v, want the pipeline container and the proxy sidecar", pod.Spec.Containers)
+	}
+	pipeline := pod.Spec.Containers[0]
+	if len(pipeline.Env) != 2 || pipeline.Env[0].Name != "HTTP_PROXY" || pipeline.Env[1].Name != "JOB_NAME" {
+		t.Errorf("pipeline env = %+v, want the template's first", pipeline.Env)
+	}
+	if limits := pipeline.Resources.Limits; limits.Cpu().String() != "2" || limits.Memory().String() != "1Gi" {
+		t.Errorf("limits = %v, want the template's cpu and the job's memory", limits)
+	}
+
+	if err := os.WriteFile(path, []byte("spec: {containerz: []}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPodTemplate(path); err == nil {
+		t.Error("pod template with an unknown field loaded")
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	l := &Launcher{Policy: model.PodPolicy{
+		MaxCpu:            "4",
+		ForbidPrivileged:  true,
+		AllowedRegistries: []string{"registry.example.com/ci", "docker.io/library"},
+	}}
+	pod := func(image string, resources v1.ResourceRequirements) *v1.PodSpec {
+		return &v1.PodSpec{Containers: []v1.Container{{Name: "pipeline", Image: image, Resources: resources}}}
+	}
+	for _, image := range []string{"alpine:3", "registry.example.com/ci/tool:1", "registry.example.com/ci/tool@sha256:abc"} {
+		if err := l.checkPolicy(pod(image, v1.ResourceRequirements{})); err != nil {
+			t.Errorf("image %s: %v", image, err)
+		}
+	}
+	privileged := true
+	sidecar := pod("alpine:3", v1.ResourceRequirements{})
+	sidecar.Containers = append(sidecar.Containers, v1.Container{Name: "dind", SecurityContext: &v1.SecurityContext{Privileged: &privileged}})
+	for name, spec := range map[string]*v1.PodSpec{
+		"cpu limit":          pod("alpine:3", v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}}),
+		"cpu request":        pod("alpine:3", v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("5")}}),
+		"other registry":     pod("quay.io/app:1", v1.ResourceRequirements{}),
+		"registry path":      pod("registry.example.com/cid/tool:1", v1.ResourceRequirements{}),
+		"docker hub user":    pod("someone/tool:1", v1.ResourceRequirements{}),
+		"privileged sidecar": sidecar,
+	} {
+		if err := l.checkPolicy(spec); err == nil {
+			t.Errorf("%s: want a policy error", name)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package launcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	v1 "k8s.io/api/core/v1"
+
+	"neutron/internal/model"
+)
+
+// ValidateScheduling checks a job's scheduling against the admin allow-list,
+// so a job cannot land its pod on reserved nodes or borrow a privileged
+// service account. A nil s is valid.
+func ValidateScheduling(s *model.Scheduling, policy model.SchedulingConfig) error {
+	if s == nil {
+		return nil
+	}
+	for key, value := range s.NodeSelector {
+		if !nodeLabelAllowed(policy, key, value) {
+			return fmt.Errorf("node_selector %s=%s is not allowed", key, value)
+		}
+	}
+	for _, t := range s.Tolerations {
+		if err := validateToleration(t, policy); err != nil {
+			return err
+		}
+	}
+	affinity, err := podAffinity(s.Affinity)
+	if err != nil {
+		return err
+	}
+	if affinity != nil {
+		if (affinity.PodAffinity != nil || affinity.PodAntiAffinity != nil) && !policy.PodAffinity {
+			return fmt.Errorf("pod affinity is not allowed")
+		}
+		if err := validateNodeAffinity(affinity.NodeAffinity, policy); err != nil {
+			return err
+		}
+	}
+	if s.PriorityClass != "" && !slices.Contains(policy.PriorityClasses, s.PriorityClass) {
+		return fmt.Errorf("priority_class %q is not allowed", s.PriorityClass)
+	}
+	if s.RuntimeClass != "" && !slices.Contains(policy.RuntimeClasses, s.RuntimeClass) {
+		return fmt.Errorf("runtime_class %q is not allowed", s.RuntimeClass)
+	}
+	if s.ServiceAccount != "" && !slices.Contains(policy.ServiceAccounts, s.ServiceAccount) {
+		return fmt.Errorf("service_account %q is not allowed", s.ServiceAccount)
+	}
+	return nil
+}
+
+// nodeLabelAllowed reports whether jobs may select nodes by key=value.
+func nodeLabelAllowed(policy model.SchedulingConfig, key, value string) bool {
+	values, ok := policy.NodeLabels[key]
+	return ok && (slices.Contains(values, "*") || slices.Contains(values, value))
+}
+
+func validateToleration(t model.Toleration, policy model.SchedulingConfig) error {
+	// an empty key with Exists would tolerate every taint
+	if t.Key == "" {
+		return fmt.Errorf("tolerations need a key")
+	}
+	if !slices.Contains(policy.TolerationKeys, t.Key) {
+		return fmt.Errorf("toleration of %q is not allowed", t.Key)
+	}
+	switch t.Operator {
+	case "", "Equal":
+	case "Exists":
+		if t.Value != "" {
+			return fmt.Errorf("toleration of %q: operator Exists takes no value", t.Key)
+		}
+	default:
+		return fmt.Errorf("toleration of %q: operator must be Equal or Exists, got %q", t.Key, t.Operator)
+	}
+	switch t.Effect {
+	case "", "NoSchedule", "PreferNoSchedule", "NoExecute":
+	default:
+		return fmt.Errorf("toleration of %q: effect must be NoSchedule, PreferNoSchedule or NoExecute, got %q", t.Key, t.Effect)
+	}
+	if t.TolerationSeconds != nil && t.Effect != "NoExecute" {
+		return fmt.Errorf("toleration of %q: toleration_seconds needs effect NoExecute", t.Key)
+	}
+	return nil
+}
+
+func validateNodeAffinity(na *v1.NodeAffinity, policy model.SchedulingConfig) error {
+	if na == nil {
+		return nil
+	}
+	var terms []v1.NodeSelectorTerm
+	if na.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		terms = append(terms, na.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms...)
+	}
+	for _, p := range na.PreferredDuringSchedulingIgnoredDuringExecution {
+		terms = append(terms, p.Preference)
+	}
+	for _, term := range terms {
+		if len(term.MatchFields) > 0 {
+			return fmt.Errorf("node affinity matchFields is not allowed")
+		}
+		for _, expr := range term.MatchExpressions {
+			if _, ok := policy.NodeLabels[expr.Key]; !ok {
+				return fmt.Errorf("node affinity on label %q is not allowed", expr.Key)
+			}
+			switch expr.Operator {
+			case v1.NodeSelectorOpIn, v1.NodeSelectorOpNotIn, v1.NodeSelectorOpGt, v1.NodeSelectorOpLt:
+				if len(expr.Values) == 0 {
+					return fmt.Errorf("node affinity on label %q: operator %s needs values", expr.Key, expr.Operator)
+				}
+			case v1.NodeSelectorOpExists, v1.NodeSelectorOpDoesNotExist:
+				if len(expr.Values) > 0 {
+					return fmt.Errorf("node affinity on label %q: operator %s takes no values", expr.Key, expr.Operator)
+				}
+			default:
+				return fmt.Errorf("node affinity on label %q: unsupported operator %q", expr.Key, expr.Operator)
+			}
+			for _, value := range expr.Values {
+				if !nodeLabelAllowed(policy, expr.Key, value) {
+					return fmt.Errorf("node affinity %s=%s is not allowed", expr.Key, value)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// podAffinity decodes the affinity of neutron.yaml, written as in a pod spec,
+// into a K8s Affinity. Unknown fields are an error rather than silently
+// ignored constraints.
+func podAffinity(affinity map[string]any) (*v1.Affinity, error) {
+	if len(affinity) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(affinity)
+	if err != nil {
+		return nil, fmt.Errorf("invalid affinity: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var a v1.Affinity
+	if err := dec.Decode(&a); err != nil {
+		return nil, fmt.Errorf("invalid affinity: %w", err)
+	}
+	return &a, nil
+}
+
+// applyScheduling sets the job's scheduling, already checked by
+// ValidateScheduling, on the pod spec. It narrows the pod template's rather
+// than replacing it: node selectors, tolerations and affinity terms add to the
+// template's, and a node label, class or service account the template sets to
+// another value is an error.
+func (l *Launcher) applyScheduling(spec *v1.PodSpec) error {
+	s := l.Scheduling
+	if s == nil {
+		return nil
+	}
+	for key, value := range s.NodeSelector {
+		if own, ok := spec.NodeSelector[key]; ok && own != value {
+			return fmt.Errorf("node_selector %s=%s: the pod template selects %s=%s", key, value, key, own)
+		}
+	}
+	spec.NodeSelector = mergeStrings(spec.NodeSelector, s.NodeSelector)
+	for _, t := range s.Tolerations {
+		spec.Tolerations = append(spec.Tolerations, v1.Toleration{
+			Key:               t.Key,
+			Operator:          v1.TolerationOperator(t.Operator),
+			Value:             t.Value,
+			Effect:            v1.TaintEffect(t.Effect),
+			TolerationSeconds: t.TolerationSeconds,
+		})
+	}
+	if affinity, _ := podAffinity(s.Affinity); affinity != nil {
+		spec.Affinity = mergeAffinity(spec.Affinity, affinity)
+	}
+	if err := setUnlessTemplate("priority_class", &spec.PriorityClassName, s.PriorityClass); err != nil {
+		return err
+	}
+	if s.RuntimeClass != "" {
+		runtimeClass := ""
+		if spec.RuntimeClassName != nil {
+			runtimeClass = *spec.RuntimeClassName
+		}
+		if err := setUnlessTemplate("runtime_class", &runtimeClass, s.RuntimeClass); err != nil {
+			return err
+		}
+		spec.RuntimeClassName = &runtimeClass
+	}
+	return setUnlessTemplate("service_account", &spec.ServiceAccountName, s.ServiceAccount)
+}
+
+// setUnlessTemplate sets a pod field to the job's value, unless the pod
+// template already set it to another one.
+func setUnlessTemplate(name string, field *string, value string) error {
+	if value == "" || *field == value {
+		return nil
+	}
+	if *field != "" {
+		return fmt.Errorf("%s %q: the pod template sets %q", name, value, *field)
+	}
+	*field = value
+	return nil
+}
+
+// mergeAffinity adds a job's affinity to the pod template's. Pod affinity and
+// preferred node affinity terms all apply, so they are appended; required
+// node selector terms are alternatives, so each of the template's is combined
+// with each of the job's for the pod to satisfy both.
+func mergeAffinity(base, job *v1.Affinity) *v1.Affinity {
+	if base == nil {
+		return job
+	}
+	merged := base.DeepCopy()
+	if na := job.NodeAffinity; na != nil {
+		if merged.NodeAffinity == nil {
+			merged.NodeAffinity = &v1.NodeAffinity{}
+		}
+		m := merged.NodeAffinity
+		m.RequiredDuringSchedulingIgnoredDuringExecution = mergeNodeSelector(m.RequiredDuringSchedulingIgnoredDuringExecution, na.RequiredDuringSchedulingIgnoredDuringExecution)
+		m.PreferredDuringSchedulingIgnoredDuringExecution = append(m.PreferredDuringSchedulingIgnoredDuringExecution, na.PreferredDuringSchedulingIgnoredDuringExecution...)
+	}
+	if pa := job.PodAffinity; pa != nil {
+		if merged.PodAffinity == nil {
+			merged.PodAffinity = &v1.PodAffinity{}
+		}
+		m := merged.PodAffinity
+		m.RequiredDuringSchedulingIgnoredDuringExecution = append(m.RequiredDuringSchedulingIgnoredDuringExecution, pa.RequiredDuringSchedulingIgnoredDuringExecution...)
+		m.PreferredDuringSchedulingIgnoredDuringExecution = append(m.PreferredDuringSchedulingIgnoredDuringExecution, pa.PreferredDuringSchedulingIgnoredDuringExecution...)
+	}
+	if pa := job.PodAntiAffinity; pa != nil {
+		if merged.PodAntiAffinity == nil {
+			merged.PodAntiAffinity = &v1.PodAntiAffinity{}
+		}
+		m := merged.PodAntiAffinity
+		m.RequiredDuringSchedulingIgnoredDuringExecution = append(m.RequiredDuringSchedulingIgnoredDuringExecution, pa.RequiredDuringSchedulingIgnoredDuringExecution...)
+		m.PreferredDuringSchedulingIgnoredDuringExecution = append(m.PreferredDuringSchedulingIgnoredDuringExecution, pa.PreferredDuringSchedulingIgnoredDuringExecution...)
+	}
+	return merged
+}
+
+func mergeNodeSelector(base, job *v1.NodeSelector) *v1.NodeSelector {
+	if base == nil || len(base.NodeSelectorTerms) == 0 {
+		return job
+	}
+	if job == nil || len(job.NodeSelectorTerms) == 0 {
+		return base
+	}
+	merged := &v1.NodeSelector{}
+	for _, b := range base.NodeSelectorTerms {
+		for _, j := range job.NodeSelectorTerms {
+			merged.NodeSelectorTerms = append(merged.NodeSelectorTerms, v1.NodeSelectorTerm{
+				MatchExpressions: append(slices.Clone(b.MatchExpressions), j.MatchExpressions...),
+				MatchFields:      append(slices.Clone(b.MatchFields), j.MatchFields...),
+			})
+		}
+	}
+	return merged
+}
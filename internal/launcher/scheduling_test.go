@@ -0,0 +1,119 @@
+package launcher
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"neutron/internal/model"
+)
+
+// TestApplySchedulingKeepsPodTemplate verifies that a job's scheduling narrows
+// the pod template's: required node affinity has to match both, other terms
+// add up, and a setting the template pins cannot be changed.
+func TestApplySchedulingKeepsPodTemplate(t *testing.T) {
+	template := func() *v1.PodSpec {
+		return &v1.PodSpec{
+			NodeSelector:       map[string]string{"pool": "ci"},
+			ServiceAccountName: "ci-runner",
+			Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+					{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "arch", Operator: v1.NodeSelectorOpIn, Values: []string{"amd64"}}}},
+				}},
+			}},
+		}
+	}
+	l := &Launcher{Scheduling: &model.Scheduling{
+		NodeSelector: map[string]string{"accelerator": "nvidia"},
+		Affinity: map[string]any{
+			"nodeAffinity": map[string]any{"requiredDuringSchedulingIgnoredDuringExecution": map[string]any{"nodeSelectorTerms": []any{
+				map[string]any{"matchExpressions": []any{map[string]any{"key": "zone", "operator": "In", "values": []any{"a"}}}},
+				map[string]any{"matchExpressions": []any{map[string]any{"key": "zone", "operator": "In", "values": []any{"b"}}}},
+			}}},
+			"podAntiAffinity": map[string]any{"preferredDuringSchedulingIgnoredDuringExecution": []any{
+				map[string]any{"weight": 1, "podAffinityTerm": map[string]any{"topologyKey": "kubernetes.io/hostname"}},
+			}},
+		},
+		ServiceAccount: "ci-runner",
+	}}
+	spec := template()
+	if err := l.applyScheduling(spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.NodeSelector["pool"] != "ci" || spec.NodeSelector["accelerator"] != "nvidia" || spec.ServiceAccountName != "ci-runner" {
+		t.Errorf("node selector = %v, service account = %q", spec.NodeSelector, spec.ServiceAccountName)
+	}
+	terms := spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 2 {
+		t.Fatalf("required node selector terms = %+v, want the template's combined with each of the job's", terms)
+	}
+	for i, zone := range []string{"a", "b"} {
+		exprs := terms[i].MatchExpressions
+		if len(exprs) != 2 || exprs[0].Key != "arch" || exprs[1].Key != "zone" || exprs[1].Values[0] != zone {
+			t.Errorf("term %d = %+v, want arch and zone %s", i, exprs, zone)
+		}
+	}
+	if anti := spec.Affinity.PodAntiAffinity; anti == nil || len(anti.PreferredDuringSchedulingIgnoredDuringExecution) != 1 {
+		t.Errorf("pod anti-affinity = %+v, want the job's", anti)
+	}
+
+	for name, s := range map[string]model.Scheduling{
+		"node_selector":   {NodeSelector: map[string]string{"pool": "gpu"}},
+		"service_account": {ServiceAccount: "deployer"},
+	} {
+		l.Scheduling = &s
+		if err := l.applyScheduling(template()); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s overriding the pod template: error %v", name, err)
+		}
+	}
+}
+
+func TestValidateScheduling(t *testing.T) {
+	policy := model.SchedulingConfig{
+		NodeLabels:      map[string][]string{"accelerator": {"nvidia"}, "zone": {"*"}},
+		TolerationKeys:  []string{"gpu"},
+		RuntimeClasses:  []string{"nvidia"},
+		PriorityClasses: []string{"ci-high"},
+	}
+	ok := &model.Scheduling{
+		NodeSelector: map[string]string{"accelerator": "nvidia", "zone": "any"},
+		Tolerations:  []model.Toleration{{Key: "gpu", Operator: "Exists", Effect: "NoSchedule"}},
+		Affinity: map[string]any{"nodeAffinity": map[string]any{"preferredDuringSchedulingIgnoredDuringExecution": []any{
+			map[string]any{"weight": 1, "preference": map[string]any{"matchExpressions": []any{map[string]any{"key": "zone", "operator": "In", "values": []any{"a"}}}}},
+		}}},
+		PriorityClass: "ci-high",
+		RuntimeClass:  "nvidia",
+	}
+	if err := ValidateScheduling(ok, policy); err != nil {
+		t.Errorf("allowed scheduling: %v", err)
+	}
+	if err := ValidateScheduling(nil, model.SchedulingConfig{}); err != nil {
+		t.Errorf("no scheduling: %v", err)
+	}
+
+	seconds := int64(60)
+	for _, bad := range []model.Scheduling{
+		{NodeSelector: map[string]string{"accelerator": "amd"}},
+		{NodeSelector: map[string]string{"pool": "reserved"}},
+		{Tolerations: []model.Toleration{{Operator: "Exists"}}},
+		{Tolerations: []model.Toleration{{Key: "dedicated", Operator: "Exists"}}},
+		{Tolerations: []model.Toleration{{Key: "gpu", Operator: "Exists", Value: "yes"}}},
+		{Tolerations: []model.Toleration{{Key: "gpu", TolerationSeconds: &seconds}}},
+		{Affinity: map[string]any{"podAntiAffinity": map[string]any{}}},
+		{Affinity: map[string]any{"nodeAffinity": map[string]any{"requiredDuringScheduling": map[string]any{}}}},
+		{Affinity: map[string]any{"nodeAffinity": map[string]any{"requiredDuringSchedulingIgnoredDuringExecution": map[string]any{"nodeSelectorTerms": []any{
+			map[string]any{"matchFields": []any{map[string]any{"key": "metadata.name", "operator": "In", "values": []any{"node-1"}}}},
+		}}}}},
+		{Affinity: map[string]any{"nodeAffinity": map[string]any{"requiredDuringSchedulingIgnoredDuringExecution": map[string]any{"nodeSelectorTerms": []any{
+			map[string]any{"matchExpressions": []any{map[string]any{"key": "accelerator", "operator": "In", "values": []any{"amd"}}}},
+		}}}}},
+		{PriorityClass: "system-cluster-critical"},
+		{RuntimeClass: "runc"},
+		{ServiceAccount: "deployer"},
+	} {
+		if err := ValidateScheduling(&bad, policy); err == nil {
+			t.Errorf("scheduling %+v: want an error", bad)
+		}
+	}
+}
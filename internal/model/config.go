@@ -1,15 +1,44 @@
 package model
 
+import "strings"
+
 type Config struct {
 	Host       string              `yaml:"host"`
 	Port       int                 `yaml:"port"`
 	Database   string              `yaml:"database"`
+	Salt       string              `yaml:"salt"`              // 加密项目 token 的密钥材料；修改后已存的 token 无法解密
 	LogUrl     string              `yaml:"log_url,omitempty"` // 日志平台链接模板，支持 {namespace} 和 {podId} 占位符
-	BaseConfig map[string]CodeBase `yaml:"codebase"`
-	// PodCodeBase 覆盖 K8s Pod 内 runner 访问 codebase 的地址（当 Pod 网络与宿主机不同时使用）
+	BaseConfig map[string]CodeBase `yaml:"codebase"`          // 按实例 id；type 为空时 id 即平台名
+	// PodCodeBase 覆盖 K8s Pod 内 runner 访问 codebase 的地址（当 Pod 网络与宿主机不同时使用），按实例 id
 	PodCodeBase map[string]CodeBase `yaml:"pod_codebase,omitempty"`
 	Kubernetes  KubernetesConfig    `yaml:"kubernetes"`
 	Notify      NotifyConfig        `yaml:"notify,omitempty"`
+	Queue       QueueConfig         `yaml:"queue,omitempty"`
+	Snippets    SnippetsConfig      `yaml:"snippets,omitempty"`
+	Artifacts   ArtifactsConfig     `yaml:"artifacts,omitempty"`
+}
+
+// ArtifactsConfig is where the API server keeps the files runners upload with
+// upload-artifact, one directory per K8s Job.
+type ArtifactsConfig struct {
+	Dir     string `yaml:"dir,omitempty"`      // default ./artifacts
+	MaxSize int    `yaml:"max_size,omitempty"` // per file, in MB; default 100
+}
+
+// SnippetsConfig makes the snippet library a read-only mirror of a directory
+// of a registered project: pushes to SyncBranch re-import SyncDir, and the
+// snippet write API is disabled. Empty SyncProject keeps the library editable.
+type SnippetsConfig struct {
+	SyncProject string `yaml:"sync_project,omitempty"` // registered project id
+	SyncBranch  string `yaml:"sync_branch,omitempty"`  // default main
+	SyncDir     string `yaml:"sync_dir,omitempty"`     // default snippets
+}
+
+// QueueConfig caps how many pipeline K8s Jobs run at once; jobs over a limit
+// wait in the persisted queue. Zero means unlimited.
+type QueueConfig struct {
+	MaxJobs           int `yaml:"max_jobs,omitempty"`             // across all projects
+	MaxJobsPerProject int `yaml:"max_jobs_per_project,omitempty"` // per registered project
 }
 
 type NotifyConfig struct {
@@ -20,18 +49,114 @@ type NotifyConfig struct {
 }
 
 type KubernetesConfig struct {
-	KubeConfig       string   `yaml:"kube-config"`
-	Namespace        string   `yaml:"namespace"`
-	GitPrivateKey    string   `yaml:"git-private-key"`
-	InitImage        string   `yaml:"init-image"`
-	CheckoutImage    string   `yaml:"checkout-image"`      // dedicated image for git checkout (must include git + ssh)
-	ImagePullSecrets []string `yaml:"image-pull-secrets,omitempty"` // K8s image pull secret names
-	PodApiUrl        string   `yaml:"pod-api-url,omitempty"`        // Pod 内访问 API server 的地址（本地开发用，覆盖集群内地址）
+	KubeConfig       string           `yaml:"kube-config"`
+	Namespace        string           `yaml:"namespace"`
+	GitPrivateKey    string           `yaml:"git-private-key"`
+	InitImage        string           `yaml:"init-image"`
+	CheckoutImage    string           `yaml:"checkout-image"`               // dedicated image for git checkout (must include git + ssh)
+	ImagePullSecrets []string         `yaml:"image-pull-secrets,omitempty"` // K8s image pull secret names
+	PodApiUrl        string           `yaml:"pod-api-url,omitempty"`        // Pod 内访问 API server 的地址（本地开发用，覆盖集群内地址）
+	KnownHostsSecret string           `yaml:"known-hosts-secret,omitempty"` // Secret whose known_hosts pins SSH host keys; not checked when empty
+	GitCache         *GitCacheConfig  `yaml:"git-cache,omitempty"`          // bare mirrors checkouts borrow objects from; none when absent
+	Scheduling       SchedulingConfig `yaml:"scheduling,omitempty"`         // what neutron.yaml may set of a pod's scheduling
+	PodTemplate      string           `yaml:"pod-template,omitempty"`       // YAML file with the PodTemplateSpec every pipeline pod starts from
+	Policy           PodPolicy        `yaml:"policy,omitempty"`             // rules every pipeline pod must follow
 }
 
+// PodPolicy is checked on every pipeline pod, after the pod template is merged
+// in. A job breaking it is rejected before its K8s Job is created.
+type PodPolicy struct {
+	MaxCpu            string   `yaml:"max-cpu,omitempty"`            // highest CPU request or limit of the pipeline container
+	MaxMemory         string   `yaml:"max-memory,omitempty"`         // highest memory request or limit of the pipeline container
+	ForbidPrivileged  bool     `yaml:"forbid-privileged,omitempty"`  // reject privileged containers
+	AllowedRegistries []string `yaml:"allowed-registries,omitempty"` // registries, optionally with a path, job images must come from; any when empty
+}
+
+// SchedulingConfig is the allow-list of pod scheduling settings jobs may ask
+// for in neutron.yaml. Anything not listed is rejected, so without it jobs can
+// set none.
+type SchedulingConfig struct {
+	NodeLabels      map[string][]string `yaml:"node-labels,omitempty"`      // node label → allowed values ("*" for any), for node_selector and node affinity
+	TolerationKeys  []string            `yaml:"toleration-keys,omitempty"`  // taint keys jobs may tolerate
+	PodAffinity     bool                `yaml:"pod-affinity,omitempty"`     // allow pod affinity and anti-affinity
+	PriorityClasses []string            `yaml:"priority-classes,omitempty"` // PriorityClass names
+	RuntimeClasses  []string            `yaml:"runtime-classes,omitempty"`  // RuntimeClass names
+	ServiceAccounts []string            `yaml:"service-accounts,omitempty"` // ServiceAccount names in the job's namespace
+}
+
+// GitCacheConfig keeps a bare mirror of each repository that checkouts borrow
+// objects from, so only what the mirror lacks comes over the network. Set
+// either Pvc or HostPath.
+type GitCacheConfig struct {
+	Pvc         string `yaml:"pvc,omitempty"`           // shared ReadWriteMany PVC, refreshed by a K8s Job on each webhook
+	HostPath    string `yaml:"host-path,omitempty"`     // per-node directory, refreshed by the checkouts running on the node
+	MaxIdleDays int    `yaml:"max-idle-days,omitempty"` // mirrors unused for this many days are deleted; default 14
+}
+
+// CodeBase is one instance of a code hosting platform. Several instances of
+// the same platform are told apart by their id, the key in Config.BaseConfig.
 type CodeBase struct {
-	Url            string `yaml:"url"`
-	Token          string `yaml:"token"`
-	SkipTLSVerify  bool   `yaml:"skip_tls_verify,omitempty"`
-	WebhookUrl     string `yaml:"webhook_url,omitempty"` // 外部可访问的 webhook URL（覆盖 config.Host）
+	Type          string    `yaml:"type,omitempty"` // platform name; the instance id when empty
+	Url           string    `yaml:"url"`
+	Token         string    `yaml:"token"`
+	SkipTLSVerify bool      `yaml:"skip_tls_verify,omitempty"`
+	WebhookUrl    string    `yaml:"webhook_url,omitempty"`    // 外部可访问的 webhook URL（覆盖 config.Host）
+	WebhookSecret string    `yaml:"webhook_secret,omitempty"` // verifies webhooks (signature on GitHub/Gitea, X-Gitlab-Token/X-Codeup-Token); set on installed hooks
+	ReportTo      []string  `yaml:"report_to,omitempty"`      // other instances whose credentials this instance's jobs get when their RUNNER_REPORTERS name them
+	Pod           *CodeBase `yaml:"pod,omitempty"`            // runner pods' view of the instance (url, token, skip_tls_verify)
+}
+
+// Codebase returns the codebase instance id with its Type filled in.
+func (c Config) Codebase(id string) (CodeBase, bool) {
+	cb, ok := c.BaseConfig[id]
+	if !ok {
+		return CodeBase{}, false
+	}
+	if cb.Type == "" {
+		cb.Type = id
+	}
+	return cb, true
+}
+
+// DefaultCodebase returns the id of the instance used for a platform when no
+// instance is named (projects registered before instances existed): the
+// instance keyed by the platform name, else the only instance of that type.
+func (c Config) DefaultCodebase(platformName string) (string, bool) {
+	if cb, ok := c.Codebase(platformName); ok && strings.EqualFold(cb.Type, platformName) {
+		return platformName, true
+	}
+	found := ""
+	for id := range c.BaseConfig {
+		if cb, _ := c.Codebase(id); strings.EqualFold(cb.Type, platformName) {
+			if found != "" {
+				return "", false
+			}
+			found = id
+		}
+	}
+	return found, found != ""
+}
+
+// PodCodebase returns instance id as runner pods reach it: its Pod block, or
+// the pod_codebase entry of the same id, replaces the URL, token and TLS
+// setting.
+func (c Config) PodCodebase(id string) CodeBase {
+	cb, _ := c.Codebase(id)
+	pod := cb.Pod
+	if pod == nil {
+		if legacy, ok := c.PodCodeBase[id]; ok {
+			pod = &legacy
+		}
+	}
+	if pod == nil {
+		return cb
+	}
+	if pod.Url != "" {
+		cb.Url = pod.Url
+	}
+	if pod.Token != "" {
+		cb.Token = pod.Token
+	}
+	cb.SkipTLSVerify = pod.SkipTLSVerify
+	return cb
 }
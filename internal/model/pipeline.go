@@ -1,15 +1,140 @@
 package model
 
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
 type Pipeline struct {
-	Jobs map[string]Job `yaml:"jobs"`
+	Include   []Include      `yaml:"include,omitempty"`   // files merged into this one; resolved by parser.Resolve
+	Checkout  *Checkout      `yaml:"checkout,omitempty"`  // default checkout of jobs that set none
+	Templates map[string]Job `yaml:"templates,omitempty"` // jobs that never run themselves, only extended
+	Jobs      map[string]Job `yaml:"jobs"`
+}
+
+// Include pulls templates and jobs from another file: Local names a file of
+// the same repository at the same commit, Project/File a file of another
+// registered project at the pinned Ref.
+type Include struct {
+	Local   string `yaml:"local,omitempty"`
+	Project string `yaml:"project,omitempty"` // registered project id or repo URL
+	Ref     string `yaml:"ref,omitempty"`
+	File    string `yaml:"file,omitempty"`
 }
 
 type Job struct {
-	Image     string     `yaml:"image"`
-	Trigger   []string   `yaml:"trigger"`
-	Steps     []Step     `yaml:"steps"`
-	Resources *Resources `yaml:"resources,omitempty"`
-	Notify    *Notify    `yaml:"notify,omitempty"`
+	Image       string       `yaml:"image"`
+	Trigger     []string     `yaml:"trigger"`
+	Steps       []Step       `yaml:"steps"`
+	Resources   *Resources   `yaml:"resources,omitempty"`
+	Notify      *Notify      `yaml:"notify,omitempty"`
+	When        string       `yaml:"when,omitempty"`        // "manual" holds the job until approved; empty runs immediately
+	Approvers   []string     `yaml:"approvers,omitempty"`   // user ids allowed to approve a manual job; empty allows anyone
+	Environment *Environment `yaml:"environment,omitempty"` // deployment target; successful runs are recorded per environment
+	Concurrency *Concurrency `yaml:"concurrency,omitempty"` // serialises jobs of the same project sharing a group
+	Checkout    *Checkout    `yaml:"checkout,omitempty"`    // how the repository is cloned; a full clone when absent
+	Extends     string       `yaml:"extends,omitempty"`     // template whose image, resources, steps, notify, checkout and scheduling fill in unset fields
+
+	// node_selector, tolerations, affinity, priority_class, runtime_class and
+	// service_account of the job's pod
+	Scheduling `yaml:",inline"`
+}
+
+// Scheduling places a job's pod on suitable nodes. Every value must be allowed
+// by kubernetes.scheduling in the API server's config.
+type Scheduling struct {
+	NodeSelector   map[string]string `yaml:"node_selector,omitempty" json:"node_selector,omitempty"`
+	Tolerations    []Toleration      `yaml:"tolerations,omitempty" json:"tolerations,omitempty"`
+	Affinity       map[string]any    `yaml:"affinity,omitempty" json:"affinity,omitempty"` // a K8s Affinity, as in a pod spec
+	PriorityClass  string            `yaml:"priority_class,omitempty" json:"priority_class,omitempty"`
+	RuntimeClass   string            `yaml:"runtime_class,omitempty" json:"runtime_class,omitempty"`
+	ServiceAccount string            `yaml:"service_account,omitempty" json:"service_account,omitempty"`
+}
+
+// IsZero reports whether s sets nothing.
+func (s Scheduling) IsZero() bool {
+	return len(s.NodeSelector) == 0 && len(s.Tolerations) == 0 && len(s.Affinity) == 0 &&
+		s.PriorityClass == "" && s.RuntimeClass == "" && s.ServiceAccount == ""
+}
+
+// Toleration lets a job's pod run on nodes with a matching taint.
+type Toleration struct {
+	Key               string `yaml:"key" json:"key"`
+	Operator          string `yaml:"operator,omitempty" json:"operator,omitempty"` // Equal (default) or Exists
+	Value             string `yaml:"value,omitempty" json:"value,omitempty"`
+	Effect            string `yaml:"effect,omitempty" json:"effect,omitempty"` // NoSchedule, PreferNoSchedule or NoExecute; all when empty
+	TolerationSeconds *int64 `yaml:"toleration_seconds,omitempty" json:"toleration_seconds,omitempty"`
+}
+
+// Checkout tunes the clone of the checkout init container for large
+// repositories. The zero value is a full clone of the whole history.
+type Checkout struct {
+	Depth      int      `yaml:"depth,omitempty" json:"depth,omitempty"`           // commits of history to fetch; 0 fetches all
+	Filter     string   `yaml:"filter,omitempty" json:"filter,omitempty"`         // partial clone filter: blob:none, tree:0 or blob:limit=<size>
+	Sparse     []string `yaml:"sparse,omitempty" json:"sparse,omitempty"`         // directories to check out (cone mode); all when empty
+	Submodules string   `yaml:"submodules,omitempty" json:"submodules,omitempty"` // "true" or "recursive"; none when empty or "false"
+	Lfs        bool     `yaml:"lfs,omitempty" json:"lfs,omitempty"`               // fetch Git LFS objects of the checked out files
+}
+
+var checkoutFilter = regexp.MustCompile(`^(blob:none|tree:0|blob:limit=[0-9]+[kmg]?)$`)
+
+// Validate checks the options that would otherwise only fail in the pod.
+func (c *Checkout) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Depth < 0 {
+		return fmt.Errorf("checkout depth must not be negative, got %d", c.Depth)
+	}
+	if c.Filter != "" && !checkoutFilter.MatchString(c.Filter) {
+		return fmt.Errorf("unsupported checkout filter %q (supported: blob:none, tree:0, blob:limit=<size>)", c.Filter)
+	}
+	for _, dir := range c.Sparse {
+		if dir == "" || strings.HasPrefix(dir, "-") || strings.HasPrefix(dir, "/") || slices.Contains(strings.Split(dir, "/"), "..") {
+			return fmt.Errorf("invalid checkout sparse directory %q", dir)
+		}
+	}
+	switch c.Submodules {
+	case "", "false", "true", "recursive":
+	default:
+		return fmt.Errorf("checkout submodules must be true, false or recursive, got %q", c.Submodules)
+	}
+	return nil
+}
+
+// Concurrency limits a project to one running job per group. A new job in a
+// busy group waits in the queue, or cancels the running and queued ones when
+// CancelInProgress is set.
+type Concurrency struct {
+	Group            string `yaml:"group" json:"group"`
+	CancelInProgress bool   `yaml:"cancel_in_progress,omitempty" json:"cancel_in_progress,omitempty"`
+}
+
+// GroupName returns the concurrency group, or "" when c is nil.
+func (c *Concurrency) GroupName() string {
+	if c == nil {
+		return ""
+	}
+	return c.Group
+}
+
+// Environment names the deployment target of a job (e.g. staging) and an
+// optional URL where the deployed version can be reached.
+type Environment struct {
+	Name string `yaml:"name" json:"name"`
+	Url  string `yaml:"url,omitempty" json:"url,omitempty"`
+}
+
+// WhenManual marks a job that waits for a human approval before it is launched.
+const WhenManual = "manual"
+
+// IsManual reports whether the job must be approved before it runs.
+func (j Job) IsManual() bool {
+	return j.When == WhenManual
 }
 
 // Notify declares the per-job notification targets. Both fields are optional;
@@ -22,27 +147,44 @@ type Notify struct {
 // JobSpec is the persisted snapshot of a webhook-created job's inputs, kept on
 // the DB row so an identical K8s Job can be recreated later (rerun). Tokens are
 // intentionally NOT stored — they are re-resolved from config at rerun time.
-// Steps are not stored either — the runner reads neutron.yaml from the same
-// immutable commit, so the same file is reproduced exactly.
+// Steps are pinned as resolved at webhook time, since include/extends may pull
+// them from other files or projects the runner cannot read. Specs without
+// steps (older rows) fall back to the runner reading neutron.yaml itself.
 type JobSpec struct {
-	Platform     string            `json:"platform"`               // GitLab / Codeup
-	JobName      string            `json:"job_name"`               // pipeline job key (e.g. "build")
+	Platform     string            `json:"platform"`            // GitLab / Codeup / GitHub / Gitea
+	Codebase     string            `json:"codebase,omitempty"`  // codebase instance id; empty (older rows) uses the platform's default
+	Project      string            `json:"project,omitempty"`   // registered project id; its token is resolved at launch
+	Namespace    string            `json:"namespace,omitempty"` // K8s namespace; kubernetes.namespace when empty
+	JobName      string            `json:"job_name"`            // pipeline job key (e.g. "build")
 	Image        string            `json:"image"`
 	Resources    *Resources        `json:"resources,omitempty"`
-	ProjectId    string            `json:"project_id"`             // RunnerConfig.ProjectId (numeric string)
+	ProjectId    string            `json:"project_id"` // RunnerConfig.ProjectId (numeric string)
 	CommitSha    string            `json:"commit_sha"`
 	ReportSha    string            `json:"report_sha"`
-	Trigger      string            `json:"trigger"`                // PUSH / MR / TAG
+	Trigger      string            `json:"trigger"` // PUSH / MR / TAG
 	GitRepoUrl   string            `json:"git_repo_url"`
 	TargetBranch string            `json:"target_branch,omitempty"`
 	CodeRef      string            `json:"code_ref,omitempty"`
 	SourceUrl    string            `json:"source_url,omitempty"`
 	QueryParams  map[string]string `json:"query_params,omitempty"` // webhook URL query params → pod env
+	Manual       bool              `json:"manual,omitempty"`       // when: manual; every run, reruns included, waits for approval
+	Approvers    []string          `json:"approvers,omitempty"`    // required approvers of a manual job
+	Environment  *Environment      `json:"environment,omitempty"`  // deployment target recorded on success
+	TriggeredBy  string            `json:"triggered_by,omitempty"` // webhook user, or who asked for a rerun/redeploy
+	Concurrency  *Concurrency      `json:"concurrency,omitempty"`
+	Checkout     *Checkout         `json:"checkout,omitempty"`
+	Scheduling   *Scheduling       `json:"scheduling,omitempty"`
+	Steps        []Step            `json:"steps,omitempty"`
 }
 
+// Step is one command of a job. A step may instead name a snippet with Uses,
+// passing With as its parameters; the API expands it into Command when the job
+// is created, so the spec records the exact script the run executed.
 type Step struct {
-	StepName string `yaml:"name"`
-	Command  string `yaml:"cmd"`
+	StepName string            `yaml:"name"`
+	Command  string            `yaml:"cmd"`
+	Uses     string            `yaml:"uses,omitempty" json:",omitempty"`
+	With     map[string]string `yaml:"with,omitempty" json:",omitempty"`
 }
 
 type Resources struct {
@@ -55,21 +197,47 @@ type ResourceSpec struct {
 	Memory string `yaml:"memory,omitempty"`
 }
 
+// Validate checks that every cpu and memory value is a K8s quantity, such as
+// 500m or 2Gi.
+func (r *Resources) Validate() error {
+	if r == nil {
+		return nil
+	}
+	for _, spec := range []struct {
+		kind string
+		ResourceSpec
+	}{{"limits", r.Limits}, {"requests", r.Requests}} {
+		for _, q := range []struct{ name, value string }{{"cpu", spec.Cpu}, {"memory", spec.Memory}} {
+			if q.value == "" {
+				continue
+			}
+			if _, err := resource.ParseQuantity(q.value); err != nil {
+				return fmt.Errorf("invalid resources.%s.%s %q: %v", spec.kind, q.name, q.value, err)
+			}
+		}
+	}
+	return nil
+}
+
 type RunnerConfig struct {
 	CodebaseToken      string // codebase access token
 	CodebaseUrl        string // codebase API base URL
 	ProjectId          string
 	CommitSha          string
-	ReportSha          string // GitLab: commit SHA for status reporting; Codeup: unused
+	ReportSha          string // commit SHA for status reporting
 	Trigger            string
 	JobName            string
 	GitRepoUrl         string
+	CloneUrl           string // URL the checkout clones; GitRepoUrl when empty
 	GitPrivateKey      string
-	TargetBranch       string // MR target branch, GitLab only
-	CodeRef            string // tag name for TAG, branch name for PUSH, empty for MR
-	SourceUrl          string // URL to the source branch/MR on the code hosting platform
-	SkipTriggerCheck   bool   // skip trigger type validation (for API-triggered jobs)
-	SkipPlatformReport bool   // skip reporting commit status to platform (for API-triggered jobs)
+	TargetBranch       string    // MR target branch
+	CodeRef            string    // tag name for TAG, branch name for PUSH, empty for MR
+	SourceUrl          string    // URL to the source branch/MR on the code hosting platform
+	SkipTriggerCheck   bool      // skip trigger type validation (for API-triggered jobs)
+	SkipPlatformReport bool      // skip reporting commit status to platform (for API-triggered jobs)
+	Steps              []Step    // resolved job steps passed to the runner; empty lets it read neutron.yaml
+	TokenFile          bool      // hand CodebaseToken to the runner in a file instead of the step env
+	Checkout           *Checkout // clone options of the checkout init container; a full clone when nil
 }
 
 type StepResult string
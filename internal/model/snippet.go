@@ -0,0 +1,159 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Snippet parameter types.
+const (
+	ParamString = "string"
+	ParamInt    = "int"
+	ParamBool   = "bool"
+)
+
+var paramNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SnippetParam declares one parameter of a snippet. Values are passed as query
+// parameters of /s/:name (or `with:` of a snippet step) and set as shell
+// variables before the snippet content.
+type SnippetParam struct {
+	Name     string   `json:"name" yaml:"name"`
+	Type     string   `json:"type,omitempty" yaml:"type,omitempty"` // string (default), int or bool
+	Required bool     `json:"required,omitempty" yaml:"required,omitempty"`
+	Default  string   `json:"default,omitempty" yaml:"default,omitempty"`
+	Enum     []string `json:"enum,omitempty" yaml:"enum,omitempty"`   // allowed values; empty allows any
+	Regex    string   `json:"regex,omitempty" yaml:"regex,omitempty"` // must match the whole value
+}
+
+// ParseSnippetParams parses the params column of a snippet. Only a JSON array
+// of SnippetParam is a schema; legacy free text (e.g. "ENV (prod|dev)") was a
+// note for readers and declares no schema, so it yields no params.
+func ParseSnippetParams(s string) ([]SnippetParam, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(s, "[") {
+		var params []SnippetParam
+		if err := json.Unmarshal([]byte(s), &params); err != nil {
+			return nil, fmt.Errorf("invalid params: %w", err)
+		}
+		return params, nil
+	}
+	return nil, nil
+}
+
+// ValidateSnippetParams checks a parameter schema: names are unique shell
+// identifiers, types are known, regexes compile, and defaults are valid values.
+func ValidateSnippetParams(params []SnippetParam) error {
+	seen := make(map[string]bool, len(params))
+	for _, p := range params {
+		if !paramNameRegex.MatchString(p.Name) {
+			return fmt.Errorf("param %q: name must be a shell identifier", p.Name)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("param %s declared twice", p.Name)
+		}
+		seen[p.Name] = true
+		switch p.Type {
+		case "", ParamString, ParamInt, ParamBool:
+		default:
+			return fmt.Errorf("param %s: unknown type %q", p.Name, p.Type)
+		}
+		if p.Regex != "" {
+			if _, err := regexp.Compile(p.Regex); err != nil {
+				return fmt.Errorf("param %s: invalid regex: %w", p.Name, err)
+			}
+		}
+		for _, v := range p.Enum {
+			if err := p.checkType(v); err != nil {
+				return fmt.Errorf("param %s: enum value %q: %w", p.Name, v, err)
+			}
+		}
+		if p.Default != "" {
+			if err := p.Check(p.Default); err != nil {
+				return fmt.Errorf("param %s: default: %w", p.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Check validates a value against the parameter's type, enum and regex.
+func (p SnippetParam) Check(value string) error {
+	if err := p.checkType(value); err != nil {
+		return err
+	}
+	if len(p.Enum) > 0 && !slices.Contains(p.Enum, value) {
+		return fmt.Errorf("must be one of %s", strings.Join(p.Enum, ", "))
+	}
+	if p.Regex != "" {
+		re, err := regexp.Compile(`^(?:` + p.Regex + `)$`)
+		if err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("must match %s", p.Regex)
+		}
+	}
+	return nil
+}
+
+func (p SnippetParam) checkType(value string) error {
+	switch p.Type {
+	case ParamInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+	case ParamBool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("must be true or false")
+		}
+	}
+	return nil
+}
+
+// ResolveSnippetArgs validates the arguments of a snippet invocation against
+// its schema and fills in defaults. With an empty schema any argument whose
+// name is a shell identifier is passed through; otherwise unknown arguments
+// are rejected.
+func ResolveSnippetArgs(params []SnippetParam, args map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(args))
+	if len(params) == 0 {
+		for k, v := range args {
+			if paramNameRegex.MatchString(k) {
+				resolved[k] = v
+			}
+		}
+		return resolved, nil
+	}
+	declared := make(map[string]bool, len(params))
+	for _, p := range params {
+		declared[p.Name] = true
+		v, ok := args[p.Name]
+		if !ok {
+			if p.Required {
+				return nil, fmt.Errorf("missing required param %s", p.Name)
+			}
+			if p.Default == "" {
+				continue
+			}
+			v = p.Default
+		}
+		if err := p.Check(v); err != nil {
+			return nil, fmt.Errorf("param %s: %w", p.Name, err)
+		}
+		resolved[p.Name] = v
+	}
+	for k := range args {
+		if !declared[k] {
+			return nil, fmt.Errorf("unknown param %s", k)
+		}
+	}
+	return resolved, nil
+}
@@ -1,17 +1,15 @@
 package parser
 
 import (
-	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"gopkg.in/yaml.v3"
 	"io"
 	"net/http"
 	"net/url"
 	"neutron/internal/model"
 	"strings"
-	"time"
 )
 
 const MaxBodySize = 1 << 20 // 1MB
@@ -20,104 +18,204 @@ type FileResponse struct {
 	Content string `json:"content"`
 }
 
+// PipelineFile is the pipeline definition read from the repository root.
+const PipelineFile = "neutron.yaml"
+
+// ErrFileNotFound is wrapped by FetchFile errors when the file does not exist
+// at the requested ref.
+var ErrFileNotFound = errors.New("file not found")
+
+// TreeEntry is an entry of a repository directory listing (GitLab, Codeup and
+// the GitHub/Gitea contents APIs share the shape).
+type TreeEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"` // blob or tree; file or dir on GitHub/Gitea
+}
+
 type Base struct {
-	AccessApiPath  string
-	AccessToken    string
-	AuthHeaderName string // e.g. "PRIVATE-TOKEN" (GitLab), "x-yunxiao-token" (Codeup)
-	Client         *http.Client
-	CodeSha        string
-	ReportSha      string
-	TargetBranch   string
-	Trigger        string
+	FilesApiPath    string              // repository files API endpoint; the escaped file path is appended
+	FilePathEscaper func(string) string // escapes a file path for FilesApiPath; url.PathEscape when nil
+	TreeApiPath     string              // repository tree API endpoint, for listing directories
+	DirInPath       bool                // the listed directory is appended to TreeApiPath instead of passed as ?path= (GitHub, Gitea)
+	AccessToken     string
+	AuthHeaderName  string // e.g. "PRIVATE-TOKEN" (GitLab), "x-yunxiao-token" (Codeup), "Authorization" (GitHub, Gitea)
+	AuthScheme      string // prefixed to the token, e.g. "Bearer" (GitHub), "token" (Gitea)
+	Client          *http.Client
+	CodeSha         string
+	ReportSha       string
+	TargetBranch    string
+	Trigger         string
 }
 
-func (b *Base) Parse() (model.Pipeline, error) {
-	req, err := http.NewRequest("GET", b.AccessApiPath, nil)
+// Parse fetches neutron.yaml at CodeSha and resolves its include/extends
+// directives. projects resolves `include: project` entries; nil rejects them.
+func (b *Base) Parse(projects ProjectFetcher) (model.Pipeline, error) {
+	data, err := b.FetchFile(PipelineFile, b.CodeSha)
 	if err != nil {
 		return model.Pipeline{}, err
 	}
+	return Resolve(data, b, b.CodeSha, projects)
+}
+
+// FetchFile fetches a file of the repository at ref through the platform's
+// files API.
+func (b *Base) FetchFile(filePath string, ref string) ([]byte, error) {
+	escape := b.FilePathEscaper
+	if escape == nil {
+		escape = url.PathEscape
+	}
+	req, err := http.NewRequest("GET", b.FilesApiPath+"/"+escape(filePath), nil)
+	if err != nil {
+		return nil, err
+	}
 	query := req.URL.Query()
-	query.Add("ref", b.CodeSha)
+	query.Add("ref", ref)
 	req.URL.RawQuery = query.Encode()
-	authHeader := b.AuthHeaderName
-	if authHeader == "" {
-		authHeader = "PRIVATE-TOKEN"
-	}
-	req.Header.Add(authHeader, b.AccessToken)
+	b.authorize(req)
 	res, err := b.Client.Do(req)
 	if err != nil {
-		return model.Pipeline{}, err
+		return nil, err
 	}
 	defer res.Body.Close()
 	if res.StatusCode == http.StatusNotFound {
-		return model.Pipeline{}, fmt.Errorf("neutron.yaml not found in repository (ref: %s)", b.CodeSha)
+		return nil, fmt.Errorf("%s not found in repository (ref: %s): %w", filePath, ref, ErrFileNotFound)
 	}
 	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
-		return model.Pipeline{}, fmt.Errorf("authentication failed when accessing API (status: %d)", res.StatusCode)
+		return nil, fmt.Errorf("authentication failed when accessing API (status: %d)", res.StatusCode)
 	}
 	if res.StatusCode >= 400 {
-		return model.Pipeline{}, fmt.Errorf("API returned error (status: %d)", res.StatusCode)
+		return nil, fmt.Errorf("API returned error (status: %d)", res.StatusCode)
 	}
 	var fileResponse FileResponse
 	err = json.NewDecoder(res.Body).Decode(&fileResponse)
 	if err != nil {
-		return model.Pipeline{}, err
+		return nil, err
+	}
+	// GitHub wraps the base64 content at 60 columns
+	return base64.StdEncoding.DecodeString(strings.ReplaceAll(fileResponse.Content, "\n", ""))
+}
+
+// authorize sets the platform's token header on an API request.
+func (b *Base) authorize(req *http.Request) {
+	authHeader := b.AuthHeaderName
+	if authHeader == "" {
+		authHeader = "PRIVATE-TOKEN"
+	}
+	token := b.AccessToken
+	if b.AuthScheme != "" {
+		token = b.AuthScheme + " " + token
 	}
-	neutronContent, err := base64.StdEncoding.DecodeString(fileResponse.Content)
+	req.Header.Add(authHeader, token)
+}
+
+// maxListPages bounds the pages ListFiles follows.
+const maxListPages = 100
+
+// ListFiles returns the files (not subdirectories) directly under dir of the
+// repository at ref, following the platform's pagination. A listing is
+// complete or an error, never silently truncated.
+func (b *Base) ListFiles(dir string, ref string) ([]string, error) {
+	if b.TreeApiPath == "" {
+		return nil, fmt.Errorf("listing directories is not supported")
+	}
+	apiPath := b.TreeApiPath
+	if b.DirInPath {
+		apiPath += "/" + EscapeFilePath(strings.Trim(dir, "/"))
+	}
+	u, err := url.Parse(apiPath)
 	if err != nil {
-		return model.Pipeline{}, err
+		return nil, err
+	}
+	query := u.Query()
+	if !b.DirInPath {
+		query.Add("path", dir)
+	}
+	query.Add("ref", ref)
+	query.Add("per_page", "100")
+	u.RawQuery = query.Encode()
+
+	var files []string
+	for page := 0; u != nil; page++ {
+		if page == maxListPages {
+			return nil, fmt.Errorf("%s has more than %d pages of entries", dir, maxListPages)
+		}
+		var entries []TreeEntry
+		if entries, u, err = b.listPage(u, dir, ref); err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Type == "blob" || e.Type == "file" {
+				files = append(files, e.Path)
+			}
+		}
 	}
-	var pipeline model.Pipeline
-	err = yaml.Unmarshal(neutronContent, &pipeline)
-	return pipeline, err
+	return files, nil
 }
 
-// ReadBody reads and closes the request body with size limit.
-func ReadBody(body io.ReadCloser) ([]byte, error) {
-	data, err := io.ReadAll(io.LimitReader(body, MaxBodySize))
-	body.Close()
+// listPage fetches one page of a directory listing and returns the URL of the
+// next page, or nil on the last one.
+func (b *Base) listPage(u *url.URL, dir string, ref string) ([]TreeEntry, *url.URL, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
-		return nil, fmt.Errorf("reading webhook body: %w", err)
+		return nil, nil, err
 	}
-	return data, nil
+	b.authorize(req)
+	res, err := b.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil, fmt.Errorf("%s not found in repository (ref: %s): %w", dir, ref, ErrFileNotFound)
+	}
+	if res.StatusCode >= 400 {
+		return nil, nil, fmt.Errorf("API returned error (status: %d)", res.StatusCode)
+	}
+	var entries []TreeEntry
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		return nil, nil, err
+	}
+	next, err := nextPage(u, res.Header)
+	return entries, next, err
 }
 
-// BuildSourceUrl constructs the URL to the source branch or MR on the code hosting platform.
-// It returns empty string for unsupported triggers (e.g., "API").
-func BuildSourceUrl(platform, trigger, codebaseUrl, repoUrl, ref, codeSha string, mrIid int) string {
-	switch platform {
-	case "GitLab":
-		projectPath := ExtractGitLabProjectPath(repoUrl)
-		if projectPath == "" {
-			return ""
-		}
-		refName := ExtractRefName(ref)
-		switch trigger {
-		case "MR":
-			return fmt.Sprintf("%s/%s/-/merge_requests/%d", codebaseUrl, projectPath, mrIid)
-		case "PUSH":
-			return fmt.Sprintf("%s/%s/-/tree/%s", codebaseUrl, projectPath, refName)
-		case "TAG":
-			return fmt.Sprintf("%s/%s/-/tags/%s", codebaseUrl, projectPath, refName)
+// nextPage returns the URL of the page after u from the Link header (GitHub,
+// Gitea) or X-Next-Page (GitLab, Codeup), or nil on the last page. The token
+// is sent to the next page, so it must be on the same host.
+func nextPage(u *url.URL, header http.Header) (*url.URL, error) {
+	for _, link := range strings.Split(strings.Join(header.Values("Link"), ","), ",") {
+		target, params, ok := strings.Cut(link, ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
 		}
-	case "Codeup":
-		orgId, codeupProject := ExtractCodeupOrgAndProject(repoUrl)
-		if orgId == "" || codeupProject == "" {
-			return ""
+		next, err := u.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid next page link: %w", err)
 		}
-		// codeupProject already contains orgId as its first segment (e.g. "orgId/group/project")
-		projectUrl := fmt.Sprintf("%s/codeup/%s", codebaseUrl, codeupProject)
-		refName := ExtractRefName(ref)
-		switch trigger {
-		case "MR":
-			return fmt.Sprintf("%s/change/%d", projectUrl, mrIid)
-		case "PUSH":
-			return fmt.Sprintf("%s/commit/%s?branch=%s", projectUrl, codeSha, url.QueryEscape(refName))
-		case "TAG":
-			return fmt.Sprintf("%s/tree/%s", projectUrl, refName)
+		if next.Host != u.Host {
+			return nil, fmt.Errorf("next page link leaves %s", u.Host)
 		}
+		return next, nil
+	}
+	if page := header.Get("X-Next-Page"); page != "" {
+		next := *u
+		query := next.Query()
+		query.Set("page", page)
+		next.RawQuery = query.Encode()
+		return &next, nil
 	}
-	return ""
+	return nil, nil
+}
+
+// ReadBody reads and closes the request body with size limit.
+func ReadBody(body io.ReadCloser) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, MaxBodySize))
+	body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading webhook body: %w", err)
+	}
+	return data, nil
 }
 
 func ExtractRefName(ref string) string {
@@ -143,47 +241,3 @@ func DetectTrigger(webhookType, codeSha, lastCommitId, targetBranch string) (tri
 		return "", "", "", "", fmt.Errorf("unsupported webhook type: %s", webhookType)
 	}
 }
-
-// FetchPipeline fetches neutron.yaml from a repository at the given ref.
-// It constructs the platform-specific API path from the repo URL.
-func FetchPipeline(platform, repoUrl, ref string, codebaseUrl, codebaseToken string, skipTLS bool) (model.Pipeline, error) {
-	var accessApiPath, authHeader string
-
-	switch platform {
-	case "GitLab":
-		projectPath := ExtractGitLabProjectPath(repoUrl)
-		if projectPath == "" {
-			return model.Pipeline{}, fmt.Errorf("cannot extract project path from URL: %s", repoUrl)
-		}
-		encodedPath := url.PathEscape(projectPath)
-		accessApiPath = fmt.Sprintf("%s/api/v4/projects/%s/repository/files/neutron.yaml", codebaseUrl, encodedPath)
-		authHeader = "PRIVATE-TOKEN"
-	case "Codeup":
-		orgId, projectPath := ExtractCodeupOrgAndProject(repoUrl)
-		if orgId == "" || projectPath == "" {
-			return model.Pipeline{}, fmt.Errorf("cannot extract org-id and project path from URL: %s", repoUrl)
-		}
-		encodedProjectPath := EncodeCodeupProjectPath(projectPath)
-		accessApiPath = fmt.Sprintf("%s/oapi/v1/codeup/organizations/%s/repositories/%s/files/neutron.yaml", codebaseUrl, orgId, encodedProjectPath)
-		authHeader = "x-yunxiao-token"
-	default:
-		return model.Pipeline{}, fmt.Errorf("unsupported platform: %s", platform)
-	}
-
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: skipTLS},
-		},
-	}
-
-	base := Base{
-		AccessApiPath:  accessApiPath,
-		AccessToken:    codebaseToken,
-		AuthHeaderName: authHeader,
-		Client:         client,
-		CodeSha:        ref,
-	}
-
-	return base.Parse()
-}
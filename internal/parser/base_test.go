@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// pagedTree serves a directory listing of n files, two per page, announcing
+// the next page with X-Next-Page (GitLab) or a Link header (GitHub, Gitea).
+func pagedTree(t *testing.T, n int, link bool) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		if r.URL.Query().Get("ref") != "main" {
+			t.Errorf("page %d requested without ref: %s", page, r.URL)
+		}
+		var entries []TreeEntry
+		for i := (page - 1) * 2; i < page*2 && i < n; i++ {
+			entries = append(entries, TreeEntry{Path: fmt.Sprintf("snippets/s%d.sh", i), Type: "blob"})
+		}
+		if page*2 < n {
+			if link {
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?ref=main&page=%d>; rel="next", <%s%s?page=9>; rel="last"`, srv.URL, r.URL.Path, page+1, srv.URL, r.URL.Path))
+			} else {
+				w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+			}
+		}
+		json.NewEncoder(w).Encode(entries)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestListFilesPaginates(t *testing.T) {
+	want := []string{"snippets/s0.sh", "snippets/s1.sh", "snippets/s2.sh", "snippets/s3.sh", "snippets/s4.sh"}
+	for _, link := range []bool{false, true} {
+		srv := pagedTree(t, len(want), link)
+		b := &Base{TreeApiPath: srv.URL + "/tree", Client: srv.Client()}
+		files, err := b.ListFiles("snippets", "main")
+		if err != nil {
+			t.Fatalf("link=%v: %v", link, err)
+		}
+		if !slices.Equal(files, want) {
+			t.Errorf("link=%v: files = %v, want %v", link, files, want)
+		}
+	}
+}
+
+func TestListFilesRejectsForeignNextPage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `<https://attacker.example/tree?page=2>; rel="next"`)
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+	b := &Base{TreeApiPath: srv.URL + "/tree", Client: srv.Client()}
+	if _, err := b.ListFiles("snippets", "main"); err == nil {
+		t.Error("followed a next page link to another host")
+	}
+}
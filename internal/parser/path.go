@@ -54,3 +54,29 @@ func ExtractCodeupOrgAndProject(sshUrl string) (orgId, projectPath string) {
 func EncodeCodeupProjectPath(projectPath string) string {
 	return strings.ReplaceAll(projectPath, "/", "%252F")
 }
+
+// GitHubApiUrl returns the REST API root of a GitHub instance from its web URL:
+// https://api.github.com for github.com, <url>/api/v3 for GitHub Enterprise.
+// e.g. "https://github.example.com" → "https://github.example.com/api/v3"
+func GitHubApiUrl(codebaseUrl string) string {
+	codebaseUrl = strings.TrimSuffix(codebaseUrl, "/")
+	parsed, err := url.Parse(codebaseUrl)
+	if err == nil && (parsed.Host == "github.com" || parsed.Host == "www.github.com") {
+		return "https://api.github.com"
+	}
+	if err == nil && (strings.HasPrefix(parsed.Host, "api.") || strings.HasSuffix(parsed.Path, "/api/v3")) {
+		return codebaseUrl
+	}
+	return codebaseUrl + "/api/v3"
+}
+
+// EscapeFilePath escapes each segment of a repository file path, keeping the
+// slashes, for APIs that take the path as part of the URL (GitHub contents).
+// e.g. "ci/my file.yaml" → "ci/my%20file.yaml"
+func EscapeFilePath(filePath string) string {
+	segments := strings.Split(filePath, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segments, "/")
+}
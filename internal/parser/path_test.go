@@ -0,0 +1,43 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestExtractRefName(t *testing.T) {
+	tests := []struct {
+		name string
+		ref  string
+		want string
+	}{
+		{"push main", "refs/heads/main", "main"},
+		{"push feature branch", "refs/heads/feature/add-auth", "feature/add-auth"},
+		{"push with slash", "refs/heads/bugfix/JIRA-123-fix", "bugfix/JIRA-123-fix"},
+		{"tag simple", "refs/tags/v1.0.0", "v1.0.0"},
+		{"tag with dots", "refs/tags/release-2024.06.15", "release-2024.06.15"},
+		{"plain ref", "abc123", "abc123"},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExtractRefName(tt.ref)
+			if got != tt.want {
+				t.Errorf("ExtractRefName(%q) = %q, want %q", tt.ref, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitHubApiUrl(t *testing.T) {
+	for in, want := range map[string]string{
+		"https://github.com":                "https://api.github.com",
+		"https://github.example.com/":       "https://github.example.com/api/v3",
+		"https://github.example.com/api/v3": "https://github.example.com/api/v3",
+		"https://api.github.com":            "https://api.github.com",
+	} {
+		if got := GitHubApiUrl(in); got != want {
+			t.Errorf("GitHubApiUrl(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package parser
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"neutron/internal/model"
+	"strings"
+)
+
+// maxIncludeDepth bounds nested includes so a cycle between files (or
+// projects) fails instead of recursing forever.
+const maxIncludeDepth = 5
+
+// FileFetcher reads a file of one repository at a ref.
+type FileFetcher interface {
+	FetchFile(filePath string, ref string) ([]byte, error)
+}
+
+// ProjectFetcher returns the FileFetcher of a registered project, identified
+// by project id or repo URL, for `include: project` entries.
+type ProjectFetcher func(project string) (FileFetcher, error)
+
+// Resolve parses a neutron.yaml and returns the pipeline with its includes
+// merged in and every job's `extends` applied. Local includes are read through
+// local at ref; project includes through projects at their pinned ref.
+//
+// Later includes override earlier ones, and the including file overrides all
+// of them, key by key in `templates` and `jobs`; the last top-level `checkout`
+// is the default of jobs that set none. The result has no include or template
+// left, so it is what the runner executes; its checkout options and resource
+// quantities are checked.
+func Resolve(data []byte, local FileFetcher, ref string, projects ProjectFetcher) (model.Pipeline, error) {
+	pipeline, err := loadPipeline(data, local, ref, projects, 0)
+	if err != nil {
+		return model.Pipeline{}, err
+	}
+	jobs := make(map[string]model.Job, len(pipeline.Jobs))
+	for name, job := range pipeline.Jobs {
+		resolved, err := extendJob(job, pipeline.Templates, nil)
+		if err != nil {
+			return model.Pipeline{}, fmt.Errorf("job %s: %w", name, err)
+		}
+		if resolved.Checkout == nil {
+			resolved.Checkout = pipeline.Checkout
+		}
+		if err := resolved.Checkout.Validate(); err != nil {
+			return model.Pipeline{}, fmt.Errorf("job %s: %w", name, err)
+		}
+		if err := resolved.Resources.Validate(); err != nil {
+			return model.Pipeline{}, fmt.Errorf("job %s: %w", name, err)
+		}
+		jobs[name] = resolved
+	}
+	return model.Pipeline{Jobs: jobs}, nil
+}
+
+// loadPipeline parses data and merges its includes, recursively.
+func loadPipeline(data []byte, local FileFetcher, ref string, projects ProjectFetcher, depth int) (model.Pipeline, error) {
+	var pipeline model.Pipeline
+	if err := yaml.Unmarshal(data, &pipeline); err != nil {
+		return model.Pipeline{}, err
+	}
+	if len(pipeline.Include) == 0 {
+		return pipeline, nil
+	}
+	if depth >= maxIncludeDepth {
+		return model.Pipeline{}, fmt.Errorf("includes nested deeper than %d levels", maxIncludeDepth)
+	}
+
+	merged := model.Pipeline{Templates: map[string]model.Job{}, Jobs: map[string]model.Job{}}
+	for _, inc := range pipeline.Include {
+		fetcher, incRef, file, err := includeSource(inc, local, ref, projects)
+		if err != nil {
+			return model.Pipeline{}, err
+		}
+		incData, err := fetcher.FetchFile(file, incRef)
+		if err != nil {
+			return model.Pipeline{}, fmt.Errorf("include %s: %w", describeInclude(inc), err)
+		}
+		included, err := loadPipeline(incData, fetcher, incRef, projects, depth+1)
+		if err != nil {
+			return model.Pipeline{}, fmt.Errorf("include %s: %w", describeInclude(inc), err)
+		}
+		mergeJobs(merged.Templates, included.Templates)
+		mergeJobs(merged.Jobs, included.Jobs)
+		if included.Checkout != nil {
+			merged.Checkout = included.Checkout
+		}
+	}
+	if pipeline.Checkout != nil {
+		merged.Checkout = pipeline.Checkout
+	}
+	mergeJobs(merged.Templates, pipeline.Templates)
+	mergeJobs(merged.Jobs, pipeline.Jobs)
+	return merged, nil
+}
+
+// includeSource returns where an include entry is read from.
+func includeSource(inc model.Include, local FileFetcher, ref string, projects ProjectFetcher) (FileFetcher, string, string, error) {
+	switch {
+	case inc.Local != "" && inc.Project == "":
+		return local, ref, strings.TrimPrefix(inc.Local, "/"), nil
+	case inc.Project != "" && inc.Local == "":
+		if inc.File == "" || inc.Ref == "" {
+			return nil, "", "", fmt.Errorf("include of project %s needs both file and ref", inc.Project)
+		}
+		if projects == nil {
+			return nil, "", "", fmt.Errorf("include of project %s is not supported here", inc.Project)
+		}
+		fetcher, err := projects(inc.Project)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("include of project %s: %w", inc.Project, err)
+		}
+		return fetcher, inc.Ref, strings.TrimPrefix(inc.File, "/"), nil
+	default:
+		return nil, "", "", fmt.Errorf("include must set exactly one of local or project")
+	}
+}
+
+func describeInclude(inc model.Include) string {
+	if inc.Local != "" {
+		return inc.Local
+	}
+	return fmt.Sprintf("%s:%s@%s", inc.Project, inc.File, inc.Ref)
+}
+
+func mergeJobs(dst, src map[string]model.Job) {
+	for name, job := range src {
+		dst[name] = job
+	}
+}
+
+// extendJob fills the unset image, resources, steps, notify, checkout and
+// scheduling of job from the template chain it extends. seen holds the
+// templates already visited to detect cycles.
+func extendJob(job model.Job, templates map[string]model.Job, seen []string) (model.Job, error) {
+	if job.Extends == "" {
+		return job, nil
+	}
+	for _, name := range seen {
+		if name == job.Extends {
+			return model.Job{}, fmt.Errorf("extends cycle: %s -> %s", strings.Join(seen, " -> "), job.Extends)
+		}
+	}
+	tmpl, ok := templates[job.Extends]
+	if !ok {
+		return model.Job{}, fmt.Errorf("unknown template %q", job.Extends)
+	}
+	tmpl, err := extendJob(tmpl, templates, append(seen, job.Extends))
+	if err != nil {
+		return model.Job{}, err
+	}
+	if job.Image == "" {
+		job.Image = tmpl.Image
+	}
+	if job.Resources == nil {
+		job.Resources = tmpl.Resources
+	}
+	if len(job.Steps) == 0 {
+		job.Steps = tmpl.Steps
+	}
+	if job.Notify == nil {
+		job.Notify = tmpl.Notify
+	}
+	if job.Checkout == nil {
+		job.Checkout = tmpl.Checkout
+	}
+	if job.Scheduling.IsZero() {
+		job.Scheduling = tmpl.Scheduling
+	}
+	job.Extends = ""
+	return job, nil
+}
@@ -0,0 +1,157 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeFiles is a FileFetcher over in-memory files keyed by "ref:path".
+type fakeFiles map[string]string
+
+func (f fakeFiles) FetchFile(filePath string, ref string) ([]byte, error) {
+	data, ok := f[ref+":"+filePath]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in repository (ref: %s)", filePath, ref)
+	}
+	return []byte(data), nil
+}
+
+func TestResolve(t *testing.T) {
+	local := fakeFiles{
+		"abc:ci/base.yaml": `
+templates:
+  go:
+    image: golang:1.22
+    steps:
+      - name: test
+        cmd: go test ./...
+jobs:
+  lint:
+    image: golangci/golangci-lint
+    trigger: [PUSH]
+    steps:
+      - name: lint
+        cmd: golangci-lint run
+`,
+	}
+	shared := fakeFiles{
+		"v1:templates.yaml": `
+templates:
+  notified:
+    extends: go
+    notify:
+      users: [ops]
+`,
+	}
+	projects := func(project string) (FileFetcher, error) {
+		if project != "ops/ci-templates" {
+			return nil, fmt.Errorf("project %s is not registered", project)
+		}
+		return shared, nil
+	}
+
+	data := `
+include:
+  - local: ci/base.yaml
+  - project: ops/ci-templates
+    ref: v1
+    file: templates.yaml
+jobs:
+  build:
+    extends: notified
+    trigger: [PUSH]
+  lint:
+    image: golangci/golangci-lint:v1.59
+    trigger: [MR]
+    steps:
+      - name: lint
+        cmd: golangci-lint run --fast
+`
+	pipeline, err := Resolve([]byte(data), local, "abc", projects)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	build := pipeline.Jobs["build"]
+	if build.Image != "golang:1.22" || len(build.Steps) != 1 || build.Steps[0].Command != "go test ./..." {
+		t.Errorf("build did not inherit image/steps through the template chain: %+v", build)
+	}
+	if build.Notify == nil || len(build.Notify.Users) != 1 || build.Notify.Users[0] != "ops" {
+		t.Errorf("build did not inherit notify: %+v", build.Notify)
+	}
+	if build.Extends != "" {
+		t.Errorf("build.Extends = %q, want it cleared", build.Extends)
+	}
+	if lint := pipeline.Jobs["lint"]; lint.Image != "golangci/golangci-lint:v1.59" || lint.Trigger[0] != "MR" {
+		t.Errorf("including file must override included job: %+v", lint)
+	}
+	if pipeline.Include != nil || pipeline.Templates != nil {
+		t.Errorf("resolved pipeline still has include/templates")
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	local := fakeFiles{
+		"abc:self.yaml": "include:\n  - local: self.yaml\n",
+	}
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"unknown template", "jobs:\n  build:\n    extends: missing\n", `unknown template "missing"`},
+		{"extends cycle", "templates:\n  a:\n    extends: b\n  b:\n    extends: a\njobs:\n  build:\n    extends: a\n", "extends cycle"},
+		{"include cycle", "include:\n  - local: self.yaml\n", "nested deeper"},
+		{"missing file", "include:\n  - local: nope.yaml\n", "nope.yaml not found"},
+		{"project without ref", "include:\n  - project: ops/ci\n    file: a.yaml\n", "needs both file and ref"},
+		{"project not supported", "include:\n  - project: ops/ci\n    ref: v1\n    file: a.yaml\n", "not supported"},
+		{"local and project", "include:\n  - local: a.yaml\n    project: ops/ci\n", "exactly one"},
+		{"checkout filter", "checkout:\n  filter: blob:all\njobs:\n  build:\n    image: x\n", "unsupported checkout filter"},
+		{"checkout sparse", "jobs:\n  build:\n    checkout:\n      sparse: [../x]\n", "invalid checkout sparse"},
+		{"resource quantity", "jobs:\n  build:\n    resources:\n      limits:\n        cpu: 2 cores\n", `job build: invalid resources.limits.cpu "2 cores"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Resolve([]byte(tt.data), local, "abc", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Resolve() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestResolveCheckout verifies that the top-level checkout is the default of
+// jobs that set none, directly or through extends.
+func TestResolveCheckout(t *testing.T) {
+	data := `
+checkout:
+  depth: 1
+templates:
+  mono:
+    checkout:
+      sparse: [services/api]
+      submodules: true
+jobs:
+  lint:
+    image: alpine
+  api:
+    extends: mono
+  full:
+    checkout: {}
+`
+	pipeline, err := Resolve([]byte(data), fakeFiles{}, "abc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := pipeline.Jobs["lint"].Checkout; c == nil || c.Depth != 1 {
+		t.Errorf("lint checkout = %+v, want the top-level one", c)
+	}
+	if c := pipeline.Jobs["api"].Checkout; c == nil || c.Depth != 0 || c.Submodules != "true" || len(c.Sparse) != 1 {
+		t.Errorf("api checkout = %+v, want the template's", c)
+	}
+	if c := pipeline.Jobs["full"].Checkout; c == nil || c.Depth != 0 {
+		t.Errorf("full checkout = %+v, want its own", c)
+	}
+}
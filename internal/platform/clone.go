@@ -0,0 +1,38 @@
+package platform
+
+import (
+	"fmt"
+	"neutron/internal/model"
+	"neutron/internal/parser"
+	"strings"
+)
+
+// HttpsCloner is implemented by platforms whose repositories can be cloned
+// over HTTPS with the codebase token, for clusters without an SSH key Secret.
+type HttpsCloner interface {
+	// HttpsClone returns the HTTPS URL of a repository and the user name and
+	// password that authenticate cb.Token on it.
+	HttpsClone(repoUrl string, cb model.CodeBase) (cloneUrl, user, password string, err error)
+}
+
+// Cloner returns the HttpsCloner of the named platform.
+func Cloner(name string) (HttpsCloner, error) {
+	p, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	cloner, ok := p.(HttpsCloner)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support HTTPS checkout", p.Name())
+	}
+	return cloner, nil
+}
+
+// httpsRepoUrl is <webUrl>/<path>.git for the repository path in repoUrl.
+func httpsRepoUrl(webUrl, repoUrl string) (string, error) {
+	repoPath := parser.ExtractGitLabProjectPath(repoUrl)
+	if webUrl == "" || repoPath == "" {
+		return "", fmt.Errorf("cannot build an HTTPS URL of %s from codebase URL %q", repoUrl, webUrl)
+	}
+	return strings.TrimSuffix(webUrl, "/") + "/" + repoPath + ".git", nil
+}
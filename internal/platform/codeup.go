@@ -0,0 +1,146 @@
+package platform
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"neutron/internal/codeup"
+	"neutron/internal/model"
+	"neutron/internal/parser"
+	"neutron/internal/reporter"
+	"time"
+)
+
+func init() { Register(codeupPlatform{}) }
+
+type codeupPlatform struct{}
+
+func (codeupPlatform) Name() string { return "Codeup" }
+
+func (codeupPlatform) ParseWebhook(header http.Header, body io.ReadCloser, cb model.CodeBase) (*Hook, error) {
+	if err := checkToken(header.Get("X-Codeup-Token"), cb.WebhookSecret); err != nil {
+		body.Close()
+		return nil, err
+	}
+	p, err := codeup.NewCodeupParser(body, cb.Url, cb.Token, cb.SkipTLSVerify)
+	if err != nil {
+		return nil, err
+	}
+	projectId := p.Request.Project.Id
+	if projectId == 0 {
+		projectId = p.Request.ProjectId
+	}
+	if projectId == 0 {
+		projectId = p.Request.Attributes.ProjectId
+	}
+	return &Hook{
+		Base:         &p.Base,
+		Trigger:      p.Trigger,
+		CodeSha:      p.CodeSha,
+		ReportSha:    p.ReportSha,
+		TargetBranch: p.TargetBranch,
+		Ref:          p.Request.Ref,
+		MrIid:        p.Request.Attributes.Iid,
+		ProjectId:    projectId,
+		TriggeredBy:  p.Username(),
+	}, nil
+}
+
+func (codeupPlatform) NewBase(repoUrl string, cb model.CodeBase) (*parser.Base, error) {
+	orgId, projectPath := parser.ExtractCodeupOrgAndProject(repoUrl)
+	if orgId == "" || projectPath == "" {
+		return nil, fmt.Errorf("cannot extract org-id and project path from URL: %s", repoUrl)
+	}
+	encodedProjectPath := parser.EncodeCodeupProjectPath(projectPath)
+	return &parser.Base{
+		FilesApiPath:    fmt.Sprintf("%s/oapi/v1/codeup/organizations/%s/repositories/%s/files", cb.Url, orgId, encodedProjectPath),
+		FilePathEscaper: parser.EncodeCodeupProjectPath,
+		TreeApiPath:     fmt.Sprintf("%s/oapi/v1/codeup/organizations/%s/repositories/%s/files/tree", cb.Url, orgId, encodedProjectPath),
+		AccessToken:     cb.Token,
+		AuthHeaderName:  "x-yunxiao-token",
+		Client:          newClient(30*time.Second, cb.SkipTLSVerify),
+	}, nil
+}
+
+func (codeupPlatform) SourceUrl(trigger, codebaseUrl, repoUrl, ref, codeSha string, mrIid int) string {
+	orgId, codeupProject := parser.ExtractCodeupOrgAndProject(repoUrl)
+	if orgId == "" || codeupProject == "" {
+		return ""
+	}
+	// codeupProject already contains orgId as its first segment (e.g. "orgId/group/project")
+	projectUrl := fmt.Sprintf("%s/codeup/%s", codebaseUrl, codeupProject)
+	refName := parser.ExtractRefName(ref)
+	switch trigger {
+	case "MR":
+		return fmt.Sprintf("%s/change/%d", projectUrl, mrIid)
+	case "PUSH":
+		return fmt.Sprintf("%s/commit/%s?branch=%s", projectUrl, codeSha, url.QueryEscape(refName))
+	case "TAG":
+		return fmt.Sprintf("%s/tree/%s", projectUrl, refName)
+	}
+	return ""
+}
+
+func (codeupPlatform) NewReporter(env RunnerEnv) (model.Reporter, error) {
+	orgId, projectPath := parser.ExtractCodeupOrgAndProject(env.RepoUrl)
+	if orgId == "" || projectPath == "" {
+		return nil, fmt.Errorf("cannot extract org-id and project path from repo URL: %s", env.RepoUrl)
+	}
+	encodedProjectPath := parser.EncodeCodeupProjectPath(projectPath)
+	return &reporter.CommitStatus{
+		Platform: "Codeup",
+		Url: fmt.Sprintf("%s/oapi/v1/codeup/organizations/%s/repositories/%s/commits/%s/statuses",
+			env.CodebaseUrl, orgId, encodedProjectPath, env.ReportSha),
+		Header: http.Header{"x-yunxiao-token": {env.Token}},
+		States: map[model.StepResult]string{
+			model.Pending: "pending",
+			model.Running: "pending",
+			model.Fail:    "failure",
+			model.Success: "success",
+		},
+		DefaultState: "failure",
+		TargetUrlKey: "targetUrl",
+		TargetUrl:    env.PipelineUrl,
+		Client:       newClient(10*time.Second, env.SkipTLSVerify),
+	}, nil
+}
+
+func (codeupPlatform) CloneUrl(repoUrl string) string { return repoUrl }
+
+func (codeupPlatform) hooks(repoUrl string, cb model.CodeBase) (hookApi, error) {
+	orgId, projectPath := parser.ExtractCodeupOrgAndProject(repoUrl)
+	if orgId == "" || projectPath == "" {
+		return hookApi{}, fmt.Errorf("cannot extract org-id and project path from URL: %s", repoUrl)
+	}
+	return hookApi{
+		hooksUrl: fmt.Sprintf("%s/oapi/v1/codeup/organizations/%s/repositories/%s/webhooks",
+			cb.Url, orgId, parser.EncodeCodeupProjectPath(projectPath)),
+		listQuery: "perPage=100",
+		update:    http.MethodPut,
+		header:    http.Header{"x-yunxiao-token": {cb.Token}},
+		client:    newClient(30*time.Second, cb.SkipTLSVerify),
+	}, nil
+}
+
+func (p codeupPlatform) InstallHook(repoUrl string, cb model.CodeBase, hookUrl, secret string) (string, error) {
+	api, err := p.hooks(repoUrl, cb)
+	if err != nil {
+		return "", err
+	}
+	return api.install(hookUrl, map[string]any{
+		"url":                 hookUrl,
+		"token":               secret,
+		"pushEvents":          true,
+		"tagPushEvents":       true,
+		"mergeRequestsEvents": true,
+	})
+}
+
+func (p codeupPlatform) DeleteHook(repoUrl string, cb model.CodeBase, hookId string) error {
+	api, err := p.hooks(repoUrl, cb)
+	if err != nil {
+		return err
+	}
+	return api.remove(hookId)
+}
@@ -0,0 +1,153 @@
+package platform
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"neutron/internal/gitea"
+	"neutron/internal/model"
+	"neutron/internal/parser"
+	"neutron/internal/reporter"
+	"strings"
+	"time"
+)
+
+func init() { Register(giteaPlatform{}) }
+
+// giteaPlatform also serves Forgejo, which sends the same payloads under its own
+// header names.
+type giteaPlatform struct{}
+
+func (giteaPlatform) Name() string { return "Gitea" }
+
+func (giteaPlatform) ParseWebhook(header http.Header, body io.ReadCloser, cb model.CodeBase) (*Hook, error) {
+	event, signature := header.Get(gitea.EventHeader), header.Get(gitea.SignatureHeader)
+	if event == "" {
+		event, signature = header.Get(gitea.ForgejoEventHeader), header.Get(gitea.ForgejoSignatureHeader)
+	}
+	p, err := gitea.NewGiteaParser(body, event, signature, cb.WebhookSecret, cb.Url, cb.Token, cb.SkipTLSVerify)
+	if err != nil {
+		return nil, err
+	}
+	return &Hook{
+		Base:         &p.Base,
+		Trigger:      p.Trigger,
+		CodeSha:      p.CodeSha,
+		ReportSha:    p.ReportSha,
+		TargetBranch: p.TargetBranch,
+		Ref:          p.Request.Ref,
+		MrIid:        p.Request.Number,
+		ProjectId:    p.Request.Repository.Id,
+		TriggeredBy:  p.Username(),
+	}, nil
+}
+
+func (giteaPlatform) NewBase(repoUrl string, cb model.CodeBase) (*parser.Base, error) {
+	repoPath := parser.ExtractGitLabProjectPath(repoUrl)
+	if repoPath == "" {
+		return nil, fmt.Errorf("cannot extract owner/repo from URL: %s", repoUrl)
+	}
+	contentsPath := fmt.Sprintf("%s/api/v1/repos/%s/contents", strings.TrimSuffix(cb.Url, "/"), repoPath)
+	return &parser.Base{
+		FilesApiPath:    contentsPath,
+		FilePathEscaper: parser.EscapeFilePath,
+		TreeApiPath:     contentsPath,
+		DirInPath:       true,
+		AccessToken:     cb.Token,
+		AuthHeaderName:  "Authorization",
+		AuthScheme:      "token",
+		Client:          newClient(30*time.Second, cb.SkipTLSVerify),
+	}, nil
+}
+
+func (giteaPlatform) SourceUrl(trigger, codebaseUrl, repoUrl, ref, codeSha string, mrIid int) string {
+	repoPath := parser.ExtractGitLabProjectPath(repoUrl)
+	if repoPath == "" {
+		return ""
+	}
+	refName := parser.ExtractRefName(ref)
+	switch trigger {
+	case "MR":
+		return fmt.Sprintf("%s/%s/pulls/%d", codebaseUrl, repoPath, mrIid)
+	case "PUSH":
+		return fmt.Sprintf("%s/%s/src/branch/%s", codebaseUrl, repoPath, refName)
+	case "TAG":
+		return fmt.Sprintf("%s/%s/src/tag/%s", codebaseUrl, repoPath, refName)
+	}
+	return ""
+}
+
+func (giteaPlatform) NewReporter(env RunnerEnv) (model.Reporter, error) {
+	repoPath := parser.ExtractGitLabProjectPath(env.RepoUrl)
+	if repoPath == "" {
+		return nil, fmt.Errorf("cannot extract owner/repo from repo URL: %s", env.RepoUrl)
+	}
+	return &reporter.CommitStatus{
+		Platform: "Gitea",
+		Url:      fmt.Sprintf("%s/api/v1/repos/%s/statuses/%s", strings.TrimSuffix(env.CodebaseUrl, "/"), repoPath, env.ReportSha),
+		Header:   http.Header{"Authorization": {"token " + env.Token}},
+		States: map[model.StepResult]string{
+			model.Pending: "pending",
+			model.Running: "pending",
+			model.Fail:    "failure",
+			model.Success: "success",
+		},
+		DefaultState: "error",
+		TargetUrl:    env.PipelineUrl,
+		Client:       newClient(10*time.Second, env.SkipTLSVerify),
+	}, nil
+}
+
+func (giteaPlatform) CloneUrl(repoUrl string) string { return repoUrl }
+
+// HttpsClone sends the token as the user name, with the password Gitea and
+// Forgejo reserve for token authentication.
+func (giteaPlatform) HttpsClone(repoUrl string, cb model.CodeBase) (string, string, string, error) {
+	cloneUrl, err := httpsRepoUrl(cb.Url, repoUrl)
+	return cloneUrl, cb.Token, "x-oauth-basic", err
+}
+
+func (giteaPlatform) hooks(repoUrl string, cb model.CodeBase) (hookApi, error) {
+	repoPath := parser.ExtractGitLabProjectPath(repoUrl)
+	if repoPath == "" {
+		return hookApi{}, fmt.Errorf("cannot extract owner/repo from URL: %s", repoUrl)
+	}
+	return hookApi{
+		hooksUrl:  fmt.Sprintf("%s/api/v1/repos/%s/hooks", strings.TrimSuffix(cb.Url, "/"), repoPath),
+		listQuery: "limit=50",
+		update:    http.MethodPatch,
+		header:    http.Header{"Authorization": {"token " + cb.Token}},
+		client:    newClient(30*time.Second, cb.SkipTLSVerify),
+	}, nil
+}
+
+// InstallHook also tests the hook. Gitea queues the test, a push of the
+// latest commit that starts its jobs like any push, and shows its response
+// under the hook's recent deliveries.
+func (p giteaPlatform) InstallHook(repoUrl string, cb model.CodeBase, hookUrl, secret string) (string, error) {
+	api, err := p.hooks(repoUrl, cb)
+	if err != nil {
+		return "", err
+	}
+	id, err := api.install(hookUrl, map[string]any{
+		"type":   "gitea",
+		"active": true,
+		"events": []string{"push", "pull_request"},
+		"config": map[string]string{"url": hookUrl, "content_type": "json", "secret": secret},
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := api.do(http.MethodPost, api.hooksUrl+"/"+id+"/tests", nil, nil); err != nil {
+		return id, fmt.Errorf("webhook %s installed but test delivery failed: %w", id, err)
+	}
+	return id, nil
+}
+
+func (p giteaPlatform) DeleteHook(repoUrl string, cb model.CodeBase, hookId string) error {
+	api, err := p.hooks(repoUrl, cb)
+	if err != nil {
+		return err
+	}
+	return api.remove(hookId)
+}
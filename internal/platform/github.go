@@ -0,0 +1,164 @@
+package platform
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"neutron/internal/github"
+	"neutron/internal/model"
+	"neutron/internal/parser"
+	"neutron/internal/reporter"
+	"strings"
+	"time"
+)
+
+func init() { Register(githubPlatform{}) }
+
+// githubPlatform reports commit statuses, which unlike check runs only need a
+// personal access token rather than a GitHub App.
+type githubPlatform struct{}
+
+func (githubPlatform) Name() string { return "GitHub" }
+
+func (githubPlatform) ParseWebhook(header http.Header, body io.ReadCloser, cb model.CodeBase) (*Hook, error) {
+	event := header.Get(github.EventHeader)
+	if event == "ping" {
+		body.Close()
+		return nil, ErrPing
+	}
+	p, err := github.NewGitHubParser(body, event, header.Get(github.SignatureHeader), cb.WebhookSecret, cb.Url, cb.Token, cb.SkipTLSVerify)
+	if err != nil {
+		return nil, err
+	}
+	return &Hook{
+		Base:         &p.Base,
+		Trigger:      p.Trigger,
+		CodeSha:      p.CodeSha,
+		ReportSha:    p.ReportSha,
+		TargetBranch: p.TargetBranch,
+		Ref:          p.Request.Ref,
+		MrIid:        p.Request.Number,
+		ProjectId:    p.Request.Repository.Id,
+		TriggeredBy:  p.Username(),
+	}, nil
+}
+
+func (githubPlatform) NewBase(repoUrl string, cb model.CodeBase) (*parser.Base, error) {
+	repoPath := parser.ExtractGitLabProjectPath(repoUrl)
+	if repoPath == "" {
+		return nil, fmt.Errorf("cannot extract owner/repo from URL: %s", repoUrl)
+	}
+	contentsPath := fmt.Sprintf("%s/repos/%s/contents", parser.GitHubApiUrl(cb.Url), repoPath)
+	return &parser.Base{
+		FilesApiPath:    contentsPath,
+		FilePathEscaper: parser.EscapeFilePath,
+		TreeApiPath:     contentsPath,
+		DirInPath:       true,
+		AccessToken:     cb.Token,
+		AuthHeaderName:  "Authorization",
+		AuthScheme:      "Bearer",
+		Client:          newClient(30*time.Second, cb.SkipTLSVerify),
+	}, nil
+}
+
+func (githubPlatform) SourceUrl(trigger, codebaseUrl, repoUrl, ref, codeSha string, mrIid int) string {
+	repoPath := parser.ExtractGitLabProjectPath(repoUrl)
+	if repoPath == "" {
+		return ""
+	}
+	refName := parser.ExtractRefName(ref)
+	switch trigger {
+	case "MR":
+		return fmt.Sprintf("%s/%s/pull/%d", codebaseUrl, repoPath, mrIid)
+	case "PUSH":
+		return fmt.Sprintf("%s/%s/tree/%s", codebaseUrl, repoPath, refName)
+	case "TAG":
+		return fmt.Sprintf("%s/%s/releases/tag/%s", codebaseUrl, repoPath, refName)
+	}
+	return ""
+}
+
+func (githubPlatform) NewReporter(env RunnerEnv) (model.Reporter, error) {
+	repoPath := parser.ExtractGitLabProjectPath(env.RepoUrl)
+	if repoPath == "" {
+		return nil, fmt.Errorf("cannot extract owner/repo from repo URL: %s", env.RepoUrl)
+	}
+	return &reporter.CommitStatus{
+		Platform: "GitHub",
+		Url:      fmt.Sprintf("%s/repos/%s/statuses/%s", parser.GitHubApiUrl(env.CodebaseUrl), repoPath, env.ReportSha),
+		Header: http.Header{
+			"Authorization": {"Bearer " + env.Token},
+			"Accept":        {"application/vnd.github+json"},
+		},
+		States: map[model.StepResult]string{
+			model.Pending: "pending",
+			model.Running: "pending",
+			model.Fail:    "failure",
+			model.Success: "success",
+		},
+		DefaultState:   "error",
+		MaxDescription: 140,
+		TargetUrl:      env.PipelineUrl,
+		Client:         newClient(10*time.Second, env.SkipTLSVerify),
+	}, nil
+}
+
+func (githubPlatform) CloneUrl(repoUrl string) string { return repoUrl }
+
+// HttpsClone clones from the web host of the instance, whose url may be its
+// API root; any token type is accepted as the x-access-token user's password.
+func (githubPlatform) HttpsClone(repoUrl string, cb model.CodeBase) (string, string, string, error) {
+	webUrl := strings.TrimSuffix(strings.TrimSuffix(cb.Url, "/"), "/api/v3")
+	if webUrl == "https://api.github.com" {
+		webUrl = "https://github.com"
+	}
+	cloneUrl, err := httpsRepoUrl(webUrl, repoUrl)
+	return cloneUrl, "x-access-token", cb.Token, err
+}
+
+func (githubPlatform) hooks(repoUrl string, cb model.CodeBase) (hookApi, error) {
+	repoPath := parser.ExtractGitLabProjectPath(repoUrl)
+	if repoPath == "" {
+		return hookApi{}, fmt.Errorf("cannot extract owner/repo from URL: %s", repoUrl)
+	}
+	return hookApi{
+		hooksUrl:  fmt.Sprintf("%s/repos/%s/hooks", parser.GitHubApiUrl(cb.Url), repoPath),
+		listQuery: "per_page=100",
+		update:    http.MethodPatch,
+		header: http.Header{
+			"Authorization": {"Bearer " + cb.Token},
+			"Accept":        {"application/vnd.github+json"},
+		},
+		client: newClient(30*time.Second, cb.SkipTLSVerify),
+	}, nil
+}
+
+// InstallHook also pings the hook; GitHub delivers the ping asynchronously and
+// shows its response under the hook's recent deliveries.
+func (p githubPlatform) InstallHook(repoUrl string, cb model.CodeBase, hookUrl, secret string) (string, error) {
+	api, err := p.hooks(repoUrl, cb)
+	if err != nil {
+		return "", err
+	}
+	id, err := api.install(hookUrl, map[string]any{
+		"name":   "web",
+		"active": true,
+		"events": []string{"push", "pull_request"},
+		"config": map[string]string{"url": hookUrl, "content_type": "json", "secret": secret},
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := api.do(http.MethodPost, api.hooksUrl+"/"+id+"/pings", nil, nil); err != nil {
+		return id, fmt.Errorf("webhook %s installed but ping failed: %w", id, err)
+	}
+	return id, nil
+}
+
+func (p githubPlatform) DeleteHook(repoUrl string, cb model.CodeBase, hookId string) error {
+	api, err := p.hooks(repoUrl, cb)
+	if err != nil {
+		return err
+	}
+	return api.remove(hookId)
+}
@@ -0,0 +1,144 @@
+package platform
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"neutron/internal/gitlab"
+	"neutron/internal/model"
+	"neutron/internal/parser"
+	"neutron/internal/reporter"
+	"time"
+)
+
+func init() { Register(gitlabPlatform{}) }
+
+type gitlabPlatform struct{}
+
+func (gitlabPlatform) Name() string { return "GitLab" }
+
+func (gitlabPlatform) ParseWebhook(header http.Header, body io.ReadCloser, cb model.CodeBase) (*Hook, error) {
+	if err := checkToken(header.Get("X-Gitlab-Token"), cb.WebhookSecret); err != nil {
+		body.Close()
+		return nil, err
+	}
+	p, err := gitlab.NewGitLabParser(body, cb.Url, cb.Token, cb.SkipTLSVerify)
+	if err != nil {
+		return nil, err
+	}
+	return &Hook{
+		Base:         &p.Base,
+		Trigger:      p.Trigger,
+		CodeSha:      p.CodeSha,
+		ReportSha:    p.ReportSha,
+		TargetBranch: p.TargetBranch,
+		Ref:          p.Request.Ref,
+		MrIid:        p.Request.Attributes.Iid,
+		ProjectId:    p.Request.Project.Id,
+		TriggeredBy:  p.Username(),
+	}, nil
+}
+
+func (gitlabPlatform) NewBase(repoUrl string, cb model.CodeBase) (*parser.Base, error) {
+	projectPath := parser.ExtractGitLabProjectPath(repoUrl)
+	if projectPath == "" {
+		return nil, fmt.Errorf("cannot extract project path from URL: %s", repoUrl)
+	}
+	encodedPath := url.PathEscape(projectPath)
+	return &parser.Base{
+		FilesApiPath:   fmt.Sprintf("%s/api/v4/projects/%s/repository/files", cb.Url, encodedPath),
+		TreeApiPath:    fmt.Sprintf("%s/api/v4/projects/%s/repository/tree", cb.Url, encodedPath),
+		AccessToken:    cb.Token,
+		AuthHeaderName: "PRIVATE-TOKEN",
+		Client:         newClient(30*time.Second, cb.SkipTLSVerify),
+	}, nil
+}
+
+func (gitlabPlatform) SourceUrl(trigger, codebaseUrl, repoUrl, ref, codeSha string, mrIid int) string {
+	projectPath := parser.ExtractGitLabProjectPath(repoUrl)
+	if projectPath == "" {
+		return ""
+	}
+	refName := parser.ExtractRefName(ref)
+	switch trigger {
+	case "MR":
+		return fmt.Sprintf("%s/%s/-/merge_requests/%d", codebaseUrl, projectPath, mrIid)
+	case "PUSH":
+		return fmt.Sprintf("%s/%s/-/tree/%s", codebaseUrl, projectPath, refName)
+	case "TAG":
+		return fmt.Sprintf("%s/%s/-/tags/%s", codebaseUrl, projectPath, refName)
+	}
+	return ""
+}
+
+func (gitlabPlatform) NewReporter(env RunnerEnv) (model.Reporter, error) {
+	return &reporter.CommitStatus{
+		Platform: "GitLab",
+		Url:      fmt.Sprintf("%s/api/v4/projects/%s/statuses/%s", env.CodebaseUrl, env.ProjectId, env.ReportSha),
+		Header:   http.Header{"PRIVATE-TOKEN": {env.Token}},
+		States: map[model.StepResult]string{
+			model.Pending: "pending",
+			model.Running: "running",
+			model.Fail:    "failed",
+			model.Success: "success",
+		},
+		DefaultState: "failed",
+		TargetUrl:    env.PipelineUrl,
+		Client:       newClient(10*time.Second, env.SkipTLSVerify),
+	}, nil
+}
+
+func (gitlabPlatform) CloneUrl(repoUrl string) string { return repoUrl }
+
+// HttpsClone authenticates a personal, project or group access token as oauth2.
+func (gitlabPlatform) HttpsClone(repoUrl string, cb model.CodeBase) (string, string, string, error) {
+	cloneUrl, err := httpsRepoUrl(cb.Url, repoUrl)
+	return cloneUrl, "oauth2", cb.Token, err
+}
+
+func (gitlabPlatform) hooks(repoUrl string, cb model.CodeBase) (hookApi, error) {
+	projectPath := parser.ExtractGitLabProjectPath(repoUrl)
+	if projectPath == "" {
+		return hookApi{}, fmt.Errorf("cannot extract project path from URL: %s", repoUrl)
+	}
+	return hookApi{
+		hooksUrl:  fmt.Sprintf("%s/api/v4/projects/%s/hooks", cb.Url, url.PathEscape(projectPath)),
+		listQuery: "per_page=100",
+		update:    http.MethodPut,
+		header:    http.Header{"PRIVATE-TOKEN": {cb.Token}},
+		client:    newClient(30*time.Second, cb.SkipTLSVerify),
+	}, nil
+}
+
+// InstallHook also tests the hook. GitLab delivers the test synchronously, a
+// push of the default branch's latest commit that starts its jobs like any
+// push, and fails the request when the delivery does.
+func (p gitlabPlatform) InstallHook(repoUrl string, cb model.CodeBase, hookUrl, secret string) (string, error) {
+	api, err := p.hooks(repoUrl, cb)
+	if err != nil {
+		return "", err
+	}
+	id, err := api.install(hookUrl, map[string]any{
+		"url":                   hookUrl,
+		"token":                 secret,
+		"push_events":           true,
+		"tag_push_events":       true,
+		"merge_requests_events": true,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := api.do(http.MethodPost, api.hooksUrl+"/"+id+"/test/push_events", nil, nil); err != nil {
+		return id, fmt.Errorf("webhook %s installed but test delivery failed: %w", id, err)
+	}
+	return id, nil
+}
+
+func (p gitlabPlatform) DeleteHook(repoUrl string, cb model.CodeBase, hookId string) error {
+	api, err := p.hooks(repoUrl, cb)
+	if err != nil {
+		return err
+	}
+	return api.remove(hookId)
+}
@@ -0,0 +1,154 @@
+package platform
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"neutron/internal/model"
+	"strconv"
+	"strings"
+)
+
+// HookInstaller is implemented by platforms whose API can manage the webhooks
+// of a repository, so registering a project can install its hook instead of
+// someone pasting the URL into the platform by hand.
+type HookInstaller interface {
+	// InstallHook creates the hook delivering push, tag and MR events to
+	// hookUrl, or updates the one already delivering there, and returns its
+	// id. secret is the token the platform sends or signs deliveries with.
+	InstallHook(repoUrl string, cb model.CodeBase, hookUrl, secret string) (string, error)
+	// DeleteHook removes a hook; one that is already gone is not an error.
+	DeleteHook(repoUrl string, cb model.CodeBase, hookId string) error
+}
+
+// Installer returns the HookInstaller of the named platform.
+func Installer(name string) (HookInstaller, error) {
+	p, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	installer, ok := p.(HookInstaller)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support installing webhooks", p.Name())
+	}
+	return installer, nil
+}
+
+// checkToken verifies the plain secret token GitLab and Codeup send with
+// deliveries; without a configured secret every delivery is accepted.
+func checkToken(token, secret string) error {
+	if secret == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return errors.New("invalid webhook token")
+	}
+	return nil
+}
+
+// hookApi is the REST collection of a repository's webhooks; the four
+// platforms differ in paths, auth and payloads but not in how hooks are
+// listed, created, updated and deleted.
+type hookApi struct {
+	hooksUrl  string // collection URL; a hook is at hooksUrl/<id>
+	listQuery string // page size for listing, e.g. per_page=100
+	update    string // method updating a hook: PUT or PATCH
+	header    http.Header
+	client    *http.Client
+}
+
+// install updates the hook whose URL is hookUrl, or creates one, with body.
+// Listing first also checks that the platform is reachable and the token may
+// manage hooks before anything is changed.
+func (a hookApi) install(hookUrl string, body any) (string, error) {
+	var hooks []struct {
+		Id     int64  `json:"id"`
+		Url    string `json:"url"`
+		Config struct {
+			Url string `json:"url"`
+		} `json:"config"`
+	}
+	if err := a.do(http.MethodGet, a.hooksUrl+"?"+a.listQuery, nil, &hooks); err != nil {
+		return "", fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	for _, h := range hooks {
+		// GitHub and Gitea keep the delivery URL in config; GitHub's url is the hook's API URL
+		u := h.Config.Url
+		if u == "" {
+			u = h.Url
+		}
+		if u == hookUrl {
+			id := strconv.FormatInt(h.Id, 10)
+			if err := a.do(a.update, a.hooksUrl+"/"+id, body, nil); err != nil {
+				return "", fmt.Errorf("failed to update webhook %s: %w", id, err)
+			}
+			return id, nil
+		}
+	}
+	var created struct {
+		Id int64 `json:"id"`
+	}
+	if err := a.do(http.MethodPost, a.hooksUrl, body, &created); err != nil {
+		return "", fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return strconv.FormatInt(created.Id, 10), nil
+}
+
+// remove deletes a hook, ignoring one that no longer exists.
+func (a hookApi) remove(hookId string) error {
+	err := a.do(http.MethodDelete, a.hooksUrl+"/"+hookId, nil, nil)
+	var status *statusError
+	if errors.As(err, &status) && status.code == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.code, e.body)
+}
+
+func (a hookApi) do(method, url string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(url, "?"), reader)
+	if err != nil {
+		return err
+	}
+	for key, values := range a.header {
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &statusError{code: resp.StatusCode, body: strings.TrimSpace(string(data))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
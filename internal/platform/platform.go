@@ -0,0 +1,198 @@
+// Package platform adapts the code hosting platforms (GitLab, Codeup, GitHub,
+// Gitea) behind one interface. Each adapter registers itself by name, the
+// WebhookType a project is registered with, and the API server and runners
+// dispatch through the registry instead of switching on the name.
+package platform
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"neutron/internal/model"
+	"neutron/internal/parser"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrPing is returned by ParseWebhook for a platform's connectivity check
+// (GitHub's ping event); the webhook should be acknowledged and ignored.
+var ErrPing = errors.New("ping event")
+
+// Hook is the platform-agnostic result of parsing a webhook.
+type Hook struct {
+	Base         *parser.Base // reads files of the pushed repository
+	Trigger      string       // PUSH, TAG or MR
+	CodeSha      string       // commit to check out
+	ReportSha    string       // commit to report statuses on
+	TargetBranch string       // MR target branch
+	Ref          string       // pushed ref, e.g. refs/heads/main; empty for MR
+	MrIid        int          // MR / pull request number
+	ProjectId    int          // project id on the platform
+	TriggeredBy  string       // account that caused the event
+}
+
+// RunnerEnv is what a runner knows about its job, from the pod environment
+// set by the launcher.
+type RunnerEnv struct {
+	CodebaseUrl   string
+	Token         string
+	RepoUrl       string
+	ProjectId     string
+	ReportSha     string
+	PipelineUrl   string
+	SkipTLSVerify bool
+}
+
+// RunnerEnvFromOS reads the RunnerEnv of the current pod. A job that keeps
+// its token out of the step env passes it in the file CODEBASE_TOKEN_FILE
+// instead, readable until the runner deletes it.
+func RunnerEnvFromOS() RunnerEnv {
+	env := RunnerEnv{
+		CodebaseUrl:   os.Getenv("CODEBASE_URL"),
+		Token:         os.Getenv("CODEBASE_TOKEN"),
+		RepoUrl:       os.Getenv("GIT_REPO_URL"),
+		ProjectId:     os.Getenv("PROJECT_ID"),
+		ReportSha:     os.Getenv("REPORT_SHA"),
+		PipelineUrl:   os.Getenv("PIPELINE_URL"),
+		SkipTLSVerify: strings.EqualFold(os.Getenv("SKIP_TLS_VERIFY"), "true"),
+	}
+	if file := os.Getenv("CODEBASE_TOKEN_FILE"); file != "" && env.Token == "" {
+		if data, err := os.ReadFile(file); err == nil {
+			env.Token = strings.TrimSpace(string(data))
+		}
+	}
+	return env
+}
+
+// EnvName turns a platform or codebase instance name into the form used in
+// environment variable names: upper case, with - and . as _.
+func EnvName(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// Override returns e with the fields set by the <prefix>CODEBASE_URL,
+// <prefix>CODEBASE_TOKEN, <prefix>GIT_REPO_URL, <prefix>PROJECT_ID and
+// <prefix>SKIP_TLS_VERIFY variables, for reporting to a platform or codebase
+// instance other than the job's.
+func (e RunnerEnv) Override(prefix string) RunnerEnv {
+	for key, field := range map[string]*string{
+		"CODEBASE_URL":   &e.CodebaseUrl,
+		"CODEBASE_TOKEN": &e.Token,
+		"GIT_REPO_URL":   &e.RepoUrl,
+		"PROJECT_ID":     &e.ProjectId,
+	} {
+		if v := os.Getenv(prefix + key); v != "" {
+			*field = v
+		}
+	}
+	if v := os.Getenv(prefix + "SKIP_TLS_VERIFY"); v != "" {
+		e.SkipTLSVerify = strings.EqualFold(v, "true")
+	}
+	return e
+}
+
+// Platform is a code hosting platform.
+type Platform interface {
+	// Name is the webhook type projects are registered with, e.g. "GitLab".
+	Name() string
+	// ParseWebhook parses and authenticates an incoming webhook.
+	ParseWebhook(header http.Header, body io.ReadCloser, cb model.CodeBase) (*Hook, error)
+	// NewBase returns a reader of the files of a repository.
+	NewBase(repoUrl string, cb model.CodeBase) (*parser.Base, error)
+	// SourceUrl links to the branch, tag or MR that triggered a job; empty for
+	// other triggers.
+	SourceUrl(trigger, codebaseUrl, repoUrl, ref, codeSha string, mrIid int) string
+	// NewReporter returns the runner's commit status reporter.
+	NewReporter(env RunnerEnv) (model.Reporter, error)
+	// CloneUrl is the URL the checkout container clones a repository from.
+	CloneUrl(repoUrl string) string
+}
+
+var registry = map[string]Platform{}
+
+// Register adds a platform to the registry; adapters call it from init.
+func Register(p Platform) {
+	key := strings.ToLower(p.Name())
+	if _, ok := registry[key]; ok {
+		panic(fmt.Sprintf("platform %s registered twice", p.Name()))
+	}
+	registry[key] = p
+}
+
+// Get returns the platform registered under name, case-insensitively (runners
+// see the lowercased RUNNER_PLATFORM).
+func Get(name string) (Platform, error) {
+	p, ok := registry[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported platform: %s", name)
+	}
+	return p, nil
+}
+
+// Names returns the names of the registered platforms, sorted.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for _, p := range registry {
+		names = append(names, p.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewBase returns a reader of the files of a repository on the named platform.
+func NewBase(name, repoUrl string, cb model.CodeBase) (*parser.Base, error) {
+	p, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.NewBase(repoUrl, cb)
+}
+
+// ValidateRepoUrl checks that repoUrl names a repository the platform's API
+// can address (group/project on GitLab, org/project on Codeup, owner/repo on
+// GitHub and Gitea).
+func ValidateRepoUrl(name, repoUrl string) error {
+	if _, err := NewBase(name, repoUrl, model.CodeBase{}); err != nil {
+		return err
+	}
+	// The path extractors accept any path; every platform nests repositories under a namespace
+	if !strings.Contains(parser.ExtractGitLabProjectPath(repoUrl), "/") {
+		return fmt.Errorf("no namespace in repository path of %s", repoUrl)
+	}
+	return nil
+}
+
+// FetchPipeline fetches neutron.yaml from a repository at the given ref and
+// resolves its include/extends directives.
+func FetchPipeline(name, repoUrl, ref string, cb model.CodeBase, projects parser.ProjectFetcher) (model.Pipeline, error) {
+	base, err := NewBase(name, repoUrl, cb)
+	if err != nil {
+		return model.Pipeline{}, err
+	}
+	base.CodeSha = ref
+	return base.Parse(projects)
+}
+
+// BuildSourceUrl constructs the URL to the source branch or MR on the code
+// hosting platform. It returns empty string for unsupported platforms and
+// triggers (e.g., "API").
+func BuildSourceUrl(name, trigger, codebaseUrl, repoUrl, ref, codeSha string, mrIid int) string {
+	p, err := Get(name)
+	if err != nil {
+		return ""
+	}
+	return p.SourceUrl(trigger, codebaseUrl, repoUrl, ref, codeSha, mrIid)
+}
+
+func newClient(timeout time.Duration, skipTLSVerify bool) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: skipTLSVerify},
+		},
+	}
+}
@@ -0,0 +1,167 @@
+package platform
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"neutron/internal/model"
+)
+
+func TestRegistry(t *testing.T) {
+	if got := Names(); !slices.Equal(got, []string{"Codeup", "GitHub", "GitLab", "Gitea"}) {
+		t.Errorf("Names() = %v", got)
+	}
+	if p, err := Get("gitlab"); err != nil || p.Name() != "GitLab" {
+		t.Errorf("Get(gitlab) = %v, %v; runners look platforms up lowercased", p, err)
+	}
+	if _, err := Get("svn"); err == nil {
+		t.Errorf("Get(svn) succeeded")
+	}
+}
+
+// TestNewReporter checks each platform's commit status request against a
+// stub server: endpoint, auth header and state mapping.
+func TestNewReporter(t *testing.T) {
+	tests := []struct {
+		platform  string
+		repoUrl   string
+		wantPath  string
+		wantAuth  [2]string
+		wantState string
+		urlKey    string
+	}{
+		{"GitLab", "git@h:group/app.git", "/api/v4/projects/7/statuses/c0ffee", [2]string{"PRIVATE-TOKEN", "tok"}, "failed", "target_url"},
+		{"Codeup", "ssh://git@h:9022/org/group/app.git", "/oapi/v1/codeup/organizations/org/repositories/org%252Fgroup%252Fapp/commits/c0ffee/statuses", [2]string{"x-yunxiao-token", "tok"}, "failure", "targetUrl"},
+		{"GitHub", "git@h:owner/app.git", "/api/v3/repos/owner/app/statuses/c0ffee", [2]string{"Authorization", "Bearer tok"}, "failure", "target_url"},
+		{"Gitea", "git@h:owner/app.git", "/api/v1/repos/owner/app/statuses/c0ffee", [2]string{"Authorization", "token tok"}, "failure", "target_url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			var gotPath, gotAuth string
+			var body map[string]string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.EscapedPath()
+				gotAuth = r.Header.Get(tt.wantAuth[0])
+				_ = json.NewDecoder(r.Body).Decode(&body)
+			}))
+			defer srv.Close()
+
+			p, err := Get(tt.platform)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := p.NewReporter(RunnerEnv{
+				CodebaseUrl: srv.URL, Token: "tok", RepoUrl: tt.repoUrl, ProjectId: "7",
+				ReportSha: "c0ffee", PipelineUrl: "http://neutron/#/status/job-1",
+			})
+			if err != nil {
+				t.Fatalf("NewReporter() error = %v", err)
+			}
+			r.Report("build", "test", model.Fail, "exit status 1")
+			if gotPath != tt.wantPath || gotAuth != tt.wantAuth[1] {
+				t.Errorf("request %s with %s=%q", gotPath, tt.wantAuth[0], gotAuth)
+			}
+			if body["state"] != tt.wantState || body["context"] != "build/test" || body[tt.urlKey] != "http://neutron/#/status/job-1" {
+				t.Errorf("body = %v", body)
+			}
+		})
+	}
+}
+
+// TestInstallHook checks that installing a hook updates the one already
+// delivering to Neutron and otherwise creates one, then tests it where the
+// platform can, on each platform's API.
+func TestInstallHook(t *testing.T) {
+	tests := []struct {
+		platform string
+		repoUrl  string
+		hooks    string
+		update   string
+		existing string
+		test     string // path testing the hook, after its id
+	}{
+		{"GitLab", "git@h:group/app.git", "/api/v4/projects/group%2Fapp/hooks", http.MethodPut, `[{"id":3,"url":"%s"}]`, "/test/push_events"},
+		{"Codeup", "ssh://git@h:9022/org/group/app.git", "/oapi/v1/codeup/organizations/org/repositories/org%252Fgroup%252Fapp/webhooks", http.MethodPut, `[{"id":3,"url":"%s"}]`, ""},
+		{"GitHub", "git@h:owner/app.git", "/api/v3/repos/owner/app/hooks", http.MethodPatch, `[{"id":3,"url":"https://api/hooks/3","config":{"url":"%s"}}]`, "/pings"},
+		{"Gitea", "git@h:owner/app.git", "/api/v1/repos/owner/app/hooks", http.MethodPatch, `[{"id":3,"config":{"url":"%s"}}]`, "/tests"},
+	}
+	const hookUrl = "http://neutron/webhook/p1"
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			for _, installed := range []bool{false, true} {
+				var calls []string
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					calls = append(calls, r.Method+" "+r.URL.EscapedPath())
+					switch {
+					case r.Method == http.MethodGet && installed:
+						fmt.Fprintf(w, tt.existing, hookUrl)
+					case r.Method == http.MethodGet:
+						fmt.Fprint(w, `[{"id":1,"url":"http://other","config":{"url":"http://other"}}]`)
+					case r.Method == http.MethodPost && tt.test != "" && strings.HasSuffix(r.URL.Path, tt.test):
+						w.WriteHeader(http.StatusNoContent)
+					default:
+						fmt.Fprint(w, `{"id":9}`)
+					}
+				}))
+				installer, err := Installer(tt.platform)
+				if err != nil {
+					t.Fatal(err)
+				}
+				id, err := installer.InstallHook(tt.repoUrl, model.CodeBase{Url: srv.URL, Token: "tok"}, hookUrl, "s3cret")
+				srv.Close()
+				want := []string{"GET " + tt.hooks, "POST " + tt.hooks}
+				wantId := "9"
+				if installed {
+					want[1], wantId = tt.update+" "+tt.hooks+"/3", "3"
+				}
+				if tt.test != "" {
+					want = append(want, "POST "+tt.hooks+"/"+wantId+tt.test)
+				}
+				if err != nil || id != wantId || !slices.Equal(calls, want) {
+					t.Errorf("installed=%v: InstallHook = %q, %v; calls %v, want %v", installed, id, err, calls, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	if err := checkToken("", ""); err != nil {
+		t.Errorf("no secret configured: %v", err)
+	}
+	if err := checkToken("s3cret", "s3cret"); err != nil {
+		t.Errorf("matching token: %v", err)
+	}
+	if err := checkToken("wrong", "s3cret"); err == nil {
+		t.Error("wrong token accepted")
+	}
+}
+
+func TestHttpsClone(t *testing.T) {
+	tests := []struct {
+		platform, url, repoUrl, wantUrl, wantUser string
+	}{
+		{"GitLab", "https://gitlab.example.com/", "git@gitlab.example.com:group/sub/app.git", "https://gitlab.example.com/group/sub/app.git", "oauth2"},
+		{"GitHub", "https://api.github.com", "git@github.com:octo/app.git", "https://github.com/octo/app.git", "x-access-token"},
+		{"GitHub", "https://ghe.example.com/api/v3", "git@ghe.example.com:octo/app.git", "https://ghe.example.com/octo/app.git", "x-access-token"},
+		{"Gitea", "https://gitea.example.com", "ssh://git@gitea.example.com:2222/octo/app.git", "https://gitea.example.com/octo/app.git", "tok"},
+	}
+	for _, tt := range tests {
+		cloner, err := Cloner(tt.platform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cloneUrl, user, password, err := cloner.HttpsClone(tt.repoUrl, model.CodeBase{Url: tt.url, Token: "tok"})
+		if err != nil || cloneUrl != tt.wantUrl || user != tt.wantUser || (password != "tok" && user != "tok") {
+			t.Errorf("%s HttpsClone(%s) = %q, %q, %q, %v", tt.platform, tt.repoUrl, cloneUrl, user, password, err)
+		}
+	}
+	if _, err := Cloner("Codeup"); err == nil {
+		t.Error("Codeup should not support HTTPS checkout")
+	}
+}
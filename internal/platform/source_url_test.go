@@ -1,34 +1,9 @@
-package parser
+package platform
 
 import (
 	"testing"
 )
 
-func TestExtractRefName(t *testing.T) {
-	tests := []struct {
-		name string
-		ref  string
-		want string
-	}{
-		{"push main", "refs/heads/main", "main"},
-		{"push feature branch", "refs/heads/feature/add-auth", "feature/add-auth"},
-		{"push with slash", "refs/heads/bugfix/JIRA-123-fix", "bugfix/JIRA-123-fix"},
-		{"tag simple", "refs/tags/v1.0.0", "v1.0.0"},
-		{"tag with dots", "refs/tags/release-2024.06.15", "release-2024.06.15"},
-		{"plain ref", "abc123", "abc123"},
-		{"empty", "", ""},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := ExtractRefName(tt.ref)
-			if got != tt.want {
-				t.Errorf("ExtractRefName(%q) = %q, want %q", tt.ref, got, tt.want)
-			}
-		})
-	}
-}
-
 func TestBuildSourceUrl_GitLab(t *testing.T) {
 	const codebaseUrl = "https://gitlab.example.com"
 
@@ -216,6 +191,59 @@ func TestBuildSourceUrl_Codeup(t *testing.T) {
 	}
 }
 
+func TestBuildSourceUrl_GitHub(t *testing.T) {
+	const codebaseUrl = "https://github.example.com"
+	const repoUrl = "git@github.example.com:owner/repo.git"
+
+	tests := []struct {
+		name    string
+		trigger string
+		ref     string
+		mrIid   int
+		want    string
+	}{
+		{"GitHub MR", "MR", "", 12, "https://github.example.com/owner/repo/pull/12"},
+		{"GitHub PUSH", "PUSH", "refs/heads/feature/login", 0, "https://github.example.com/owner/repo/tree/feature/login"},
+		{"GitHub TAG", "TAG", "refs/tags/v1.2.0", 0, "https://github.example.com/owner/repo/releases/tag/v1.2.0"},
+		{"GitHub unsupported trigger", "API", "refs/heads/main", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildSourceUrl("GitHub", tt.trigger, codebaseUrl, repoUrl, tt.ref, "abc123", tt.mrIid)
+			if got != tt.want {
+				t.Errorf("BuildSourceUrl() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSourceUrl_Gitea(t *testing.T) {
+	const codebaseUrl = "https://gitea.example.com"
+	const repoUrl = "ssh://git@gitea.example.com:2222/owner/repo.git"
+
+	tests := []struct {
+		name    string
+		trigger string
+		ref     string
+		mrIid   int
+		want    string
+	}{
+		{"Gitea MR", "MR", "", 3, "https://gitea.example.com/owner/repo/pulls/3"},
+		{"Gitea PUSH", "PUSH", "refs/heads/main", 0, "https://gitea.example.com/owner/repo/src/branch/main"},
+		{"Gitea TAG", "TAG", "refs/tags/v0.1.0", 0, "https://gitea.example.com/owner/repo/src/tag/v0.1.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildSourceUrl("Gitea", tt.trigger, codebaseUrl, repoUrl, tt.ref, "abc123", tt.mrIid)
+			if got != tt.want {
+				t.Errorf("BuildSourceUrl() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestBuildSourceUrl_EdgeCases(t *testing.T) {
 	tests := []struct {
 		name      string
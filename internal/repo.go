@@ -9,13 +9,30 @@ import (
 
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
+
 type PipelineProject struct {
-	Id          string `gorm:"column:id;primaryKey"`
-	WebhookType string `gorm:"column:webhook_type"`
-	RepoUrl     string `gorm:"column:repo_url"`
+	Id            string `gorm:"column:id;primaryKey"`
+	WebhookType   string `gorm:"column:webhook_type"`
+	RepoUrl       string `gorm:"column:repo_url"`
+	CodebaseId    string `gorm:"column:codebase_id;type:varchar(64)"` // codebase instance; empty uses the platform's default
+	Name          string `gorm:"column:name;type:varchar(255)"`       // display name; the repository name when empty
+	Description   string `gorm:"column:description;type:text"`
+	Owners        string `gorm:"column:owners;type:text"`                  // JSON-encoded []string
+	Notify        string `gorm:"column:notify;type:text"`                  // JSON-encoded model.Notify for jobs that declare none
+	Paused        bool   `gorm:"column:paused;default:false"`              // webhooks are accepted but start no jobs
+	Namespace     string `gorm:"column:namespace;type:varchar(63)"`        // K8s namespace of its jobs; kubernetes.namespace when empty
+	Resources     string `gorm:"column:resources;type:text"`               // JSON-encoded model.Resources for jobs that declare none
+	Token         string `gorm:"column:token;type:text" json:"-"`          // encrypted codebase token; empty uses the instance's
+	TokenFile     bool   `gorm:"column:token_file;default:false"`          // keep the token out of the step env
+	HookId        string `gorm:"column:hook_id;type:varchar(64)"`          // webhook installed on the platform; empty when added by hand
+	WebhookSecret string `gorm:"column:webhook_secret;type:text" json:"-"` // encrypted secret of the installed hook; empty uses the instance's
+	CloneProtocol string `gorm:"column:clone_protocol;type:varchar(8)"`    // https clones with the codebase token; ssh when empty
+	KnownHosts    string `gorm:"column:known_hosts;type:varchar(253)"`     // Secret whose known_hosts pins SSH host keys; kubernetes.known-hosts-secret when empty
+	HasToken      bool   `gorm:"-"`                                        // set when listing projects
 }
 
 func (PipelineProject) TableName() string {
@@ -23,21 +40,36 @@ func (PipelineProject) TableName() string {
 }
 
 type PipelineJob struct {
-	Id          int64         `gorm:"column:id;primaryKey;autoIncrement"`
-	ProjectId   string        `gorm:"column:project_id"`
-	Name        string        `gorm:"column:name;type:varchar(255);uniqueIndex"`
-	Status      string        `gorm:"column:status;type:text"`
-	Notify      string        `gorm:"column:notify;type:text"` // JSON-encoded model.Notify, captured at trigger time
-	Spec        string        `gorm:"column:spec;type:text"`   // JSON-encoded model.JobSpec for rerun; empty for API-triggered jobs
-	Completed   bool          `gorm:"column:completed;default:false"`
-	CompletedAt *time.Time    `gorm:"column:completed_at"`
-	Pods        []PipelinePod `gorm:"foreignKey:JobId"`
+	Id               int64         `gorm:"column:id;primaryKey;autoIncrement"`
+	ProjectId        string        `gorm:"column:project_id"`
+	Name             string        `gorm:"column:name;type:varchar(255);uniqueIndex"`
+	Status           string        `gorm:"column:status;type:text"`
+	Notify           string        `gorm:"column:notify;type:text"`                          // JSON-encoded model.Notify, captured at trigger time
+	Spec             string        `gorm:"column:spec;type:text"`                            // JSON-encoded model.JobSpec for rerun; empty for API-triggered jobs
+	State            string        `gorm:"column:state;type:varchar(32);default:''"`         // JobState*; empty once the K8s Job has been created
+	ConcurrencyGroup string        `gorm:"column:concurrency_group;type:varchar(255);index"` // neutron.yaml concurrency group, scoped to the project
+	Priority         int           `gorm:"column:priority;default:0"`                        // queue priority; higher runs first
+	Namespace        string        `gorm:"column:namespace;type:varchar(63)"`                // K8s namespace; kubernetes.namespace when empty
+	ApprovedBy       string        `gorm:"column:approved_by;type:varchar(100)"`
+	ApprovedAt       *time.Time    `gorm:"column:approved_at"`
+	Completed        bool          `gorm:"column:completed;default:false"`
+	CompletedAt      *time.Time    `gorm:"column:completed_at"`
+	Pods             []PipelinePod `gorm:"foreignKey:JobId"`
 }
 
 func (PipelineJob) TableName() string {
 	return "neutron_job"
 }
 
+// Job states for rows whose K8s Job has not been created yet, or was
+// cancelled. A launched job has an empty state and is tracked through its K8s
+// status instead.
+const (
+	JobStateWaitingApproval = "WaitingApproval"
+	JobStateQueued          = "Queued"
+	JobStateCanceled        = "Canceled"
+)
+
 type PipelinePod struct {
 	Id     int64  `gorm:"column:id;primaryKey;autoIncrement"`
 	JobId  int64  `gorm:"column:job_id;index"`
@@ -68,6 +100,8 @@ type Snippet struct {
 	Content     string     `gorm:"column:content;type:text" json:"content"`
 	Description string     `gorm:"column:description;type:text" json:"description"`
 	Params      string     `gorm:"column:params;type:text" json:"params"`
+	Lang        string     `gorm:"column:lang;type:varchar(20)" json:"lang"` // shell the content is written for: sh (default), bash or pwsh
+	Rev         int        `gorm:"column:rev;default:0" json:"rev"`          // current revision
 	CreatedAt   *time.Time `gorm:"column:created_at" json:"created_at"`
 	UpdatedAt   *time.Time `gorm:"column:updated_at" json:"updated_at"`
 }
@@ -76,6 +110,76 @@ func (Snippet) TableName() string {
 	return "neutron_snippet"
 }
 
+// SnippetRevision is an immutable copy of a snippet's content, params and lang,
+// written on every change so pipelines can pin a revision and a bad edit can
+// be rolled back.
+type SnippetRevision struct {
+	Id          int64      `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	SnippetName string     `gorm:"column:snippet_name;type:varchar(255);uniqueIndex:idx_snippet_rev" json:"snippet_name"`
+	Rev         int        `gorm:"column:rev;uniqueIndex:idx_snippet_rev" json:"rev"`
+	Content     string     `gorm:"column:content;type:text" json:"content"`
+	Params      string     `gorm:"column:params;type:text" json:"params"`
+	Lang        string     `gorm:"column:lang;type:varchar(20)" json:"lang"`
+	Author      string     `gorm:"column:author;type:varchar(255)" json:"author"`
+	CreatedAt   *time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (SnippetRevision) TableName() string {
+	return "neutron_snippet_revision"
+}
+
+// SnippetUsage records one use of a snippet: a fetch of /s/:name, or a job
+// created with a `uses:` step. JobName and ProjectId are set when the caller
+// could be traced to a Neutron job.
+type SnippetUsage struct {
+	Id          int64      `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	SnippetName string     `gorm:"column:snippet_name;type:varchar(255);index:idx_snippet_usage" json:"snippet_name"`
+	Rev         int        `gorm:"column:rev" json:"rev"`
+	Source      string     `gorm:"column:source;type:varchar(20)" json:"source"` // fetch or uses
+	CallerIp    string     `gorm:"column:caller_ip;type:varchar(64)" json:"caller_ip"`
+	UserAgent   string     `gorm:"column:user_agent;type:varchar(255)" json:"user_agent"`
+	JobName     string     `gorm:"column:job_name;type:varchar(255)" json:"job_name"`
+	ProjectId   string     `gorm:"column:project_id;type:char(36)" json:"project_id"`
+	CreatedAt   *time.Time `gorm:"column:created_at;index:idx_snippet_usage" json:"created_at"`
+}
+
+func (SnippetUsage) TableName() string {
+	return "neutron_snippet_usage"
+}
+
+// Snippet usage sources.
+const (
+	SnippetUsageFetch = "fetch"
+	SnippetUsageUses  = "uses"
+)
+
+// SnippetProjectUsage aggregates a snippet's usage by one project.
+type SnippetProjectUsage struct {
+	ProjectId  string     `json:"project_id"`
+	RepoUrl    string     `json:"repo_url"`
+	Count      int64      `json:"count"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// Deployment records one successful run of a job that declares an
+// environment, answering "which commit is in staging right now?".
+type Deployment struct {
+	Id          int64      `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	ProjectId   string     `gorm:"column:project_id;type:char(36);index:idx_project_env" json:"project_id"`
+	Environment string     `gorm:"column:environment;type:varchar(100);index:idx_project_env" json:"environment"`
+	Url         string     `gorm:"column:url;type:varchar(2048)" json:"url"`
+	JobName     string     `gorm:"column:job_name;type:varchar(255);uniqueIndex" json:"job_name"` // K8s Job name
+	PipelineJob string     `gorm:"column:pipeline_job;type:varchar(255)" json:"pipeline_job"`     // neutron.yaml job key
+	CommitSha   string     `gorm:"column:commit_sha;type:varchar(64)" json:"commit_sha"`
+	Ref         string     `gorm:"column:ref;type:varchar(255)" json:"ref"`
+	TriggeredBy string     `gorm:"column:triggered_by;type:varchar(100)" json:"triggered_by"`
+	CreatedAt   *time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (Deployment) TableName() string {
+	return "neutron_deployment"
+}
+
 type JobStatus struct {
 	WebhookType string `json:"webhook_type"`
 	RepoUrl     string `json:"repo_url"`
@@ -99,15 +203,56 @@ func NewRepository(config model.Config) *Repository {
 	if err != nil {
 		log.Fatalf("cannot connect to database: %v", err)
 	}
-
-	// Auto-migrate tables
-	if err := db.AutoMigrate(&PipelineProject{}, &PipelineJob{}, &PipelinePod{}, &JobReport{}, &Snippet{}); err != nil {
+	repo, err := OpenRepository(db)
+	if err != nil {
 		log.Fatalf("failed to auto-migrate database: %v", err)
 	}
+	return repo
+}
 
+// OpenRepository migrates the tables of an open database and returns a
+// repository on it.
+func OpenRepository(db *gorm.DB) (*Repository, error) {
+	// Auto-migrate tables
+	if err := db.AutoMigrate(&PipelineProject{}, &PipelineJob{}, &PipelinePod{}, &JobReport{}, &Snippet{}, &SnippetRevision{}, &SnippetUsage{}, &Deployment{}); err != nil {
+		return nil, err
+	}
+	if err := backfillSnippetRevisions(db); err != nil {
+		return nil, err
+	}
 	return &Repository{
 		db: db,
+	}, nil
+}
+
+// backfillSnippetRevisions records snippets created before revisions existed
+// as their revision 1, so every snippet can be pinned, diffed and rolled back.
+func backfillSnippetRevisions(db *gorm.DB) error {
+	var snippets []Snippet
+	if err := db.Where("rev = ?", 0).Find(&snippets).Error; err != nil {
+		return err
 	}
+	for _, snippet := range snippets {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			now := time.Now()
+			// another replica may be migrating the same snippet
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&SnippetRevision{
+				SnippetName: snippet.Name,
+				Rev:         1,
+				Content:     snippet.Content,
+				Params:      snippet.Params,
+				Lang:        snippet.Lang,
+				CreatedAt:   &now,
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&Snippet{}).Where("id = ? AND rev = ?", snippet.Id, 0).Update("rev", 1).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (r *Repository) Close() {
@@ -122,6 +267,14 @@ func (r *Repository) DB() *gorm.DB {
 	return r.db
 }
 
+// Transaction runs fn with a repository whose changes are committed together
+// when fn returns nil, and rolled back otherwise.
+func (r *Repository) Transaction(fn func(repo *Repository) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&Repository{db: tx})
+	})
+}
+
 func (r *Repository) GetWebhookConfig(id string) PipelineProject {
 	var project PipelineProject
 	result := r.db.Where("id = ?", id).First(&project)
@@ -135,6 +288,57 @@ func (r *Repository) AddWebhookConfig(p PipelineProject) error {
 	return r.db.Create(&p).Error
 }
 
+// SetProjectToken replaces a project's encrypted codebase token and whether it
+// is kept out of the step env.
+func (r *Repository) SetProjectToken(id string, token string, tokenFile bool) error {
+	return r.db.Model(&PipelineProject{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"token": token, "token_file": tokenFile}).Error
+}
+
+// UpdateProject updates the given columns of a project.
+func (r *Repository) UpdateProject(id string, updates map[string]interface{}) error {
+	return r.db.Model(&PipelineProject{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// SetProjectHook records the id and encrypted secret of the webhook installed
+// for a project.
+func (r *Repository) SetProjectHook(id string, hookId string, webhookSecret string) error {
+	return r.db.Model(&PipelineProject{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"hook_id": hookId, "webhook_secret": webhookSecret}).Error
+}
+
+// DeleteProject deletes a project with its jobs, their pods and report links,
+// and its deployments. It returns the deleted jobs.
+func (r *Repository) DeleteProject(id string) ([]PipelineJob, error) {
+	var jobs []PipelineJob
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("project_id = ?", id).Find(&jobs).Error; err != nil {
+			return err
+		}
+		if len(jobs) > 0 {
+			ids := make([]int64, len(jobs))
+			names := make([]string, len(jobs))
+			for i, job := range jobs {
+				ids[i], names[i] = job.Id, job.Name
+			}
+			if err := tx.Where("job_id IN ?", ids).Delete(&PipelinePod{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("job_name IN ?", names).Delete(&JobReport{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("project_id = ?", id).Delete(&PipelineJob{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("project_id = ?", id).Delete(&Deployment{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&PipelineProject{}).Error
+	})
+	return jobs, err
+}
+
 func (r *Repository) ListProjects() ([]PipelineProject, error) {
 	var projects []PipelineProject
 	err := r.db.Order("id").Find(&projects).Error
@@ -200,6 +404,112 @@ func (r *Repository) GetJobByName(name string) (*PipelineJob, error) {
 	return &job, nil
 }
 
+// ClaimApproval moves a manual job out of WaitingApproval on behalf of
+// approver. It reports false when the job is not waiting (already approved or
+// unknown), so concurrent approvals launch the job only once.
+// The job moves to state: empty when it is launched right away, or Queued.
+func (r *Repository) ClaimApproval(jobName string, approver string, state string) (bool, error) {
+	now := time.Now()
+	result := r.db.Model(&PipelineJob{}).
+		Where("name = ? AND state = ?", jobName, JobStateWaitingApproval).
+		Updates(map[string]interface{}{
+			"state":       state,
+			"approved_by": approver,
+			"approved_at": now,
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// RevertApproval puts a claimed job back into WaitingApproval, used when its
+// K8s Job could not be created after the claim.
+func (r *Repository) RevertApproval(jobName string) error {
+	return r.db.Model(&PipelineJob{}).Where("name = ?", jobName).
+		Updates(map[string]interface{}{
+			"state":       JobStateWaitingApproval,
+			"approved_by": "",
+			"approved_at": nil,
+		}).Error
+}
+
+// --- Job queue ---
+
+func (r *Repository) SetJobState(jobName string, state string) error {
+	return r.db.Model(&PipelineJob{}).Where("name = ?", jobName).Update("state", state).Error
+}
+
+// ListQueuedJobs returns all queued jobs in dispatch order: higher priority
+// first, FIFO within a priority.
+func (r *Repository) ListQueuedJobs() ([]PipelineJob, error) {
+	var jobs []PipelineJob
+	err := r.db.Where("state = ?", JobStateQueued).Order("priority DESC, id").Find(&jobs).Error
+	return jobs, err
+}
+
+// CountQueuedJobs counts queued jobs of at least the given priority.
+func (r *Repository) CountQueuedJobs(priority int) (int64, error) {
+	var n int64
+	err := r.db.Model(&PipelineJob{}).Where("state = ? AND priority >= ?", JobStateQueued, priority).Count(&n).Error
+	return n, err
+}
+
+// ListRunningJobs returns launched jobs that have not completed.
+func (r *Repository) ListRunningJobs() ([]PipelineJob, error) {
+	var jobs []PipelineJob
+	err := r.db.Where("state = ? AND completed = ?", "", false).Find(&jobs).Error
+	return jobs, err
+}
+
+// CountRunningJobs counts launched, not yet completed jobs of a project, or
+// of all projects when projectId is empty.
+func (r *Repository) CountRunningJobs(projectId string) (int64, error) {
+	var n int64
+	q := r.db.Model(&PipelineJob{}).Where("state = ? AND completed = ?", "", false)
+	if projectId != "" {
+		q = q.Where("project_id = ?", projectId)
+	}
+	err := q.Count(&n).Error
+	return n, err
+}
+
+// ListGroupJobs returns the jobs of a project's concurrency group in the given
+// state that have not completed. An empty state selects launched jobs.
+func (r *Repository) ListGroupJobs(projectId string, group string, state string) ([]PipelineJob, error) {
+	var jobs []PipelineJob
+	err := r.db.Where("project_id = ? AND concurrency_group = ? AND state = ? AND completed = ?", projectId, group, state, false).
+		Order("id").Find(&jobs).Error
+	return jobs, err
+}
+
+// CountRunningInGroup counts launched, not yet completed jobs of a project's
+// concurrency group.
+func (r *Repository) CountRunningInGroup(projectId string, group string) (int64, error) {
+	var n int64
+	err := r.db.Model(&PipelineJob{}).
+		Where("project_id = ? AND concurrency_group = ? AND state = ? AND completed = ?", projectId, group, "", false).
+		Count(&n).Error
+	return n, err
+}
+
+// QueuePosition returns the 1-based position of a queued job in dispatch order.
+func (r *Repository) QueuePosition(job PipelineJob) (int64, error) {
+	var ahead int64
+	err := r.db.Model(&PipelineJob{}).
+		Where("state = ? AND (priority > ? OR (priority = ? AND id < ?))", JobStateQueued, job.Priority, job.Priority, job.Id).
+		Count(&ahead).Error
+	return ahead + 1, err
+}
+
+// MarkJobCanceled records a job as cancelled and completed.
+func (r *Repository) MarkJobCanceled(jobName string) error {
+	now := time.Now()
+	return r.db.Model(&PipelineJob{}).Where("name = ?", jobName).
+		Updates(map[string]interface{}{
+			"state":        JobStateCanceled,
+			"completed":    true,
+			"completed_at": now,
+		}).Error
+}
+
 func (r *Repository) AddPod(pod PipelinePod) error {
 	return r.db.Create(&pod).Error
 }
@@ -260,15 +570,173 @@ func (r *Repository) GetSnippetByName(name string) (*Snippet, error) {
 	return &snippet, nil
 }
 
-func (r *Repository) CreateSnippet(snippet Snippet) error {
-	return r.db.Create(&snippet).Error
+// CreateSnippet creates a snippet together with its first revision.
+func (r *Repository) CreateSnippet(snippet Snippet, author string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		snippet.Rev = 1
+		if err := tx.Create(&snippet).Error; err != nil {
+			return err
+		}
+		return addSnippetRevision(tx, snippet, author)
+	})
 }
 
-func (r *Repository) UpdateSnippet(name string, updates map[string]interface{}) error {
-	updates["updated_at"] = time.Now()
-	return r.db.Model(&Snippet{}).Where("name = ?", name).Updates(updates).Error
+// UpdateSnippet applies updates to a snippet. When content, params or lang
+// change, a new revision is recorded (author is attributed to it) and becomes
+// current.
+func (r *Repository) UpdateSnippet(name string, updates map[string]interface{}, author string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var snippet Snippet
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("name = ?", name).First(&snippet).Error; err != nil {
+			return err
+		}
+		content, params, lang := snippet.Content, snippet.Params, snippet.Lang
+		if v, ok := updates["content"].(string); ok {
+			content = v
+		}
+		if v, ok := updates["params"].(string); ok {
+			params = v
+		}
+		if v, ok := updates["lang"].(string); ok {
+			lang = v
+		}
+		if content != snippet.Content || params != snippet.Params || lang != snippet.Lang {
+			snippet.Rev++
+			snippet.Content, snippet.Params, snippet.Lang = content, params, lang
+			if err := addSnippetRevision(tx, snippet, author); err != nil {
+				return err
+			}
+			updates["rev"] = snippet.Rev
+		}
+		updates["updated_at"] = time.Now()
+		return tx.Model(&Snippet{}).Where("name = ?", name).Updates(updates).Error
+	})
+}
+
+// RollbackSnippet makes an earlier revision current again. History is never
+// rewritten: the restored content is recorded as a new revision.
+func (r *Repository) RollbackSnippet(name string, rev int, author string) (int, error) {
+	target, err := r.GetSnippetRevision(name, rev)
+	if err != nil {
+		return 0, err
+	}
+	updates := map[string]interface{}{"content": target.Content, "params": target.Params, "lang": target.Lang}
+	if err := r.UpdateSnippet(name, updates, author); err != nil {
+		return 0, err
+	}
+	snippet, err := r.GetSnippetByName(name)
+	if err != nil {
+		return 0, err
+	}
+	return snippet.Rev, nil
 }
 
+func addSnippetRevision(tx *gorm.DB, snippet Snippet, author string) error {
+	now := time.Now()
+	return tx.Create(&SnippetRevision{
+		SnippetName: snippet.Name,
+		Rev:         snippet.Rev,
+		Content:     snippet.Content,
+		Params:      snippet.Params,
+		Lang:        snippet.Lang,
+		Author:      author,
+		CreatedAt:   &now,
+	}).Error
+}
+
+// ListSnippetRevisions returns a snippet's revisions, newest first.
+func (r *Repository) ListSnippetRevisions(name string) ([]SnippetRevision, error) {
+	var revisions []SnippetRevision
+	err := r.db.Where("snippet_name = ?", name).Order("rev DESC").Find(&revisions).Error
+	return revisions, err
+}
+
+func (r *Repository) GetSnippetRevision(name string, rev int) (*SnippetRevision, error) {
+	var revision SnippetRevision
+	result := r.db.Where("snippet_name = ? AND rev = ?", name, rev).First(&revision)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &revision, nil
+}
+
+// DeleteSnippet deletes a snippet with its revisions and usage, so the name
+// can be reused.
 func (r *Repository) DeleteSnippet(name string) error {
-	return r.db.Where("name = ?", name).Delete(&Snippet{}).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("snippet_name = ?", name).Delete(&SnippetRevision{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("snippet_name = ?", name).Delete(&SnippetUsage{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("name = ?", name).Delete(&Snippet{}).Error
+	})
+}
+
+// --- Snippet usage ---
+
+func (r *Repository) AddSnippetUsage(u SnippetUsage) error {
+	return r.db.Create(&u).Error
+}
+
+// CountSnippetUsage counts a snippet's uses since the given time.
+func (r *Repository) CountSnippetUsage(name string, since time.Time) (int64, error) {
+	var n int64
+	err := r.db.Model(&SnippetUsage{}).Where("snippet_name = ? AND created_at >= ?", name, since).Count(&n).Error
+	return n, err
+}
+
+// ListSnippetUsageProjects aggregates a snippet's uses since the given time by
+// project, most used first. Uses not traced to a project are left out.
+func (r *Repository) ListSnippetUsageProjects(name string, since time.Time) ([]SnippetProjectUsage, error) {
+	var usage []SnippetProjectUsage
+	err := r.db.Model(&SnippetUsage{}).
+		Select("neutron_snippet_usage.project_id, neutron_project.repo_url, COUNT(*) AS count, MAX(neutron_snippet_usage.created_at) AS last_used_at").
+		Joins("LEFT JOIN neutron_project ON neutron_project.id = neutron_snippet_usage.project_id").
+		Where("neutron_snippet_usage.snippet_name = ? AND neutron_snippet_usage.created_at >= ? AND neutron_snippet_usage.project_id <> ''", name, since).
+		Group("neutron_snippet_usage.project_id, neutron_project.repo_url").
+		Order("count DESC").
+		Scan(&usage).Error
+	return usage, err
+}
+
+// ListSnippetUsage returns a snippet's most recent uses, newest first.
+func (r *Repository) ListSnippetUsage(name string, limit int) ([]SnippetUsage, error) {
+	var usage []SnippetUsage
+	err := r.db.Where("snippet_name = ?", name).Order("id DESC").Limit(limit).Find(&usage).Error
+	return usage, err
+}
+
+// --- Deployment history ---
+
+// AddDeployment records a successful deployment. Recording the same K8s Job
+// twice is a no-op, so callers may report completion more than once.
+func (r *Repository) AddDeployment(d Deployment) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&d).Error
+}
+
+func (r *Repository) GetDeployment(id int64) (*Deployment, error) {
+	var d Deployment
+	result := r.db.Where("id = ?", id).First(&d)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &d, nil
+}
+
+// ListEnvironments returns the latest deployment of each environment of a project.
+func (r *Repository) ListEnvironments(projectId string) ([]Deployment, error) {
+	var deployments []Deployment
+	latest := r.db.Model(&Deployment{}).Select("MAX(id)").Where("project_id = ?", projectId).Group("environment")
+	err := r.db.Where("id IN (?)", latest).Order("environment").Find(&deployments).Error
+	return deployments, err
+}
+
+// ListDeployments returns an environment's deployment history, newest first.
+func (r *Repository) ListDeployments(projectId string, environment string, limit int) ([]Deployment, error) {
+	var deployments []Deployment
+	err := r.db.Where("project_id = ? AND environment = ?", projectId, environment).
+		Order("id DESC").Limit(limit).Find(&deployments).Error
+	return deployments, err
 }
@@ -0,0 +1,72 @@
+package reporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"neutron/internal/model"
+	"strings"
+)
+
+// CommitStatus reports every step as a commit status of the platform, with
+// context "<job>/<step>" and a link to the Neutron status page.
+type CommitStatus struct {
+	Platform       string      // name in log messages
+	Url            string      // statuses endpoint of the reported commit
+	Header         http.Header // auth (and accept) headers
+	States         map[model.StepResult]string
+	DefaultState   string
+	TargetUrlKey   string // JSON key of the link; "target_url" when empty
+	MaxDescription int    // longest accepted description; 0 is unlimited
+	TargetUrl      string
+	Client         *http.Client
+}
+
+func (r *CommitStatus) Report(jobName string, stepName string, status model.StepResult, description string) {
+	state, ok := r.States[status]
+	if !ok {
+		state = r.DefaultState
+	}
+	if r.MaxDescription > 0 && len(description) > r.MaxDescription {
+		description = description[:r.MaxDescription-3] + "..."
+	}
+	targetUrlKey := r.TargetUrlKey
+	if targetUrlKey == "" {
+		targetUrlKey = "target_url"
+	}
+	body, err := json.Marshal(map[string]string{
+		"state":       state,
+		"description": description,
+		"context":     fmt.Sprintf("%s/%s", jobName, stepName),
+		targetUrlKey:  r.TargetUrl,
+	})
+	if err != nil {
+		log.Printf("Warning: failed to marshal status: %v", err)
+		return
+	}
+	req, err := http.NewRequest("POST", r.Url, bytes.NewBuffer(body))
+	if err != nil {
+		log.Printf("Warning: failed to create request: %v", err)
+		return
+	}
+	for key, values := range r.Header {
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	name := strings.ToLower(r.Platform)
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		log.Printf("Warning: failed to report pipeline status to %s: %v", name, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		log.Printf("Warning: %s returned %s for %s/%s", name, resp.Status, jobName, stepName)
+	} else {
+		log.Printf("Pipeline status reported to %s: %s", name, resp.Status)
+	}
+}
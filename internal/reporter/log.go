@@ -0,0 +1,20 @@
+package reporter
+
+import (
+	"fmt"
+	"io"
+	"neutron/internal/model"
+)
+
+// Log writes step statuses to the job log.
+type Log struct {
+	w io.Writer
+}
+
+func NewLog(w io.Writer) *Log {
+	return &Log{w: w}
+}
+
+func (r *Log) Report(jobName string, stepName string, status model.StepResult, description string) {
+	fmt.Fprintf(r.w, "[neutron] %s/%s: %s %s\n", jobName, stepName, status, description)
+}
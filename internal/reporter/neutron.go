@@ -5,8 +5,10 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"neutron/internal/model"
 	"time"
 )
@@ -101,3 +103,39 @@ func (r *Neutron) Report(jobName string, stepName string, status model.StepResul
 		log.Printf("Neutron API returned status %d", resp.StatusCode)
 	}
 }
+
+// ReportLink sets the test report link of the job.
+func (r *Neutron) ReportLink(reportUrl string) error {
+	body, err := json.Marshal(map[string]string{"report_url": reportUrl})
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Post(fmt.Sprintf("%s/api/report/%s/link", r.apiUrl, r.jobName), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to report link to Neutron API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("neutron API returned status %d for report link", resp.StatusCode)
+	}
+	return nil
+}
+
+// UploadArtifact stores content as the artifact name (a slash-separated
+// relative path) of the job.
+func (r *Neutron) UploadArtifact(name string, content io.Reader) error {
+	u := fmt.Sprintf("%s/api/report/%s/artifacts?name=%s", r.apiUrl, r.jobName, url.QueryEscape(name))
+	// Artifacts may be large; do not cut the upload off at the status timeout
+	client := *r.client
+	client.Timeout = 0
+	resp, err := client.Post(u, "application/octet-stream", content)
+	if err != nil {
+		return fmt.Errorf("failed to upload artifact to Neutron API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("neutron API returned status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Package secret encrypts credentials kept in the database (per-project
+// codebase tokens) with AES-256-GCM, under a key derived from the config salt.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// prefix marks an encrypted value and its format version.
+const prefix = "enc:v1:"
+
+// ErrNoKey is returned when no salt is configured to derive the key from.
+var ErrNoKey = errors.New("salt is not configured")
+
+func newAEAD(salt string) (cipher.AEAD, error) {
+	if salt == "" {
+		return nil, ErrNoKey
+	}
+	key := sha256.Sum256([]byte(salt))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt returns plaintext sealed under the salt, as "enc:v1:" followed by the
+// base64 of the nonce and ciphertext. An empty plaintext stays empty.
+func Encrypt(salt, plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	aead, err := newAEAD(salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value returned by Encrypt. It fails when the salt has
+// changed since the value was encrypted.
+func Decrypt(salt, ciphertext string) (string, error) {
+	if ciphertext == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(ciphertext, prefix) {
+		return "", errors.New("value is not encrypted")
+	}
+	aead, err := newAEAD(salt)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, prefix))
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted value is truncated")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt value (salt changed?)")
+	}
+	return string(plain), nil
+}
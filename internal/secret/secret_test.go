@@ -0,0 +1,40 @@
+package secret
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	enc, err := Encrypt("salt", "glpat-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enc, prefix) || strings.Contains(enc, "glpat-token") {
+		t.Fatalf("unexpected ciphertext %q", enc)
+	}
+	again, _ := Encrypt("salt", "glpat-token")
+	if again == enc {
+		t.Error("encrypting twice should use fresh nonces")
+	}
+	plain, err := Decrypt("salt", enc)
+	if err != nil || plain != "glpat-token" {
+		t.Fatalf("Decrypt = %q, %v", plain, err)
+	}
+	if _, err := Decrypt("other", enc); err == nil {
+		t.Error("decrypting with another salt should fail")
+	}
+}
+
+func TestEmptyValuesAndSalt(t *testing.T) {
+	if enc, err := Encrypt("", ""); enc != "" || err != nil {
+		t.Errorf("Encrypt of empty = %q, %v", enc, err)
+	}
+	if _, err := Encrypt("", "token"); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Encrypt without salt = %v, want ErrNoKey", err)
+	}
+	if _, err := Decrypt("salt", "token"); err == nil {
+		t.Error("decrypting a plain value should fail")
+	}
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"neutron/internal/model"
+	"neutron/internal/platform"
+	"neutron/internal/reporter"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Reporter names of RUNNER_REPORTERS besides the platform names.
+const (
+	ReporterNeutron  = "neutron"  // job status on the Neutron API
+	ReporterLog      = "log"      // step statuses on stdout
+	ReporterPlatform = "platform" // commit statuses of RUNNER_PLATFORM
+)
+
+// ReporterNames returns the reporters of the job: RUNNER_REPORTERS, comma
+// separated, or "neutron,platform" when unset ("neutron" with
+// SKIP_PLATFORM_REPORT).
+func ReporterNames() []string {
+	value := os.Getenv("RUNNER_REPORTERS")
+	if value == "" {
+		if strings.EqualFold(os.Getenv("SKIP_PLATFORM_REPORT"), "true") {
+			return []string{ReporterNeutron}
+		}
+		return []string{ReporterNeutron, ReporterPlatform}
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// newNeutronReporter returns the reporter of the job status on Neutron.
+func newNeutronReporter(env platform.RunnerEnv) *reporter.Neutron {
+	return reporter.NewNeutron(os.Getenv("NEUTRON_API_URL"), os.Getenv("FULL_JOB_NAME"), os.Getenv("TRIGGER"),
+		os.Getenv("RUNNER_PLATFORM"), env.RepoUrl, env.SkipTLSVerify)
+}
+
+// newReporters builds the named reporters except neutron. "platform" reports
+// to RUNNER_PLATFORM; another name reports to a platform, or a codebase
+// instance (the API sets its <NAME>_PLATFORM), with the <NAME>_CODEBASE_URL,
+// _CODEBASE_TOKEN, _GIT_REPO_URL, _PROJECT_ID and _SKIP_TLS_VERIFY variables
+// overriding the job's (e.g. to a mirror of the repository). The token of a
+// job with a CODEBASE_TOKEN_FILE only goes to the job's own codebase: other
+// reporters, and all of them once the file turned out empty, are skipped.
+func newReporters(names []string, env platform.RunnerEnv) ([]model.Reporter, error) {
+	var reporters []model.Reporter
+	for _, name := range names {
+		switch name {
+		case ReporterNeutron:
+			continue
+		case ReporterLog:
+			reporters = append(reporters, reporter.NewLog(os.Stdout))
+			continue
+		}
+		platformEnv := env
+		if name == ReporterPlatform {
+			name = os.Getenv("RUNNER_PLATFORM")
+		} else if prefix := platform.EnvName(name) + "_"; os.Getenv(prefix+"PLATFORM") != "" {
+			platformEnv = env.Override(prefix)
+			name = os.Getenv(prefix + "PLATFORM")
+		} else if !strings.EqualFold(name, os.Getenv("RUNNER_PLATFORM")) {
+			platformEnv = env.Override(prefix)
+		}
+		if os.Getenv("CODEBASE_TOKEN_FILE") != "" && (platformEnv.Token == "" || platformEnv != env) {
+			log.Printf("reporter %s skipped: the codebase token is only available to the job's codebase", name)
+			continue
+		}
+		p, err := platform.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("reporter %s: %w", name, err)
+		}
+		r, err := p.NewReporter(platformEnv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s reporter: %w", p.Name(), err)
+		}
+		reporters = append(reporters, r)
+	}
+	return reporters, nil
+}
+
+// RunFromEnv runs the pipeline job described by the pod environment the
+// launcher sets, reporting step statuses to the RUNNER_REPORTERS. A token
+// file is deleted before the first step, so steps cannot read it.
+func RunFromEnv() {
+	env := platform.RunnerEnvFromOS()
+	if file := os.Getenv("CODEBASE_TOKEN_FILE"); file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			// without the token the job could not report its statuses
+			_ = os.Remove(file)
+			log.Fatalf("cannot read codebase token file: %v", err)
+		}
+		_ = f.Close()
+		if env.Token == "" {
+			log.Printf("codebase token file %s is empty", file)
+		}
+		if err := os.Remove(file); err != nil {
+			log.Fatalf("failed to remove codebase token file: %v", err)
+		}
+	}
+	names := ReporterNames()
+	reporters, err := newReporters(names, env)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range names {
+		if name == ReporterNeutron {
+			neutronReporter := newNeutronReporter(env)
+			neutronReporter.RegisterPod(os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE"))
+			reporters = append(reporters, neutronReporter)
+			break
+		}
+	}
+
+	skipTriggerCheck := strings.EqualFold(os.Getenv("SKIP_TRIGGER_CHECK"), "true")
+	runner := NewRunner("/repo", os.Getenv("TRIGGER"), os.Getenv("JOB_NAME"), reporter.NewComposite(reporters...), skipTriggerCheck)
+	runner.Run()
+}
+
+// ParseStepResult parses the status argument of the report command.
+func ParseStepResult(s string) (model.StepResult, error) {
+	switch strings.ToLower(s) {
+	case "pending":
+		return model.Pending, nil
+	case "running":
+		return model.Running, nil
+	case "success":
+		return model.Success, nil
+	case "failed", "fail":
+		return model.Fail, nil
+	}
+	return "", fmt.Errorf("unknown status %q (pending, running, success or failed)", s)
+}
+
+// ReportFromEnv reports an extra status named name of the current job to the
+// RUNNER_REPORTERS. The Neutron job status is left alone, it follows the
+// steps; linkUrl, when set, becomes the job's test report link instead.
+func ReportFromEnv(name string, status model.StepResult, description string, linkUrl string) error {
+	env := platform.RunnerEnvFromOS()
+	if linkUrl != "" {
+		if err := newNeutronReporter(env).ReportLink(linkUrl); err != nil {
+			return err
+		}
+	}
+	if name == "" {
+		return nil
+	}
+	reporters, err := newReporters(ReporterNames(), env)
+	if err != nil {
+		return err
+	}
+	reporter.NewComposite(reporters...).Report(os.Getenv("JOB_NAME"), name, status, description)
+	return nil
+}
+
+// UploadArtifacts uploads files to the artifacts of the current job on
+// Neutron. A directory is uploaded with every file below it; names are the
+// paths relative to the working directory.
+func UploadArtifacts(paths []string) error {
+	neutron := newNeutronReporter(platform.RunnerEnvFromOS())
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			name := filepath.ToSlash(filepath.Clean(p))
+			if filepath.IsAbs(p) {
+				name = filepath.ToSlash(filepath.Base(p))
+				if root != p {
+					rel, _ := filepath.Rel(filepath.Dir(filepath.Clean(root)), p)
+					name = filepath.ToSlash(rel)
+				}
+			}
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := neutron.UploadArtifact(name, f); err != nil {
+				return fmt.Errorf("uploading %s: %w", p, err)
+			}
+			log.Printf("Uploaded artifact %s", name)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
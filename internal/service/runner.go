@@ -1,6 +1,7 @@
 package service
 
 import (
+	"encoding/json"
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"log"
@@ -18,7 +19,23 @@ type Runner struct {
 	Reporter   model.Reporter
 }
 
+// NewRunner builds the runner of a pipeline job. When the API pinned the job's
+// resolved steps (PIPELINE_STEPS), they are run as-is: the API already matched
+// the trigger, and neutron.yaml alone may lack included or inherited steps.
 func NewRunner(workingDir string, triggerType string, jobName string, reporter model.Reporter, skipTriggerCheck ...bool) *Runner {
+	if pinned := os.Getenv("PIPELINE_STEPS"); pinned != "" {
+		var steps []model.Step
+		if err := json.Unmarshal([]byte(pinned), &steps); err != nil {
+			log.Fatalf("invalid PIPELINE_STEPS: %v", err)
+		}
+		return &Runner{
+			WorkingDir: workingDir,
+			Trigger:    triggerType,
+			JobName:    jobName,
+			Steps:      steps,
+			Reporter:   reporter,
+		}
+	}
 	data, err := os.ReadFile(path.Join(workingDir, "neutron.yaml"))
 	if err != nil {
 		log.Fatal(err)